@@ -0,0 +1,405 @@
+// Copyright 2024 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+// Package alma provides a client for submitting and monitoring
+// jobs using the Alma Jobs API.
+// https://developers.exlibrisgroup.com/blog/Working-with-the-Alma-Jobs-API/
+package alma
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	// DefaultTimeout is the default amount of time to wait on the Alma API for each request.
+	DefaultTimeout = 10 * time.Second
+
+	// DefaultPollInterval is the default amount of time to wait between requests for job instance data.
+	DefaultPollInterval = 30 * time.Second
+
+	// DefaultMaxPolls is the default number of times a job instance is requested
+	// before monitoring stops. With the default poll interval, this is approximately 23 hours.
+	DefaultMaxPolls = 2760
+
+	// DefaultMaxPollFailures is the default number of consecutive failed polls
+	// of a job instance before monitoring stops.
+	DefaultMaxPollFailures = 10
+
+	// DefaultPollFailureWindow is the default amount of time polls of a job instance
+	// can fail for, without a successful poll, before monitoring stops.
+	DefaultPollFailureWindow = 30 * time.Minute
+)
+
+var (
+	// ErrMissingDomain is returned by NewClient when no Alma API server domain is provided.
+	ErrMissingDomain = errors.New("an Alma API server domain is required")
+
+	// ErrMissingKey is returned by NewClient when no Alma API key is provided.
+	ErrMissingKey = errors.New("an Alma API key is required")
+)
+
+// Client submits and monitors jobs using the Alma Jobs API.
+// A Client is safe for concurrent use by multiple goroutines.
+type Client struct {
+	domain            string
+	key               string
+	httpClient        *http.Client
+	logger            *log.Logger
+	retryPolicy       RetryPolicy
+	timeout           time.Duration
+	pollInterval      time.Duration
+	maxPolls          int
+	maxPollFailures   int
+	pollFailureWindow time.Duration
+	submittedBy       string
+	jitter            *JitterSource
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithDomain sets the domain of the Alma API server. (ex: api-ca.hosted.exlibrisgroup.com)
+func WithDomain(domain string) Option {
+	return func(c *Client) {
+		c.domain = domain
+	}
+}
+
+// WithKey sets the Alma API key.
+func WithKey(key string) Option {
+	return func(c *Client) {
+		c.key = key
+	}
+}
+
+// WithHTTPClient sets the http.Client used to make requests.
+// http.DefaultClient is used by default.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithLogger sets the logger used to report progress.
+// Nothing is logged by default.
+func WithLogger(logger *log.Logger) Option {
+	return func(c *Client) {
+		c.logger = logger
+	}
+}
+
+// WithRetryPolicy sets the policy used to retry requests which fail with a transient error.
+func WithRetryPolicy(retryPolicy RetryPolicy) Option {
+	return func(c *Client) {
+		c.retryPolicy = retryPolicy
+	}
+}
+
+// WithTimeout sets the amount of time to wait on the Alma API for each request.
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.timeout = timeout
+	}
+}
+
+// WithPollInterval sets the amount of time to wait between requests for job instance data.
+func WithPollInterval(pollInterval time.Duration) Option {
+	return func(c *Client) {
+		c.pollInterval = pollInterval
+	}
+}
+
+// WithMaxPolls sets the number of times a job instance is requested before monitoring stops.
+func WithMaxPolls(maxPolls int) Option {
+	return func(c *Client) {
+		c.maxPolls = maxPolls
+	}
+}
+
+// WithMaxPollFailures sets the number of consecutive failed polls of a job instance
+// before monitoring stops.
+func WithMaxPollFailures(maxPollFailures int) Option {
+	return func(c *Client) {
+		c.maxPollFailures = maxPollFailures
+	}
+}
+
+// WithPollFailureWindow sets the amount of time polls of a job instance can fail for,
+// without a successful poll, before monitoring stops. Zero means no limit.
+func WithPollFailureWindow(pollFailureWindow time.Duration) Option {
+	return func(c *Client) {
+		c.pollFailureWindow = pollFailureWindow
+	}
+}
+
+// WithSubmittedBy sets the user Alma records as submitting jobs with the client's API key.
+// When set, only job instances submitted by this user are adopted by RetrySubmitJob.
+func WithSubmittedBy(submittedBy string) Option {
+	return func(c *Client) {
+		c.submittedBy = submittedBy
+	}
+}
+
+// NewClient returns a new Client configured with the provided options.
+// The domain and key options are required.
+func NewClient(options ...Option) (*Client, error) {
+	c := &Client{
+		httpClient:        http.DefaultClient,
+		logger:            log.New(io.Discard, "", 0),
+		retryPolicy:       DefaultRetryPolicy(),
+		timeout:           DefaultTimeout,
+		pollInterval:      DefaultPollInterval,
+		maxPolls:          DefaultMaxPolls,
+		maxPollFailures:   DefaultMaxPollFailures,
+		pollFailureWindow: DefaultPollFailureWindow,
+		jitter:            NewJitterSource(),
+	}
+	for _, option := range options {
+		option(c)
+	}
+	if c.domain == "" {
+		return nil, ErrMissingDomain
+	}
+	if c.key == "" {
+		return nil, ErrMissingKey
+	}
+	return c, nil
+}
+
+// Domain returns the domain of the Alma API server used by the client.
+func (c *Client) Domain() string {
+	return c.domain
+}
+
+// URL builds an Alma API URL from a path which starts with a /.
+func (c *Client) URL(path string) (*url.URL, error) {
+	return url.Parse(fmt.Sprintf("https://%v%v", c.domain, path))
+}
+
+// RetrySubmitJob calls SubmitJob, retrying errors which IsRetryable considers transient
+// using the client's retry policy. If the retries run out, the returned *RetryError wraps
+// the error from the last attempt.
+//
+// If an attempt fails in a way which IsAmbiguous, the job might have been submitted
+// anyway. Before trying again, the job's recent instances are requested, and if one
+// was submitted since the first attempt, its link is returned instead of submitting
+// the job a second time. The instances are also checked if the last attempt was
+// ambiguous. Only instances submitted by the client's submitter, set with
+// WithSubmittedBy, are adopted. If none is set and an instance was submitted
+// since the first attempt, or the check fails, an error matching
+// ErrSubmissionUnknown is returned instead of guessing.
+func (c *Client) RetrySubmitJob(ctx context.Context, url *url.URL, params AlmaJob) (jobInstanceLink string, err error) {
+	firstAttempt := time.Now()
+	ambiguous := false
+	err = c.retry(ctx, "submit job", func() error {
+		if ambiguous {
+			link, err := c.findSubmittedInstance(ctx, url, firstAttempt)
+			if err != nil {
+				// Without knowing whether the job was submitted, don't submit it again.
+				return fmt.Errorf("checking for a job instance submitted by an earlier attempt: %w", err)
+			}
+			if link != "" {
+				c.logger.Println("An earlier attempt submitted the job, adopting job instance", link)
+				jobInstanceLink = link
+				return nil
+			}
+			c.logger.Printf("No job instance was submitted since %v, submitting the job again.\n", firstAttempt.Format(time.RFC3339))
+			ambiguous = false
+		}
+		// Submit the Job, get the job instance ID back.
+		jobInstanceLink, err = c.SubmitJob(ctx, url, params)
+		if IsAmbiguous(err) {
+			ambiguous = true
+		}
+		return err
+	})
+	// If the last attempt might have submitted the job, look for its instance
+	// before giving up, so that the job isn't reported as not submitted while it runs.
+	var retryErr *RetryError
+	if ambiguous && errors.As(err, &retryErr) {
+		link, findErr := c.findSubmittedInstance(ctx, url, firstAttempt)
+		switch {
+		case errors.Is(findErr, ErrSubmissionUnknown):
+			return "", findErr
+		case findErr != nil:
+			return "", fmt.Errorf("%w: the last attempt failed with %v, and checking for a job instance it submitted failed: %v", ErrSubmissionUnknown, retryErr.Err, findErr)
+		case link != "":
+			c.logger.Println("The last attempt submitted the job, adopting job instance", link)
+			return link, nil
+		}
+	}
+	return jobInstanceLink, err
+}
+
+// SubmitJob sends a POST HTTP request to the Alma API to execute the job.
+func (c *Client) SubmitJob(ctx context.Context, url *url.URL, params AlmaJob) (jobInstanceLink string, err error) {
+	// Setup the job parameter data as a io.Reader.
+	marshaledParams := new(bytes.Buffer)
+	encoder := xml.NewEncoder(marshaledParams)
+	err = encoder.Encode(params)
+	if err != nil {
+		return "", err
+	}
+
+	// Decode the job and return the job instance ID.
+	returnedJob := &AlmaJob{}
+	err = c.do(ctx, http.MethodPost, url, marshaledParams, returnedJob)
+	if err != nil {
+		return "", err
+	}
+	if returnedJob.AdditionalInfo == nil {
+		return "", fmt.Errorf("%w: no job instance link in response", ErrAPIError)
+	}
+	return returnedJob.AdditionalInfo.Link, nil
+}
+
+// MonitorStats describes how monitoring a job instance went.
+type MonitorStats struct {
+	// Polls is the number of times the job instance was requested.
+	Polls int
+	// FailedPolls is the number of those requests which failed.
+	FailedPolls int
+}
+
+// MonitorJobInstance will request the job instance until the job is complete or
+// the maximum number of polls is reached.
+func (c *Client) MonitorJobInstance(ctx context.Context, url *url.URL) (instance *AlmaJobInstance, err error) {
+	instance, _, err = c.Monitor(ctx, url)
+	return instance, err
+}
+
+// Monitor will request the job instance until the job is complete or the maximum
+// number of polls is reached. Polls which fail with a transient error are retried
+// using the client's retry policy, without waiting less than the poll interval.
+// Monitoring stops when polls fail more times in a row than allowed, or keep failing
+// for longer than the poll failure window; the returned *RetryError wraps the error
+// from the last poll. The instance returned is the last one successfully requested.
+func (c *Client) Monitor(ctx context.Context, url *url.URL) (instance *AlmaJobInstance, stats MonitorStats, err error) {
+	consecutiveFailures := 0
+	var failingSince time.Time
+	for i := 0; i < c.maxPolls; i++ {
+		stats.Polls++
+		polled, err := c.GetJobInstance(ctx, url)
+		if err != nil {
+			stats.FailedPolls++
+			if ctx.Err() != nil {
+				return instance, stats, ctx.Err()
+			}
+			c.logger.Printf("Failed to get job instance: %v\n", err)
+			if !IsRetryable(err) {
+				c.logger.Println("The error is permanent, not retrying.")
+				return instance, stats, err
+			}
+			if consecutiveFailures == 0 {
+				failingSince = time.Now()
+			}
+			consecutiveFailures++
+			if consecutiveFailures >= c.maxPollFailures {
+				c.logger.Printf("%v polls in a row have failed, giving up.\n", consecutiveFailures)
+				return instance, stats, &RetryError{Attempts: consecutiveFailures, Err: err}
+			}
+			delay := c.retryDelay(consecutiveFailures-1, err)
+			if delay < c.pollInterval {
+				delay = c.pollInterval
+			}
+			if c.pollFailureWindow > 0 && time.Since(failingSince)+delay > c.pollFailureWindow {
+				c.logger.Printf("Polls have been failing for %v, giving up.\n", time.Since(failingSince).Round(time.Second))
+				return instance, stats, &RetryError{Attempts: consecutiveFailures, Err: err}
+			}
+			c.logger.Printf("Polling again in %v (%v/%v consecutive failures)\n", delay, consecutiveFailures, c.maxPollFailures)
+			err = Sleep(ctx, delay)
+			if err != nil {
+				return instance, stats, err
+			}
+			continue
+		}
+		instance = polled
+		if consecutiveFailures > 0 {
+			c.logger.Printf("Polling recovered after %v failed polls.\n", consecutiveFailures)
+			consecutiveFailures = 0
+		}
+		if instance.Status != nil {
+			c.logger.Println("Job Status: ", instance.Status.Desc)
+		}
+		if instance.Finished() {
+			return instance, stats, nil
+		}
+		err = Sleep(ctx, c.pollInterval)
+		if err != nil {
+			return instance, stats, err
+		}
+	}
+	return instance, stats, fmt.Errorf("%w: job monitor has been running for %v, exiting", ErrMonitorTimeout, time.Duration(c.maxPolls)*c.pollInterval)
+}
+
+// GetJobInstance sends a GET HTTP request to the Alma API to get job instance data.
+func (c *Client) GetJobInstance(ctx context.Context, url *url.URL) (instance *AlmaJobInstance, err error) {
+	instance = &AlmaJobInstance{}
+	err = c.do(ctx, http.MethodGet, url, nil, instance)
+	return instance, err
+}
+
+// do sends a request to the Alma API and decodes the XML response body into v.
+func (c *Client) do(ctx context.Context, method string, url *url.URL, body io.Reader, v interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	// Setup the request.
+	request, err := http.NewRequestWithContext(ctx, method, url.String(), body)
+	if err != nil {
+		return err
+	}
+	request.Header.Add("Authorization", "apikey "+c.key)
+	request.Header.Add("Accept", "application/xml")
+	if body != nil {
+		request.Header.Add("Content-Type", "application/xml")
+	}
+
+	// Do the request.
+	resp, err := c.httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	// Drain and close the response body, no matter how it was handled.
+	defer func() {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+
+	// Log the remaning number of API calls.
+	remainingCalls := resp.Header.Get("X-Exl-Api-Remaining")
+	if remainingCalls != "" {
+		c.logger.Printf("%v Alma API calls remaining.\n", remainingCalls)
+	}
+
+	// If the Status != OK, build an error from the response.
+	if resp.StatusCode != http.StatusOK {
+		return newResponseError(resp)
+	}
+
+	// Decode the response.
+	decoder := xml.NewDecoder(resp.Body)
+	return decoder.Decode(v)
+}
+
+// Sleep pauses for the duration d, or until the context is done, when it returns the context's error.
+func Sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
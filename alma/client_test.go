@@ -0,0 +1,227 @@
+// Copyright 2024 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package alma
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestClient returns a Client which sends requests to the test server.
+func newTestClient(t *testing.T, server *httptest.Server, options ...Option) *Client {
+	t.Helper()
+	options = append([]Option{
+		WithDomain(strings.TrimPrefix(server.URL, "https://")),
+		WithKey("testkey"),
+		WithHTTPClient(server.Client()),
+		WithPollInterval(time.Millisecond),
+	}, options...)
+	client, err := NewClient(options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestNewClientRequiresDomainAndKey(t *testing.T) {
+	_, err := NewClient(WithKey("testkey"))
+	if !errors.Is(err, ErrMissingDomain) {
+		t.Fatalf("Expected ErrMissingDomain, got %v.", err)
+	}
+	_, err = NewClient(WithDomain("api-ca.hosted.exlibrisgroup.com"))
+	if !errors.Is(err, ErrMissingKey) {
+		t.Fatalf("Expected ErrMissingKey, got %v.", err)
+	}
+}
+
+func TestSubmitJob(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			t.Errorf("Expected POST, got %v.", r.Method)
+		}
+		if r.Header.Get("Authorization") != "apikey testkey" {
+			t.Errorf("Unexpected Authorization header %q.", r.Header.Get("Authorization"))
+		}
+		fmt.Fprint(w, `<job><additional_info link="https://example.com/instances/1">Job submitted.</additional_info></job>`)
+	}))
+	defer server.Close()
+
+	client := newTestClient(t, server)
+	jobURL, err := client.URL("/almaws/v1/conf/jobs/M1?op=run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	link, err := client.SubmitJob(context.Background(), jobURL, AlmaJob{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link != "https://example.com/instances/1" {
+		t.Fatalf("Unexpected job instance link %q.", link)
+	}
+}
+
+func TestSubmitJobAPIError(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `<web_service_result><errorList><error><errorCode>402119</errorCode><errorMessage>General error.</errorMessage></error></errorList></web_service_result>`)
+	}))
+	defer server.Close()
+
+	client := newTestClient(t, server)
+	jobURL, err := client.URL("/almaws/v1/conf/jobs/M1?op=run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.SubmitJob(context.Background(), jobURL, AlmaJob{})
+	if !errors.Is(err, ErrAPIError) {
+		t.Fatalf("Expected ErrAPIError, got %v.", err)
+	}
+	if !strings.Contains(err.Error(), "402119") {
+		t.Fatalf("Expected the Alma error code in %q.", err)
+	}
+}
+
+func TestRetrySubmitJobPermanentError(t *testing.T) {
+	requests := 0
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `<web_service_result><errorList><error><errorCode>UNAUTHORIZED</errorCode><errorMessage>API-key not defined or not configured to allow this API.</errorMessage></error></errorList></web_service_result>`)
+	}))
+	defer server.Close()
+
+	client := newTestClient(t, server)
+	jobURL, err := client.URL("/almaws/v1/conf/jobs/M1?op=run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.RetrySubmitJob(context.Background(), jobURL, AlmaJob{})
+	if requests != 1 {
+		t.Fatalf("Expected 1 request, got %v.", requests)
+	}
+	var responseErr *ResponseError
+	if !errors.As(err, &responseErr) || responseErr.Code != "UNAUTHORIZED" {
+		t.Fatalf("Expected the UNAUTHORIZED ResponseError, got %v.", err)
+	}
+	if errors.Is(err, ErrMaxRetries) {
+		t.Fatal("A permanent error shouldn't be reported as reaching the maximum number of retries.")
+	}
+}
+
+func TestMonitorJobInstance(t *testing.T) {
+	polls := 0
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		polls++
+		if polls < 3 {
+			fmt.Fprint(w, `<job_instance><status desc="Running">RUNNING</status></job_instance>`)
+			return
+		}
+		fmt.Fprint(w, `<job_instance><end_time>2015-04-15T13:07:44.359Z</end_time><status desc="Completed Successfully">COMPLETED_SUCCESS</status></job_instance>`)
+	}))
+	defer server.Close()
+
+	client := newTestClient(t, server)
+	instanceURL, err := client.URL("/almaws/v1/conf/jobs/M1/instances/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	instance, err := client.MonitorJobInstance(context.Background(), instanceURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if polls != 3 {
+		t.Fatalf("Expected 3 polls, got %v.", polls)
+	}
+	if instance.Status.Value != "COMPLETED_SUCCESS" {
+		t.Fatalf("Unexpected final status %q.", instance.Status.Value)
+	}
+}
+
+func TestMonitorJobInstanceMaxPolls(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<job_instance><status desc="Running">RUNNING</status></job_instance>`)
+	}))
+	defer server.Close()
+
+	client := newTestClient(t, server, WithMaxPolls(2))
+	instanceURL, err := client.URL("/almaws/v1/conf/jobs/M1/instances/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.MonitorJobInstance(context.Background(), instanceURL)
+	if !errors.Is(err, ErrAPIError) {
+		t.Fatalf("Expected ErrAPIError, got %v.", err)
+	}
+	if !errors.Is(err, ErrMonitorTimeout) {
+		t.Fatalf("Expected ErrMonitorTimeout, got %v.", err)
+	}
+}
+
+func TestMonitorTransientPollFailures(t *testing.T) {
+	polls := 0
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		polls++
+		switch polls {
+		case 1:
+			fmt.Fprint(w, `<job_instance><status desc="Running">RUNNING</status></job_instance>`)
+		case 2, 3:
+			w.WriteHeader(http.StatusBadGateway)
+		default:
+			fmt.Fprint(w, `<job_instance><end_time>2015-04-15T13:07:44.359Z</end_time><status desc="Completed Successfully">COMPLETED_SUCCESS</status></job_instance>`)
+		}
+	}))
+	defer server.Close()
+
+	client := newTestClient(t, server, WithMaxPollFailures(3), WithRetryPolicy(RetryPolicy{MaxRetries: 1, BaseDelay: time.Millisecond}))
+	instanceURL, err := client.URL("/almaws/v1/conf/jobs/M1/instances/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	instance, stats, err := client.Monitor(context.Background(), instanceURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if instance.Status.Value != "COMPLETED_SUCCESS" {
+		t.Fatalf("Unexpected final status %q.", instance.Status.Value)
+	}
+	if stats.Polls != 4 || stats.FailedPolls != 2 {
+		t.Fatalf("Expected 4 polls and 2 failed polls, got %+v.", stats)
+	}
+}
+
+func TestMonitorMaxPollFailures(t *testing.T) {
+	polls := 0
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		polls++
+		if polls == 1 {
+			fmt.Fprint(w, `<job_instance><status desc="Running">RUNNING</status></job_instance>`)
+			return
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	client := newTestClient(t, server, WithMaxPollFailures(3), WithRetryPolicy(RetryPolicy{MaxRetries: 1, BaseDelay: time.Millisecond}))
+	instanceURL, err := client.URL("/almaws/v1/conf/jobs/M1/instances/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	instance, stats, err := client.Monitor(context.Background(), instanceURL)
+	if !errors.Is(err, ErrMaxRetries) {
+		t.Fatalf("Expected ErrMaxRetries, got %v.", err)
+	}
+	if instance == nil || instance.Status.Value != "RUNNING" {
+		t.Fatalf("Expected the last successfully polled instance, got %+v.", instance)
+	}
+	if stats.Polls != 4 || stats.FailedPolls != 3 {
+		t.Fatalf("Expected 4 polls and 3 failed polls, got %+v.", stats)
+	}
+}
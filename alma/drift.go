@@ -0,0 +1,120 @@
+// Copyright 2024 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package alma
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// RenamedParameter is a parameter which appears to have been renamed in the job's definition.
+type RenamedParameter struct {
+	From string
+	To   string
+}
+
+// ParameterDrift describes the differences between the names of the parameters
+// submitted for a job and the parameters in the job's definition in Alma.
+type ParameterDrift struct {
+	// Unknown parameters are submitted, but aren't in the job's definition.
+	Unknown []string
+	// Missing parameters are in the job's definition, but aren't submitted.
+	Missing []string
+	// Renamed parameters are submitted under a name which looks like
+	// the name of a missing parameter.
+	Renamed []RenamedParameter
+}
+
+// Drifted reports whether any differences were found.
+func (d ParameterDrift) Drifted() bool {
+	return len(d.Unknown) > 0 || len(d.Missing) > 0 || len(d.Renamed) > 0
+}
+
+// Messages returns a description of each difference.
+func (d ParameterDrift) Messages() []string {
+	messages := make([]string, 0, len(d.Unknown)+len(d.Missing)+len(d.Renamed))
+	for _, renamed := range d.Renamed {
+		messages = append(messages, fmt.Sprintf("parameter %v appears to have been renamed to %v", renamed.From, renamed.To))
+	}
+	for _, name := range d.Unknown {
+		messages = append(messages, fmt.Sprintf("parameter %v is not in the job's definition", name))
+	}
+	for _, name := range d.Missing {
+		messages = append(messages, fmt.Sprintf("parameter %v of the job's definition is not set", name))
+	}
+	return messages
+}
+
+// CompareParameters compares the names of the submitted parameters to the names of the
+// parameters in the job's definition. A submitted parameter which isn't in the definition
+// is treated as renamed if a missing parameter has the same description, or a name which
+// is the same or a prefix of it once case and punctuation are ignored.
+func CompareParameters(submitted, defined []Parameter) ParameterDrift {
+	definedNames := map[string]bool{}
+	for _, param := range defined {
+		definedNames[param.Name.Value] = true
+	}
+	submittedNames := map[string]bool{}
+	var unknown []Parameter
+	for _, param := range submitted {
+		submittedNames[param.Name.Value] = true
+		if !definedNames[param.Name.Value] {
+			unknown = append(unknown, param)
+		}
+	}
+	var missing []Parameter
+	for _, param := range defined {
+		if !submittedNames[param.Name.Value] {
+			missing = append(missing, param)
+		}
+	}
+
+	drift := ParameterDrift{}
+	matched := map[string]bool{}
+	for _, param := range unknown {
+		to := ""
+		for _, candidate := range missing {
+			if !matched[candidate.Name.Value] && similarParameters(param, candidate) {
+				to = candidate.Name.Value
+				break
+			}
+		}
+		if to == "" {
+			drift.Unknown = append(drift.Unknown, param.Name.Value)
+			continue
+		}
+		matched[to] = true
+		drift.Renamed = append(drift.Renamed, RenamedParameter{From: param.Name.Value, To: to})
+	}
+	for _, param := range missing {
+		if !matched[param.Name.Value] {
+			drift.Missing = append(drift.Missing, param.Name.Value)
+		}
+	}
+	return drift
+}
+
+// similarParameters reports whether a and b are likely the same parameter under different names.
+func similarParameters(a, b Parameter) bool {
+	if a.Name.Desc != "" && strings.EqualFold(a.Name.Desc, b.Name.Desc) {
+		return true
+	}
+	aName, bName := normalizeParameterName(a.Name.Value), normalizeParameterName(b.Name.Value)
+	if aName == "" || bName == "" {
+		return false
+	}
+	return strings.HasPrefix(aName, bName) || strings.HasPrefix(bName, aName)
+}
+
+// normalizeParameterName returns the lowercase letters and digits of name.
+func normalizeParameterName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, name)
+}
@@ -0,0 +1,57 @@
+// Copyright 2024 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package alma
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCompareParameters(t *testing.T) {
+	param := func(name, desc string) Parameter {
+		return Parameter{Name: DescAndValue{Value: name, Desc: desc}}
+	}
+	tests := []struct {
+		name      string
+		submitted []Parameter
+		defined   []Parameter
+		expected  ParameterDrift
+	}{
+		{
+			name:      "no drift",
+			submitted: []Parameter{param("set_id", ""), param("job_name", "")},
+			defined:   []Parameter{param("set_id", "Set"), param("job_name", "Job name")},
+		},
+		{
+			name:      "unknown and missing",
+			submitted: []Parameter{param("set_id", ""), param("old_flag", "")},
+			defined:   []Parameter{param("set_id", "Set"), param("email_report", "Email report")},
+			expected:  ParameterDrift{Unknown: []string{"old_flag"}, Missing: []string{"email_report"}},
+		},
+		{
+			name:      "renamed by name",
+			submitted: []Parameter{param("task_ExportParams_outputFormat_string", "")},
+			defined:   []Parameter{param("task_exportparams_outputformat", "Output format")},
+			expected:  ParameterDrift{Renamed: []RenamedParameter{{From: "task_ExportParams_outputFormat_string", To: "task_exportparams_outputformat"}}},
+		},
+		{
+			name:      "renamed by description",
+			submitted: []Parameter{param("from_date", "From date")},
+			defined:   []Parameter{param("start_date", "From date")},
+			expected:  ParameterDrift{Renamed: []RenamedParameter{{From: "from_date", To: "start_date"}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			drift := CompareParameters(tt.submitted, tt.defined)
+			if !reflect.DeepEqual(drift, tt.expected) {
+				t.Fatalf("Expected %+v, got %+v.", tt.expected, drift)
+			}
+			if drift.Drifted() != (len(drift.Messages()) > 0) {
+				t.Fatalf("Drifted is %v, but the messages are %q.", drift.Drifted(), drift.Messages())
+			}
+		})
+	}
+}
@@ -0,0 +1,219 @@
+// Copyright 2024 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package alma
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// maxErrorBodySize is the largest error response body which is read.
+const maxErrorBodySize = 64 << 10
+
+// Alma error codes which affect whether a request is retried.
+const (
+	// PerSecondThresholdErrorCode is returned when too many requests are made in one second.
+	PerSecondThresholdErrorCode = "PER_SECOND_THRESHOLD"
+
+	// DailyThresholdErrorCode is returned when the institution's daily API limit is reached.
+	DailyThresholdErrorCode = "DAILY_THRESHOLD"
+)
+
+var (
+	// ErrAPIError is an error which is used when the Alma API returns an error.
+	ErrAPIError = errors.New("an API error occurred")
+
+	// ErrMaxRetries is matched by errors.Is when every attempt to submit a job failed.
+	ErrMaxRetries = errors.New("maximum number of retries reached")
+
+	// ErrSubmissionUnknown is returned when a submission might have reached Alma,
+	// but the job instance it started can't be identified, so the job is neither
+	// adopted nor submitted again.
+	ErrSubmissionUnknown = errors.New("submission state unknown")
+
+	// ErrMonitorTimeout is returned when the job instance hasn't finished after the
+	// maximum number of polls. It matches ErrAPIError.
+	ErrMonitorTimeout = fmt.Errorf("%w: the job monitor timed out", ErrAPIError)
+)
+
+// APIError is a struct which stores the data from Alma API errors.
+type APIError struct {
+	XMLName   xml.Name `xml:"web_service_result"`
+	ErrorList []struct {
+		Error struct {
+			ErrorCode    string `xml:"errorCode"`
+			ErrorMessage string `xml:"errorMessage"`
+		} `xml:"error"`
+	} `xml:"errorList"`
+}
+
+// Collapse is a method on APIErrors which returns the first APIError as
+// a Go error. This was done this way because the API returns different
+// error codes which mean the same thing.
+func (e *APIError) Collapse() error {
+	if len(e.ErrorList) > 0 {
+		return fmt.Errorf("%w: %v - %v", ErrAPIError, e.ErrorList[0].Error.ErrorCode, e.ErrorList[0].Error.ErrorMessage)
+	}
+	return fmt.Errorf("%w: unknown error", ErrAPIError)
+}
+
+// ResponseError is returned when the Alma API responds with an HTTP status other than 200 OK.
+// If the response body was an Alma error, the first error's code and message are stored.
+// It matches ErrAPIError using errors.Is.
+type ResponseError struct {
+	StatusCode int
+	Status     string
+	Header     http.Header
+	Code       string
+	Message    string
+	Body       string
+}
+
+// newResponseError reads the body of an error response and builds a ResponseError.
+func newResponseError(resp *http.Response) *ResponseError {
+	responseErr := &ResponseError{
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		Header:     resp.Header,
+	}
+	bodyBytes, err := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+	if err != nil {
+		responseErr.Body = fmt.Sprintf("couldn't read body: %v", err)
+		return responseErr
+	}
+	// We can (usually) parse the returned XML.
+	apiErr := &APIError{}
+	err = xml.Unmarshal(bodyBytes, apiErr)
+	if err == nil && len(apiErr.ErrorList) > 0 {
+		responseErr.Code = apiErr.ErrorList[0].Error.ErrorCode
+		responseErr.Message = apiErr.ErrorList[0].Error.ErrorMessage
+		return responseErr
+	}
+	responseErr.Body = strings.TrimSpace(string(bodyBytes))
+	return responseErr
+}
+
+// Error returns a description of the failed request.
+func (e *ResponseError) Error() string {
+	if e.Code != "" {
+		return fmt.Sprintf("alma API request failed, HTTP status %v, %v: %v - %v", e.Status, ErrAPIError, e.Code, e.Message)
+	}
+	return fmt.Sprintf("alma API request failed: %v - %v: %v", e.Status, e.Body, ErrAPIError)
+}
+
+// Unwrap returns ErrAPIError.
+func (e *ResponseError) Unwrap() error {
+	return ErrAPIError
+}
+
+// Retryable reports whether the request might succeed if it is made again.
+// Server errors and rate limiting are transient. Authentication, validation
+// and other client errors are permanent, as is reaching the daily API limit.
+func (e *ResponseError) Retryable() bool {
+	switch {
+	case e.Code == PerSecondThresholdErrorCode:
+		return true
+	case e.Code == DailyThresholdErrorCode:
+		return false
+	case e.StatusCode == http.StatusTooManyRequests, e.StatusCode == http.StatusRequestTimeout:
+		return true
+	case e.StatusCode >= http.StatusInternalServerError:
+		return true
+	default:
+		return false
+	}
+}
+
+// IsRetryable reports whether err is a transient failure, which might not happen
+// if the request is made again. Timeouts, connections which were reset or refused,
+// and connections closed before the response was read are transient, as are the
+// ResponseErrors whose Retryable method returns true. Cancellation, certificate
+// verification failures, unsupported URLs and errors which happened before a
+// request was sent are permanent.
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var responseErr *ResponseError
+	if errors.As(err, &responseErr) {
+		return responseErr.Retryable()
+	}
+	return isTransientRequestError(err)
+}
+
+// IsAmbiguous reports whether a request which failed with err might have reached
+// Alma anyway, so that a job could have been submitted even though no response
+// was received. Timeouts, connections reset or closed after the request was written
+// and gateway errors are ambiguous. Errors which happened before a connection was
+// made, like DNS failures and refused connections, permanent errors, and responses
+// from Alma aren't.
+func IsAmbiguous(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var responseErr *ResponseError
+	if errors.As(err, &responseErr) {
+		return responseErr.StatusCode == http.StatusBadGateway || responseErr.StatusCode == http.StatusGatewayTimeout
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return false
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return false
+	}
+	return isTransientRequestError(err)
+}
+
+// isTransientRequestError reports whether err, from sending a request or reading
+// its response, might not happen again. Every error from http.Client.Do is a
+// *url.Error, which is a net.Error whatever caused it, so the error it wraps
+// is classified instead.
+func isTransientRequestError(err error) bool {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		err = urlErr.Err
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, errConnectionReset) || errors.Is(err, errConnectionRefused) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// RetryError is returned when every attempt to submit a job failed with a transient error.
+// It wraps the error from the last attempt, and matches ErrMaxRetries using errors.Is.
+type RetryError struct {
+	Attempts int
+	Err      error
+}
+
+// Error returns a description of the last failure.
+func (e *RetryError) Error() string {
+	if e.Err == nil {
+		return ErrMaxRetries.Error()
+	}
+	return fmt.Sprintf("%v after %v attempts, last error: %v", ErrMaxRetries, e.Attempts, e.Err)
+}
+
+// Unwrap returns the error from the last attempt.
+func (e *RetryError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is ErrMaxRetries.
+func (e *RetryError) Is(target error) bool {
+	return target == ErrMaxRetries
+}
@@ -0,0 +1,108 @@
+// Copyright 2024 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package alma
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"testing"
+)
+
+// timeoutError is a net.Error which is a timeout, like the error returned when http.Client.Timeout is reached.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "timeout awaiting response headers" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+// refusedError returns the error from posting to a port nothing is listening on.
+func refusedError() error {
+	return &url.Error{Op: "Post", URL: "https://127.0.0.1:1", Err: &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", errConnectionRefused)}}
+}
+
+// resetError returns the error from reading a response from a server which reset the connection.
+func resetError() error {
+	return &url.Error{Op: "Post", URL: "https://127.0.0.1:1", Err: &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", errConnectionReset)}}
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		retryable bool
+	}{
+		{"nil", nil, false},
+		{"server error", &ResponseError{StatusCode: http.StatusInternalServerError}, true},
+		{"service unavailable", &ResponseError{StatusCode: http.StatusServiceUnavailable}, true},
+		{"too many requests", &ResponseError{StatusCode: http.StatusTooManyRequests}, true},
+		{"per second threshold", &ResponseError{StatusCode: http.StatusTooManyRequests, Code: PerSecondThresholdErrorCode}, true},
+		{"daily threshold", &ResponseError{StatusCode: http.StatusTooManyRequests, Code: DailyThresholdErrorCode}, false},
+		{"invalid key", &ResponseError{StatusCode: http.StatusBadRequest, Code: "UNAUTHORIZED"}, false},
+		{"invalid parameter", &ResponseError{StatusCode: http.StatusBadRequest, Code: "402119"}, false},
+		{"forbidden", &ResponseError{StatusCode: http.StatusForbidden}, false},
+		{"wrapped server error", fmt.Errorf("submitting: %w", &ResponseError{StatusCode: http.StatusBadGateway}), true},
+		{"connection refused", refusedError(), true},
+		{"connection reset", resetError(), true},
+		{"response lost", &url.Error{Op: "Post", URL: "https://example.com", Err: io.EOF}, true},
+		{"client timeout", &url.Error{Op: "Post", URL: "https://example.com", Err: timeoutError{}}, true},
+		{"unknown certificate authority", &url.Error{Op: "Post", URL: "https://example.com", Err: x509.UnknownAuthorityError{}}, false},
+		{"unsupported scheme", &url.Error{Op: "Post", URL: "ftp://example.com", Err: errors.New(`unsupported protocol scheme "ftp"`)}, false},
+		{"timeout", context.DeadlineExceeded, true},
+		{"cancelled", context.Canceled, false},
+		{"other", errors.New("xml: unsupported type"), false},
+	}
+	for _, test := range tests {
+		if IsRetryable(test.err) != test.retryable {
+			t.Errorf("%v: expected retryable %v.", test.name, test.retryable)
+		}
+	}
+}
+
+func TestIsAmbiguous(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		ambiguous bool
+	}{
+		{"nil", nil, false},
+		{"alma error", &ResponseError{StatusCode: http.StatusBadRequest, Code: "402119"}, false},
+		{"service unavailable", &ResponseError{StatusCode: http.StatusServiceUnavailable}, false},
+		{"gateway timeout", &ResponseError{StatusCode: http.StatusGatewayTimeout}, true},
+		{"connection refused", refusedError(), false},
+		{"dns failure", &url.Error{Op: "Post", URL: "https://example.com", Err: &net.OpError{Op: "dial", Err: &net.DNSError{Err: "no such host", Name: "example.com"}}}, false},
+		{"connection reset", resetError(), true},
+		{"response lost", &url.Error{Op: "Post", URL: "https://example.com", Err: io.EOF}, true},
+		{"unknown certificate authority", &url.Error{Op: "Post", URL: "https://example.com", Err: x509.UnknownAuthorityError{}}, false},
+		{"timeout", fmt.Errorf("submitting: %w", context.DeadlineExceeded), true},
+		{"cancelled", context.Canceled, false},
+	}
+	for _, test := range tests {
+		if IsAmbiguous(test.err) != test.ambiguous {
+			t.Errorf("%v: expected ambiguous %v.", test.name, test.ambiguous)
+		}
+	}
+}
+
+func TestRetryError(t *testing.T) {
+	last := &ResponseError{StatusCode: http.StatusServiceUnavailable, Status: "503 Service Unavailable", Body: "down"}
+	err := error(&RetryError{Attempts: 5, Err: last})
+	if !errors.Is(err, ErrMaxRetries) {
+		t.Error("Expected RetryError to match ErrMaxRetries.")
+	}
+	if !errors.Is(err, ErrAPIError) {
+		t.Error("Expected RetryError to wrap the last ResponseError.")
+	}
+	var responseErr *ResponseError
+	if !errors.As(err, &responseErr) || responseErr.StatusCode != http.StatusServiceUnavailable {
+		t.Error("Expected the last ResponseError to be available with errors.As.")
+	}
+}
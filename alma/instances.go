@@ -0,0 +1,180 @@
+// Copyright 2024 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package alma
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// MaxInstancesLimit is the largest number of job instances the Alma API returns in one request.
+	MaxInstancesLimit = 100
+
+	// submissionClockSkew allows for the difference between our clock and Alma's
+	// when looking for a job instance submitted by an earlier attempt.
+	submissionClockSkew = 2 * time.Minute
+
+	// queryDateLayout is the format of the dates used to filter job instances.
+	queryDateLayout = "2006-01-02"
+
+	// activeInstanceLookback is how far back ActiveJobInstances looks for
+	// job instances which haven't finished.
+	activeInstanceLookback = 7 * 24 * time.Hour
+)
+
+// JobInstancesQuery filters the job instances returned by ListJobInstances.
+// Zero values aren't used as filters.
+type JobInstancesQuery struct {
+	// SubmitDateFrom and SubmitDateTo filter by the day the instance was
+	// submitted, in UTC. The Alma API ignores the time of day.
+	SubmitDateFrom time.Time
+	SubmitDateTo   time.Time
+	// Status is a job instance status, like COMPLETED_SUCCESS.
+	Status string
+	Limit  int
+	Offset int
+}
+
+// InstancesURL returns the URL of the list of instances of the job
+// at jobURL, like /almaws/v1/conf/jobs/M47?op=run.
+func InstancesURL(jobURL *url.URL) *url.URL {
+	instancesURL := *jobURL
+	instancesURL.Path = path.Join(jobURL.Path, "instances")
+	instancesURL.RawPath = ""
+	instancesURL.RawQuery = ""
+	return &instancesURL
+}
+
+// InstanceURL returns the URL of a job instance of the job at jobURL.
+// The instance's link is used if it has one.
+func InstanceURL(jobURL *url.URL, instance *AlmaJobInstance) (*url.URL, error) {
+	if instance.Link != "" {
+		return url.Parse(instance.Link)
+	}
+	instanceURL := InstancesURL(jobURL)
+	instanceURL.Path = path.Join(instanceURL.Path, instance.ID)
+	return instanceURL, nil
+}
+
+// ListJobInstances sends a GET HTTP request to the Alma API to get one page of
+// the instances of the job at jobURL.
+func (c *Client) ListJobInstances(ctx context.Context, jobURL *url.URL, query JobInstancesQuery) (*AlmaJobInstances, error) {
+	instancesURL := InstancesURL(jobURL)
+	values := url.Values{}
+	if !query.SubmitDateFrom.IsZero() {
+		values.Set("submit_date_from", query.SubmitDateFrom.UTC().Format(queryDateLayout))
+	}
+	if !query.SubmitDateTo.IsZero() {
+		values.Set("submit_date_to", query.SubmitDateTo.UTC().Format(queryDateLayout))
+	}
+	if query.Status != "" {
+		values.Set("status", query.Status)
+	}
+	if query.Limit > 0 {
+		values.Set("limit", strconv.Itoa(query.Limit))
+	}
+	if query.Offset > 0 {
+		values.Set("offset", strconv.Itoa(query.Offset))
+	}
+	instancesURL.RawQuery = values.Encode()
+
+	instances := &AlmaJobInstances{}
+	err := c.do(ctx, http.MethodGet, instancesURL, nil, instances)
+	return instances, err
+}
+
+// findSubmittedInstance looks for an instance of the job at jobURL which was
+// submitted since the provided time by the client's submitter. It returns the
+// link to the earliest such instance, or "" if there isn't one. If no submitter
+// is set, an instance submitted since then could have been started by someone
+// else, like staff in the Alma UI, so ErrSubmissionUnknown is returned instead.
+func (c *Client) findSubmittedInstance(ctx context.Context, jobURL *url.URL, since time.Time) (string, error) {
+	since = since.Add(-submissionClockSkew)
+	instances, err := c.ListJobInstances(ctx, jobURL, JobInstancesQuery{
+		SubmitDateFrom: since,
+		SubmitDateTo:   time.Now(),
+		Limit:          MaxInstancesLimit,
+	})
+	if err != nil {
+		return "", err
+	}
+	var found *AlmaJobInstance
+	var foundTime time.Time
+	for i := range instances.Instances {
+		instance := &instances.Instances[i]
+		submitTime, err := ParseTime(instance.SubmitTime)
+		if err != nil || submitTime.Before(since) {
+			continue
+		}
+		if c.submittedBy != "" && (instance.SubmittedBy == nil || !strings.EqualFold(instance.SubmittedBy.Value, c.submittedBy)) {
+			continue
+		}
+		if found == nil || submitTime.Before(foundTime) {
+			found, foundTime = instance, submitTime
+		}
+	}
+	if found == nil {
+		return "", nil
+	}
+	if c.submittedBy == "" {
+		return "", fmt.Errorf("%w: job instance %v was submitted since %v, but without a submitter it can't be told apart from an instance submitted by someone else",
+			ErrSubmissionUnknown, found.ID, since.Format(time.RFC3339))
+	}
+	instanceURL, err := InstanceURL(jobURL, found)
+	if err != nil {
+		return "", err
+	}
+	return instanceURL.String(), nil
+}
+
+// AllJobInstances returns every instance of the job at jobURL matching the query,
+// requesting each page using the client's retry policy. The query's Limit and Offset are ignored.
+func (c *Client) AllJobInstances(ctx context.Context, jobURL *url.URL, query JobInstancesQuery) ([]AlmaJobInstance, error) {
+	query.Limit = MaxInstancesLimit
+	query.Offset = 0
+	var instances []AlmaJobInstance
+	for {
+		var page *AlmaJobInstances
+		err := c.retry(ctx, "list job instances", func() (err error) {
+			page, err = c.ListJobInstances(ctx, jobURL, query)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		instances = append(instances, page.Instances...)
+		query.Offset += len(page.Instances)
+		if len(page.Instances) == 0 || query.Offset >= page.TotalRecordCount {
+			return instances, nil
+		}
+	}
+}
+
+// ActiveJobInstances returns the instances of the job at jobURL which are queued
+// or running, among those submitted in the last week. Each page of instances is
+// requested using the client's retry policy.
+func (c *Client) ActiveJobInstances(ctx context.Context, jobURL *url.URL) ([]AlmaJobInstance, error) {
+	instances, err := c.AllJobInstances(ctx, jobURL, JobInstancesQuery{
+		SubmitDateFrom: time.Now().Add(-activeInstanceLookback),
+		SubmitDateTo:   time.Now(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	var active []AlmaJobInstance
+	for _, instance := range instances {
+		if instance.Active() {
+			active = append(active, instance)
+		}
+	}
+	return active, nil
+}
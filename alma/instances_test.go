@@ -0,0 +1,190 @@
+// Copyright 2024 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package alma
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestInstancesURL(t *testing.T) {
+	jobURL, err := url.Parse("https://api-ca.hosted.exlibrisgroup.com/almaws/v1/conf/jobs/M47?op=run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "https://api-ca.hosted.exlibrisgroup.com/almaws/v1/conf/jobs/M47/instances"
+	if got := InstancesURL(jobURL).String(); got != expected {
+		t.Fatalf("Expected %q, got %q.", expected, got)
+	}
+}
+
+// ambiguousSubmitServer returns a server whose first job submission fails with
+// 504 Gateway Timeout, and which lists instances using the provided XML.
+func ambiguousSubmitServer(t *testing.T, instances string) (server *httptest.Server, posts *int) {
+	t.Helper()
+	posts = new(int)
+	server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/almaws/v1/conf/jobs/M1/instances":
+			if r.URL.Query().Get("submit_date_from") == "" {
+				t.Error("Expected the instances to be filtered by submit date.")
+			}
+			fmt.Fprint(w, instances)
+		case r.Method == http.MethodPost:
+			*posts++
+			if *posts == 1 {
+				w.WriteHeader(http.StatusGatewayTimeout)
+				return
+			}
+			fmt.Fprint(w, `<job><additional_info link="https://example.com/instances/2">Job submitted.</additional_info></job>`)
+		default:
+			t.Errorf("Unexpected request %v %v.", r.Method, r.URL)
+		}
+	}))
+	return server, posts
+}
+
+func TestRetrySubmitJobAdoptsInstance(t *testing.T) {
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	old := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339Nano)
+	server, posts := ambiguousSubmitServer(t, `<job_instances total_record_count="3">`+
+		`<job_instance link="https://example.com/instances/0"><id>0</id><submit_time>`+old+`</submit_time><submitted_by>exl_api</submitted_by></job_instance>`+
+		`<job_instance link="https://example.com/instances/3"><id>3</id><submit_time>`+now+`</submit_time><submitted_by>someone_else</submitted_by></job_instance>`+
+		`<job_instance link="https://example.com/instances/1"><id>1</id><submit_time>`+now+`</submit_time><submitted_by>exl_api</submitted_by></job_instance>`+
+		`</job_instances>`)
+	defer server.Close()
+
+	client := newTestClient(t, server, WithSubmittedBy("exl_api"), WithRetryPolicy(RetryPolicy{MaxRetries: 3, BaseDelay: time.Millisecond}))
+	jobURL, err := client.URL("/almaws/v1/conf/jobs/M1?op=run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	link, err := client.RetrySubmitJob(context.Background(), jobURL, AlmaJob{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *posts != 1 {
+		t.Fatalf("Expected the job to be submitted once, got %v submissions.", *posts)
+	}
+	if link != "https://example.com/instances/1" {
+		t.Fatalf("Expected the recent instance to be adopted, got %q.", link)
+	}
+}
+
+func TestRetrySubmitJobResubmits(t *testing.T) {
+	old := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339Nano)
+	server, posts := ambiguousSubmitServer(t, `<job_instances total_record_count="1">`+
+		`<job_instance link="https://example.com/instances/0"><id>0</id><submit_time>`+old+`</submit_time></job_instance>`+
+		`</job_instances>`)
+	defer server.Close()
+
+	client := newTestClient(t, server, WithRetryPolicy(RetryPolicy{MaxRetries: 3, BaseDelay: time.Millisecond}))
+	jobURL, err := client.URL("/almaws/v1/conf/jobs/M1?op=run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	link, err := client.RetrySubmitJob(context.Background(), jobURL, AlmaJob{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *posts != 2 || link != "https://example.com/instances/2" {
+		t.Fatalf("Expected the job to be submitted again, got %v submissions and %q.", *posts, link)
+	}
+}
+
+func TestActiveJobInstances(t *testing.T) {
+	pages := []string{
+		`<job_instances total_record_count="3">` +
+			`<job_instance><id>1</id><status desc="Running">RUNNING</status></job_instance>` +
+			`<job_instance><id>2</id><status desc="Completed Successfully">COMPLETED_SUCCESS</status></job_instance>` +
+			`</job_instances>`,
+		`<job_instances total_record_count="3">` +
+			`<job_instance><id>3</id><status desc="Queued">QUEUED</status></job_instance>` +
+			`</job_instances>`,
+	}
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("offset") {
+		case "":
+			fmt.Fprint(w, pages[0])
+		case "2":
+			fmt.Fprint(w, pages[1])
+		default:
+			t.Errorf("Unexpected offset %q.", r.URL.Query().Get("offset"))
+		}
+	}))
+	defer server.Close()
+
+	client := newTestClient(t, server)
+	jobURL, err := client.URL("/almaws/v1/conf/jobs/M1?op=run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	active, err := client.ActiveJobInstances(context.Background(), jobURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(active) != 2 || active[0].ID != "1" || active[1].ID != "3" {
+		t.Fatalf("Expected instances 1 and 3 to be active, got %+v.", active)
+	}
+}
+
+func TestRetrySubmitJobUnknownSubmitter(t *testing.T) {
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	server, posts := ambiguousSubmitServer(t, `<job_instances total_record_count="1">`+
+		`<job_instance link="https://example.com/instances/1"><id>1</id><submit_time>`+now+`</submit_time><submitted_by>someone_else</submitted_by></job_instance>`+
+		`</job_instances>`)
+	defer server.Close()
+
+	// Without a submitter, the recent instance might have been started by staff in the Alma UI.
+	client := newTestClient(t, server, WithRetryPolicy(RetryPolicy{MaxRetries: 3, BaseDelay: time.Millisecond}))
+	jobURL, err := client.URL("/almaws/v1/conf/jobs/M1?op=run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	link, err := client.RetrySubmitJob(context.Background(), jobURL, AlmaJob{})
+	if !errors.Is(err, ErrSubmissionUnknown) || link != "" {
+		t.Fatalf("Expected ErrSubmissionUnknown, got %q, %v.", link, err)
+	}
+	if *posts != 1 {
+		t.Fatalf("Expected the job not to be submitted again, got %v submissions.", *posts)
+	}
+}
+
+func TestRetrySubmitJobLastAttemptAmbiguous(t *testing.T) {
+	posts := 0
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/almaws/v1/conf/jobs/M1/instances":
+			// The only submission reached Alma, even though its response was lost.
+			fmt.Fprintf(w, `<job_instances total_record_count="1"><job_instance link="https://example.com/instances/1"><id>1</id><submit_time>%v</submit_time><submitted_by>exl_api</submitted_by></job_instance></job_instances>`,
+				time.Now().UTC().Format(time.RFC3339Nano))
+		case r.Method == http.MethodPost:
+			posts++
+			w.WriteHeader(http.StatusGatewayTimeout)
+		default:
+			t.Errorf("Unexpected request %v %v.", r.Method, r.URL)
+		}
+	}))
+	defer server.Close()
+
+	client := newTestClient(t, server, WithSubmittedBy("exl_api"), WithRetryPolicy(RetryPolicy{MaxRetries: 1, BaseDelay: time.Millisecond}))
+	jobURL, err := client.URL("/almaws/v1/conf/jobs/M1?op=run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	link, err := client.RetrySubmitJob(context.Background(), jobURL, AlmaJob{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if posts != 1 || link != "https://example.com/instances/1" {
+		t.Fatalf("Expected the instance submitted by the last attempt to be adopted, got %v submissions and %q.", posts, link)
+	}
+}
@@ -2,7 +2,7 @@
 // Use of this source code is governed by the MIT
 // license that can be found in the LICENSE file.
 
-package main
+package alma
 
 import (
 	"encoding/xml"
@@ -27,6 +27,14 @@ type AlmaJob struct {
 	AdditionalInfo *LinkAndValue `xml:"additional_info,omitempty"`
 }
 
+// AlmaJobs is a type which maps XML data from the API about lists of jobs to Go structs.
+// https://developers.exlibrisgroup.com/alma/apis/docs/xsd/rest_jobs.xsd
+type AlmaJobs struct {
+	XMLName          xml.Name  `xml:"jobs"`
+	TotalRecordCount int       `xml:"total_record_count,attr"`
+	Jobs             []AlmaJob `xml:"job"`
+}
+
 // AlmaJobInstance is a type which maps XML data from the API about job instances to Go structs.
 // https://developers.exlibrisgroup.com/alma/apis/docs/xsd/rest_job_instance.xsd
 type AlmaJobInstance struct {
@@ -48,6 +56,47 @@ type AlmaJobInstance struct {
 	JobInfo     *AlmaJobInfo   `xml:"job_info"`
 }
 
+// Finished reports whether the job instance has ended. Instances which
+// are still finalizing have an end time, but aren't finished yet.
+func (i *AlmaJobInstance) Finished() bool {
+	return i.EndTime != "" && i.JobStatus() != StatusFinalizing
+}
+
+// Active reports whether the job instance is queued or running,
+// using the statuses Alma gives instances which haven't ended.
+func (i *AlmaJobInstance) Active() bool {
+	return i.JobStatus().IsActive()
+}
+
+// JobStatus returns the job instance's status, or an empty JobStatus if it has none.
+func (i *AlmaJobInstance) JobStatus() JobStatus {
+	if i.Status == nil {
+		return ""
+	}
+	return JobStatus(i.Status.Value)
+}
+
+// StatusDesc returns the description of the job instance's status, its value
+// if there is no description, or "Unknown status" if the instance has no status.
+func (i *AlmaJobInstance) StatusDesc() string {
+	switch {
+	case i.Status == nil:
+		return "Unknown status"
+	case i.Status.Desc == "":
+		return i.Status.Value
+	default:
+		return i.Status.Desc
+	}
+}
+
+// AlmaJobInstances is a type which maps XML data from the API about lists of job instances to Go structs.
+// https://developers.exlibrisgroup.com/alma/apis/docs/xsd/rest_job_instances.xsd
+type AlmaJobInstances struct {
+	XMLName          xml.Name          `xml:"job_instances"`
+	TotalRecordCount int               `xml:"total_record_count,attr"`
+	Instances        []AlmaJobInstance `xml:"job_instance"`
+}
+
 // AlmaJobInfo is a type which stores info about a job.
 type AlmaJobInfo struct {
 	Link        string        `xml:"link,attr,omitempty"`
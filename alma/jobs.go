@@ -0,0 +1,105 @@
+// Copyright 2024 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package alma
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+const (
+	// MaxJobsLimit is the largest number of jobs the Alma API returns in one request.
+	MaxJobsLimit = 100
+
+	// jobsPath is the path of the Alma jobs API.
+	jobsPath = "/almaws/v1/conf/jobs"
+)
+
+// JobsQuery filters the jobs returned by ListJobs.
+// Zero values aren't used as filters.
+type JobsQuery struct {
+	// Type is MANUAL, SCHEDULED or OTHER.
+	Type string
+	// Category is a job category code, like NORMALIZATION.
+	Category string
+	Limit    int
+	Offset   int
+}
+
+// ListJobs sends a GET HTTP request to the Alma API to get one page of the institution's jobs.
+func (c *Client) ListJobs(ctx context.Context, query JobsQuery) (*AlmaJobs, error) {
+	jobsURL, err := c.URL(jobsPath)
+	if err != nil {
+		return nil, err
+	}
+	values := url.Values{}
+	if query.Type != "" {
+		values.Set("type", query.Type)
+	}
+	if query.Category != "" {
+		values.Set("category", query.Category)
+	}
+	if query.Limit > 0 {
+		values.Set("limit", strconv.Itoa(query.Limit))
+	}
+	if query.Offset > 0 {
+		values.Set("offset", strconv.Itoa(query.Offset))
+	}
+	jobsURL.RawQuery = values.Encode()
+
+	jobs := &AlmaJobs{}
+	err = c.do(ctx, http.MethodGet, jobsURL, nil, jobs)
+	return jobs, err
+}
+
+// AllJobs returns every job matching the query, requesting each page
+// using the client's retry policy. The query's Limit and Offset are ignored.
+func (c *Client) AllJobs(ctx context.Context, query JobsQuery) ([]AlmaJob, error) {
+	query.Limit = MaxJobsLimit
+	query.Offset = 0
+	var jobs []AlmaJob
+	for {
+		var page *AlmaJobs
+		err := c.retry(ctx, "list jobs", func() (err error) {
+			page, err = c.ListJobs(ctx, query)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, page.Jobs...)
+		query.Offset += len(page.Jobs)
+		if len(page.Jobs) == 0 || query.Offset >= page.TotalRecordCount {
+			return jobs, nil
+		}
+	}
+}
+
+// GetJob sends a GET HTTP request to the Alma API to get the definition
+// of the job with the ID, like M47, including its parameters.
+func (c *Client) GetJob(ctx context.Context, id string) (*AlmaJob, error) {
+	jobURL, err := c.URL(JobPath(id))
+	if err != nil {
+		return nil, err
+	}
+	job := &AlmaJob{}
+	err = c.retry(ctx, "get job", func() error {
+		return c.do(ctx, http.MethodGet, jobURL, nil, job)
+	})
+	return job, err
+}
+
+// JobPath returns the path of the job with the ID, like /almaws/v1/conf/jobs/M47.
+func JobPath(id string) string {
+	return jobsPath + "/" + url.PathEscape(id)
+}
+
+// SubmitPath returns the path the job's parameters are POST'd to,
+// like /almaws/v1/conf/jobs/M47?op=run, which is used as the url setting.
+func SubmitPath(id string) string {
+	return JobPath(id) + "?op=run"
+}
@@ -0,0 +1,43 @@
+// Copyright 2024 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package alma
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestAllJobs(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/almaws/v1/conf/jobs" {
+			t.Errorf("Unexpected path %v.", r.URL.Path)
+		}
+		if r.URL.Query().Get("type") != "MANUAL" {
+			t.Errorf("Expected the type filter, got %q.", r.URL.RawQuery)
+		}
+		offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+		fmt.Fprintf(w, `<jobs total_record_count="2"><job link="https://example.com/almaws/v1/conf/jobs/M%v"><id>M%v</id><name>Job %v</name></job></jobs>`, offset, offset, offset)
+	}))
+	defer server.Close()
+
+	client := newTestClient(t, server)
+	jobs, err := client.AllJobs(context.Background(), JobsQuery{Type: "MANUAL"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 2 || jobs[0].ID != "M0" || jobs[1].ID != "M1" {
+		t.Fatalf("Expected both pages of jobs, got %+v.", jobs)
+	}
+}
+
+func TestSubmitPath(t *testing.T) {
+	if path := SubmitPath("M47"); path != "/almaws/v1/conf/jobs/M47?op=run" {
+		t.Fatalf("Unexpected submit path %q.", path)
+	}
+}
@@ -0,0 +1,17 @@
+// Copyright 2024 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+//go:build windows
+// +build windows
+
+package alma
+
+import "syscall"
+
+// The errors returned by the socket calls for connections which were reset or refused.
+// Package syscall doesn't define the Windows Sockets error for a refused connection.
+const (
+	errConnectionReset   = syscall.WSAECONNRESET
+	errConnectionRefused = syscall.Errno(10061)
+)
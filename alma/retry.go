@@ -0,0 +1,181 @@
+// Copyright 2024 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package alma
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults for the retry policy.
+const (
+	DefaultMaxRetries        = 5
+	DefaultBackoffBase       = 1 * time.Second
+	DefaultBackoffMultiplier = 2.0
+	DefaultBackoffMax        = 30 * time.Second
+	DefaultBackoffMaxElapsed = 5 * time.Minute
+)
+
+// RetryPolicy controls how requests which fail with a transient error are retried.
+//
+// The delay before retry n (starting at 0) is BaseDelay * Multiplier^n, capped at
+// MaxDelay. With Jitter, the delay is chosen at random between zero and that value
+// ("full jitter"), so that several runners which failed at the same moment don't
+// retry in lockstep. If a 429 or 503 response has a Retry-After header, the
+// runner waits at least that long.
+type RetryPolicy struct {
+	// MaxRetries is the maximum number of attempts.
+	MaxRetries int
+	BaseDelay  time.Duration
+	Multiplier float64
+	// MaxDelay caps the delay before each retry. Zero means no cap.
+	MaxDelay time.Duration
+	// MaxElapsed stops retrying when the next retry would start after this
+	// much time has passed since the first attempt. Zero means no limit.
+	MaxElapsed time.Duration
+	Jitter     bool
+}
+
+// DefaultRetryPolicy returns the retry policy used when none is provided.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxRetries: DefaultMaxRetries,
+		BaseDelay:  DefaultBackoffBase,
+		Multiplier: DefaultBackoffMultiplier,
+		MaxDelay:   DefaultBackoffMax,
+		MaxElapsed: DefaultBackoffMaxElapsed,
+		Jitter:     true,
+	}
+}
+
+// Backoff returns the delay before retry n, starting at 0, before jitter is applied.
+func (p RetryPolicy) Backoff(n int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	delay := float64(p.BaseDelay) * math.Pow(multiplier, float64(n))
+	if p.MaxDelay > 0 && delay > float64(p.MaxDelay) {
+		return p.MaxDelay
+	}
+	if delay > math.MaxInt64 {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Duration(delay)
+}
+
+// retry calls attempt until it succeeds, returns a permanent error, or the retry
+// policy runs out. The description is used in log messages.
+func (c *Client) retry(ctx context.Context, description string, attempt func() error) error {
+	policy := c.retryPolicy
+	start := time.Now()
+	var lastErr error
+	for n := 0; n < policy.MaxRetries; n++ {
+		err := attempt()
+		if err == nil {
+			return nil
+		}
+		lastErr = err
+		c.logger.Printf("Failed to %v: %v\n", description, err)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if !IsRetryable(err) {
+			c.logger.Println("The error is permanent, not retrying.")
+			return err
+		}
+		// Don't wait after the final attempt.
+		if n == policy.MaxRetries-1 {
+			break
+		}
+		delay := c.retryDelay(n, err)
+		if policy.MaxElapsed > 0 && time.Since(start)+delay > policy.MaxElapsed {
+			c.logger.Printf("Retrying in %v would take longer than %v in total, giving up.\n", delay, policy.MaxElapsed)
+			return &RetryError{Attempts: n + 1, Err: lastErr}
+		}
+		c.logger.Printf("Retrying in %v (%v/%v)\n", delay, n+1, policy.MaxRetries)
+		err = Sleep(ctx, delay)
+		if err != nil {
+			return err
+		}
+	}
+	return &RetryError{Attempts: policy.MaxRetries, Err: lastErr}
+}
+
+// retryDelay returns how long to wait before retry n, starting at 0, after err.
+func (c *Client) retryDelay(n int, err error) time.Duration {
+	delay := c.retryPolicy.Backoff(n)
+	if c.retryPolicy.Jitter {
+		delay = c.jitter.Duration(delay)
+	}
+	if retryAfter, ok := RetryAfter(err, time.Now()); ok && retryAfter > delay {
+		c.logger.Printf("The Alma API asked to wait %v before retrying.\n", retryAfter)
+		delay = retryAfter
+	}
+	return delay
+}
+
+// RetryAfter returns how long a 429 or 503 response asked the client to wait
+// using the Retry-After header, which is either a number of seconds or a date.
+func RetryAfter(err error, now time.Time) (time.Duration, bool) {
+	var responseErr *ResponseError
+	if !errors.As(err, &responseErr) || responseErr.Header == nil {
+		return 0, false
+	}
+	if responseErr.StatusCode != http.StatusTooManyRequests && responseErr.StatusCode != http.StatusServiceUnavailable {
+		return 0, false
+	}
+	value := strings.TrimSpace(responseErr.Header.Get("Retry-After"))
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	date, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	if date.Before(now) {
+		return 0, true
+	}
+	return date.Sub(now), true
+}
+
+// JitterSource returns random durations, which spread out retries and scheduled
+// runs. It is safe for concurrent use.
+type JitterSource struct {
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+// NewJitterSource returns a JitterSource seeded from the current time.
+func NewJitterSource() *JitterSource {
+	// Jitter spreads out requests, it doesn't need a cryptographically secure source.
+	return &JitterSource{rand: rand.New(rand.NewSource(time.Now().UnixNano()))} //nolint:gosec
+}
+
+// Duration returns a random duration in [0, max].
+func (j *JitterSource) Duration(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if max == math.MaxInt64 {
+		return time.Duration(j.rand.Int63())
+	}
+	return time.Duration(j.rand.Int63n(int64(max) + 1))
+}
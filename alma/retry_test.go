@@ -0,0 +1,143 @@
+// Copyright 2024 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package alma
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: time.Second, Multiplier: 2, MaxDelay: 5 * time.Second}
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for n, want := range expected {
+		got := policy.Backoff(n)
+		if got != want {
+			t.Errorf("Backoff(%v): expected %v, got %v.", n, want, got)
+		}
+	}
+}
+
+func TestJitterSource(t *testing.T) {
+	jitter := NewJitterSource()
+	if d := jitter.Duration(0); d != 0 {
+		t.Errorf("Expected no jitter, got %v.", d)
+	}
+	for i := 0; i < 1000; i++ {
+		d := jitter.Duration(2 * time.Nanosecond)
+		if d < 0 || d > 2*time.Nanosecond {
+			t.Fatalf("Expected a duration in [0, 2ns], got %v.", d)
+		}
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name       string
+		statusCode int
+		value      string
+		delay      time.Duration
+		ok         bool
+	}{
+		{"seconds", http.StatusTooManyRequests, "7", 7 * time.Second, true},
+		{"date", http.StatusServiceUnavailable, "Fri, 01 Mar 2024 12:01:00 GMT", time.Minute, true},
+		{"past date", http.StatusServiceUnavailable, "Fri, 01 Mar 2024 11:00:00 GMT", 0, true},
+		{"invalid", http.StatusTooManyRequests, "soon", 0, false},
+		{"missing", http.StatusTooManyRequests, "", 0, false},
+		{"other status", http.StatusInternalServerError, "7", 0, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			header := http.Header{}
+			if test.value != "" {
+				header.Set("Retry-After", test.value)
+			}
+			err := fmt.Errorf("wrapped: %w", &ResponseError{StatusCode: test.statusCode, Header: header})
+			delay, ok := RetryAfter(err, now)
+			if delay != test.delay || ok != test.ok {
+				t.Fatalf("Expected %v %v, got %v %v.", test.delay, test.ok, delay, ok)
+			}
+		})
+	}
+}
+
+func TestRetrySubmitJobTransientError(t *testing.T) {
+	requests := 0
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, `<job><additional_info link="https://example.com/instances/1">Job submitted.</additional_info></job>`)
+	}))
+	defer server.Close()
+
+	client := newTestClient(t, server, WithRetryPolicy(RetryPolicy{MaxRetries: 3, BaseDelay: time.Millisecond, Multiplier: 2, Jitter: true}))
+	jobURL, err := client.URL("/almaws/v1/conf/jobs/M1?op=run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	link, err := client.RetrySubmitJob(context.Background(), jobURL, AlmaJob{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests != 3 || link != "https://example.com/instances/1" {
+		t.Fatalf("Unexpected result after %v requests: %q.", requests, link)
+	}
+}
+
+func TestRetrySubmitJobMaxRetries(t *testing.T) {
+	requests := 0
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	// A large delay after the final attempt would make this test time out.
+	client := newTestClient(t, server, WithRetryPolicy(RetryPolicy{MaxRetries: 2, BaseDelay: time.Millisecond, Multiplier: 1000}))
+	jobURL, err := client.URL("/almaws/v1/conf/jobs/M1?op=run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.RetrySubmitJob(context.Background(), jobURL, AlmaJob{})
+	if !errors.Is(err, ErrMaxRetries) {
+		t.Fatalf("Expected ErrMaxRetries, got %v.", err)
+	}
+	if requests != 2 {
+		t.Fatalf("Expected 2 requests, got %v.", requests)
+	}
+}
+
+func TestRetryMaxElapsed(t *testing.T) {
+	requests := 0
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	client := newTestClient(t, server, WithRetryPolicy(RetryPolicy{MaxRetries: 5, BaseDelay: time.Millisecond, MaxElapsed: time.Second}))
+	jobURL, err := client.URL("/almaws/v1/conf/jobs/M1?op=run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.RetrySubmitJob(context.Background(), jobURL, AlmaJob{})
+	var retryErr *RetryError
+	if !errors.As(err, &retryErr) || retryErr.Attempts != 1 {
+		t.Fatalf("Expected to give up after 1 attempt, got %v.", err)
+	}
+	if requests != 1 {
+		t.Fatalf("Expected 1 request, got %v.", requests)
+	}
+}
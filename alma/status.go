@@ -0,0 +1,61 @@
+// Copyright 2024 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package alma
+
+// JobStatus is the status of a job instance, the value of its status element.
+type JobStatus string
+
+// The job instance statuses documented by Ex Libris.
+const (
+	StatusQueued           JobStatus = "QUEUED"
+	StatusPending          JobStatus = "PENDING"
+	StatusInitializing     JobStatus = "INITIALIZING"
+	StatusRunning          JobStatus = "RUNNING"
+	StatusFinalizing       JobStatus = "FINALIZING"
+	StatusCompletedSuccess JobStatus = "COMPLETED_SUCCESS"
+	StatusCompletedNoBulks JobStatus = "COMPLETED_NO_BULKS"
+	StatusCompletedWarning JobStatus = "COMPLETED_WARNING"
+	StatusCompletedFailed  JobStatus = "COMPLETED_FAILED"
+	StatusFailed           JobStatus = "FAILED"
+	StatusSystemAborted    JobStatus = "SYSTEM_ABORTED"
+	StatusManualAborted    JobStatus = "MANUAL_ABORTED"
+	StatusSkipped          JobStatus = "SKIPPED"
+)
+
+// IsActive reports whether the status is one Alma gives instances which haven't ended.
+func (s JobStatus) IsActive() bool {
+	switch s {
+	case StatusQueued, StatusPending, StatusInitializing, StatusRunning, StatusFinalizing:
+		return true
+	default:
+		return false
+	}
+}
+
+// IsTerminal reports whether the status is one Alma gives instances which have ended.
+func (s JobStatus) IsTerminal() bool {
+	return s.IsSuccess() || s.IsWarning() || s.IsFailure()
+}
+
+// IsSuccess reports whether the job completed successfully,
+// including when it had nothing to process.
+func (s JobStatus) IsSuccess() bool {
+	return s == StatusCompletedSuccess || s == StatusCompletedNoBulks
+}
+
+// IsWarning reports whether the job completed with warnings, or was skipped by Alma.
+func (s JobStatus) IsWarning() bool {
+	return s == StatusCompletedWarning || s == StatusSkipped
+}
+
+// IsFailure reports whether the job failed or was aborted.
+func (s JobStatus) IsFailure() bool {
+	switch s {
+	case StatusCompletedFailed, StatusFailed, StatusSystemAborted, StatusManualAborted:
+		return true
+	default:
+		return false
+	}
+}
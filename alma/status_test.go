@@ -0,0 +1,32 @@
+// Copyright 2024 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package alma
+
+import (
+	"testing"
+)
+
+func TestJobStatus(t *testing.T) {
+	tests := []struct {
+		status                                   JobStatus
+		active, terminal, success, warning, fail bool
+	}{
+		{StatusQueued, true, false, false, false, false},
+		{StatusFinalizing, true, false, false, false, false},
+		{StatusCompletedSuccess, false, true, true, false, false},
+		{StatusCompletedNoBulks, false, true, true, false, false},
+		{StatusCompletedWarning, false, true, false, true, false},
+		{StatusCompletedFailed, false, true, false, false, true},
+		{StatusManualAborted, false, true, false, false, true},
+		{"UNKNOWN", false, false, false, false, false},
+	}
+	for _, test := range tests {
+		s := test.status
+		if s.IsActive() != test.active || s.IsTerminal() != test.terminal || s.IsSuccess() != test.success ||
+			s.IsWarning() != test.warning || s.IsFailure() != test.fail {
+			t.Errorf("Unexpected classification of %v.", s)
+		}
+	}
+}
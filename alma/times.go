@@ -0,0 +1,98 @@
+// Copyright 2024 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package alma
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrInvalidTime is returned when a timestamp returned by the Alma API can't be parsed.
+var ErrInvalidTime = errors.New("invalid timestamp")
+
+// ParseTime parses a timestamp or date returned by the Alma API, like 2015-04-15T13:07:44.359Z
+// or 2015-04-15Z. Values without a time zone are in UTC.
+func ParseTime(value string) (time.Time, error) {
+	layouts := []string{
+		time.RFC3339Nano,
+		"2006-01-02T15:04:05.999999999",
+		"2006-01-02Z07:00",
+		"2006-01-02",
+	}
+	for _, layout := range layouts {
+		parsed, err := time.Parse(layout, value)
+		if err == nil {
+			return parsed, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%w: %q", ErrInvalidTime, value)
+}
+
+// JobTimes stores the parsed timestamps of a job instance.
+// Times which are missing or can't be parsed are zero.
+type JobTimes struct {
+	Submit time.Time
+	Start  time.Time
+	End    time.Time
+	Status time.Time
+}
+
+// Times returns the parsed timestamps of the job instance.
+func (i *AlmaJobInstance) Times() JobTimes {
+	parse := func(value string) time.Time {
+		parsed, err := ParseTime(value)
+		if err != nil {
+			return time.Time{}
+		}
+		return parsed
+	}
+	return JobTimes{
+		Submit: parse(i.SubmitTime),
+		Start:  parse(i.StartTime),
+		End:    parse(i.EndTime),
+		Status: parse(i.StatusDate),
+	}
+}
+
+// RunDuration returns how long the job instance ran, from its start time to
+// its end time. It reports false if either time is missing or can't be parsed.
+func (i *AlmaJobInstance) RunDuration() (time.Duration, bool) {
+	return i.Times().RunDuration()
+}
+
+// In returns the times in the location, for display.
+func (t JobTimes) In(loc *time.Location) JobTimes {
+	in := func(value time.Time) time.Time {
+		if value.IsZero() {
+			return value
+		}
+		return value.In(loc)
+	}
+	return JobTimes{Submit: in(t.Submit), Start: in(t.Start), End: in(t.End), Status: in(t.Status)}
+}
+
+// QueueDuration returns how long the job instance waited to start, from its submit time to its start time.
+func (t JobTimes) QueueDuration() (time.Duration, bool) {
+	return between(t.Submit, t.Start)
+}
+
+// RunDuration returns how long the job instance ran, from its start time to its end time.
+func (t JobTimes) RunDuration() (time.Duration, bool) {
+	return between(t.Start, t.End)
+}
+
+// WallDuration returns the total time the job instance took, from its submit time to its end time.
+func (t JobTimes) WallDuration() (time.Duration, bool) {
+	return between(t.Submit, t.End)
+}
+
+// between returns the time from start to end. It reports false if either time is zero.
+func between(start, end time.Time) (time.Duration, bool) {
+	if start.IsZero() || end.IsZero() {
+		return 0, false
+	}
+	return end.Sub(start), true
+}
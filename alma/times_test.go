@@ -0,0 +1,62 @@
+// Copyright 2024 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package alma
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseTime(t *testing.T) {
+	expected := map[string]time.Time{
+		"2015-04-15T13:07:44.359Z":  time.Date(2015, time.April, 15, 13, 7, 44, 359000000, time.UTC),
+		"2015-04-15T09:07:44-04:00": time.Date(2015, time.April, 15, 13, 7, 44, 0, time.UTC),
+		"2015-04-15T13:07:44":       time.Date(2015, time.April, 15, 13, 7, 44, 0, time.UTC),
+		"2015-04-15Z":               time.Date(2015, time.April, 15, 0, 0, 0, 0, time.UTC),
+		"2015-04-15":                time.Date(2015, time.April, 15, 0, 0, 0, 0, time.UTC),
+	}
+	for value, want := range expected {
+		parsed, err := ParseTime(value)
+		if err != nil || !parsed.Equal(want) {
+			t.Errorf("%v: expected %v, got %v, %v.", value, want, parsed, err)
+		}
+	}
+	_, err := ParseTime("yesterday")
+	if !errors.Is(err, ErrInvalidTime) {
+		t.Errorf("Expected ErrInvalidTime, got %v.", err)
+	}
+}
+
+func TestJobTimes(t *testing.T) {
+	instance := &AlmaJobInstance{
+		SubmitTime: "2015-04-15T13:07:30.359Z",
+		StartTime:  "2015-04-15T13:07:40.359Z",
+		EndTime:    "2015-04-15T13:09:44.359Z",
+		StatusDate: "2015-04-15Z",
+	}
+	times := instance.Times()
+	if d, ok := times.QueueDuration(); !ok || d != 10*time.Second {
+		t.Errorf("Expected a queue duration of 10s, got %v, %v.", d, ok)
+	}
+	if d, ok := instance.RunDuration(); !ok || d != 2*time.Minute+4*time.Second {
+		t.Errorf("Expected a run duration of 2m4s, got %v, %v.", d, ok)
+	}
+	if d, ok := times.WallDuration(); !ok || d != 2*time.Minute+14*time.Second {
+		t.Errorf("Expected a wall duration of 2m14s, got %v, %v.", d, ok)
+	}
+	loc := time.FixedZone("EST", -5*60*60)
+	if local := times.In(loc); local.Start.Hour() != 8 || !local.Start.Equal(times.Start) {
+		t.Errorf("Expected the start time in EST, got %v.", local.Start)
+	}
+
+	instance.EndTime = ""
+	if _, ok := instance.RunDuration(); ok {
+		t.Error("Expected no run duration for an instance which hasn't ended.")
+	}
+	if !instance.Times().In(loc).End.IsZero() {
+		t.Error("Expected a missing time to stay zero.")
+	}
+}
@@ -0,0 +1,209 @@
+// Copyright 2024 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package alma
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+)
+
+const (
+	// WebhookSignatureHeader is the header Alma uses to send the signature of a webhook's body.
+	WebhookSignatureHeader = "X-Exl-Signature"
+
+	// JobEndAction is the action of the webhook Alma sends when a job instance ends.
+	JobEndAction = "JOB_END"
+
+	// maxWebhookBodySize is the largest webhook body which is read.
+	maxWebhookBodySize = 1 << 20
+
+	// maxUnclaimedEvents is the number of job end events kept for instances
+	// nobody is waiting on yet, in case the webhook arrives before the waiter.
+	maxUnclaimedEvents = 100
+)
+
+// WebhookEvent is a webhook sent by Alma. Only the fields used
+// for job end events are decoded.
+// https://developers.exlibrisgroup.com/alma/integrations/webhooks/jobs/
+type WebhookEvent struct {
+	ID          string              `json:"id"`
+	Action      string              `json:"action"`
+	Time        string              `json:"time"`
+	JobInstance *WebhookJobInstance `json:"job_instance"`
+}
+
+// WebhookJobInstance is the job instance included in a job end webhook.
+type WebhookJobInstance struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Status struct {
+		Value string `json:"value"`
+		Desc  string `json:"desc"`
+	} `json:"status"`
+}
+
+// VerifyWebhookSignature reports whether signature is the base64 encoded
+// HMAC-SHA256 of body, using the secret configured for the webhook in Alma.
+func VerifyWebhookSignature(body []byte, signature, secret string) bool {
+	expected, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write(body)
+	return hmac.Equal(expected, mac.Sum(nil))
+}
+
+// WebhookListener is an http.Handler which receives Alma webhooks.
+// It answers the challenge Alma sends when the webhook is configured,
+// verifies the signature of each webhook, and hands job end events to
+// the goroutines waiting on those job instances.
+type WebhookListener struct {
+	secret string
+	logger *log.Logger
+
+	mu        sync.Mutex
+	waiting   map[string]chan *WebhookEvent
+	unclaimed map[string]*WebhookEvent
+	order     []string
+}
+
+// NewWebhookListener returns a WebhookListener which verifies webhooks
+// using secret. If logger is nil, nothing is logged.
+func NewWebhookListener(secret string, logger *log.Logger) *WebhookListener {
+	if logger == nil {
+		logger = log.New(io.Discard, "", 0)
+	}
+	return &WebhookListener{
+		secret:    secret,
+		logger:    logger,
+		waiting:   map[string]chan *WebhookEvent{},
+		unclaimed: map[string]*WebhookEvent{},
+	}
+}
+
+// SetLogger sets the logger used to report webhook deliveries,
+// so that they can be logged with the run waiting on them.
+func (l *WebhookListener) SetLogger(logger *log.Logger) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.logger = logger
+}
+
+// log writes a message to the listener's logger.
+func (l *WebhookListener) log(v ...interface{}) {
+	l.mu.Lock()
+	logger := l.logger
+	l.mu.Unlock()
+	logger.Println(v...)
+}
+
+// ServeHTTP handles the challenge handshake and webhook deliveries.
+func (l *WebhookListener) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		// Alma checks the listener by sending a challenge, which must be echoed back.
+		challenge := r.URL.Query().Get("challenge")
+		if challenge == "" {
+			http.Error(w, "missing challenge", http.StatusBadRequest)
+			return
+		}
+		l.log("Answering webhook challenge.")
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]string{"challenge": challenge})
+	case http.MethodPost:
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookBodySize))
+		if err != nil {
+			http.Error(w, "couldn't read body", http.StatusBadRequest)
+			return
+		}
+		if !VerifyWebhookSignature(body, r.Header.Get(WebhookSignatureHeader), l.secret) {
+			l.log("Rejected webhook with an invalid signature.")
+			http.Error(w, "invalid signature", http.StatusUnauthorized)
+			return
+		}
+		event := &WebhookEvent{}
+		err = json.Unmarshal(body, event)
+		if err != nil {
+			http.Error(w, "couldn't decode body", http.StatusBadRequest)
+			return
+		}
+		if event.Action == JobEndAction && event.JobInstance != nil {
+			l.deliver(event)
+		}
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// deliver hands a job end event to the goroutine waiting on its job instance,
+// or keeps it in case a goroutine starts waiting soon.
+func (l *WebhookListener) deliver(event *WebhookEvent) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	id := event.JobInstance.ID
+	l.logger.Printf("Received job end webhook for job instance %v.\n", id)
+	if ch, ok := l.waiting[id]; ok {
+		delete(l.waiting, id)
+		ch <- event
+		return
+	}
+	if _, ok := l.unclaimed[id]; !ok {
+		l.order = append(l.order, id)
+	}
+	l.unclaimed[id] = event
+	if len(l.order) > maxUnclaimedEvents {
+		delete(l.unclaimed, l.order[0])
+		l.order = l.order[1:]
+	}
+}
+
+// claim removes and returns the unclaimed event for a job instance. l.mu must be held.
+func (l *WebhookListener) claim(instanceID string) (*WebhookEvent, bool) {
+	event, ok := l.unclaimed[instanceID]
+	if !ok {
+		return nil, false
+	}
+	delete(l.unclaimed, instanceID)
+	for i, id := range l.order {
+		if id == instanceID {
+			l.order = append(l.order[:i], l.order[i+1:]...)
+			break
+		}
+	}
+	return event, true
+}
+
+// WaitForJobEnd blocks until a job end event for the job instance
+// is received, or the context is done.
+func (l *WebhookListener) WaitForJobEnd(ctx context.Context, instanceID string) (*WebhookEvent, error) {
+	l.mu.Lock()
+	if event, ok := l.claim(instanceID); ok {
+		l.mu.Unlock()
+		return event, nil
+	}
+	ch := make(chan *WebhookEvent, 1)
+	l.waiting[instanceID] = ch
+	l.mu.Unlock()
+
+	select {
+	case event := <-ch:
+		return event, nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		delete(l.waiting, instanceID)
+		l.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright 2024 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package alma
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testWebhookSecret = "webhooksecret"
+
+// signedWebhook returns a webhook request for body, signed with secret.
+func signedWebhook(body, secret string) *http.Request {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write([]byte(body))
+	request := httptest.NewRequest(http.MethodPost, "/webhooks", bytes.NewBufferString(body))
+	request.Header.Set(WebhookSignatureHeader, base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+	return request
+}
+
+func TestWebhookListenerChallenge(t *testing.T) {
+	listener := NewWebhookListener(testWebhookSecret, nil)
+	recorder := httptest.NewRecorder()
+	listener.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/webhooks?challenge=abc123", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Unexpected status %v.", recorder.Code)
+	}
+	if strings.TrimSpace(recorder.Body.String()) != `{"challenge":"abc123"}` {
+		t.Fatalf("Unexpected challenge response %q.", recorder.Body.String())
+	}
+}
+
+func TestWebhookListenerRejectsInvalidSignature(t *testing.T) {
+	listener := NewWebhookListener(testWebhookSecret, nil)
+	recorder := httptest.NewRecorder()
+	body := `{"action":"JOB_END","job_instance":{"id":"1"}}`
+	listener.ServeHTTP(recorder, signedWebhook(body, "wrongsecret"))
+	if recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected %v, got %v.", http.StatusUnauthorized, recorder.Code)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := listener.WaitForJobEnd(ctx, "1")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected the unsigned event to be ignored, got %v.", err)
+	}
+}
+
+func TestWebhookListenerDeliversJobEnd(t *testing.T) {
+	listener := NewWebhookListener(testWebhookSecret, nil)
+
+	// An event which arrives before anyone waits is kept.
+	early := `{"action":"JOB_END","job_instance":{"id":"1","status":{"value":"COMPLETED_SUCCESS"}}}`
+	recorder := httptest.NewRecorder()
+	listener.ServeHTTP(recorder, signedWebhook(early, testWebhookSecret))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Unexpected status %v.", recorder.Code)
+	}
+	event, err := listener.WaitForJobEnd(context.Background(), "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.JobInstance.Status.Value != "COMPLETED_SUCCESS" {
+		t.Fatalf("Unexpected status %q.", event.JobInstance.Status.Value)
+	}
+
+	// An event which arrives while waiting is handed over.
+	done := make(chan *WebhookEvent)
+	go func() {
+		event, err := listener.WaitForJobEnd(context.Background(), "2")
+		if err != nil {
+			t.Error(err)
+		}
+		done <- event
+	}()
+	for {
+		listener.mu.Lock()
+		_, waiting := listener.waiting["2"]
+		listener.mu.Unlock()
+		if waiting {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	late := `{"action":"JOB_END","job_instance":{"id":"2","status":{"value":"COMPLETED_FAILED"}}}`
+	listener.ServeHTTP(httptest.NewRecorder(), signedWebhook(late, testWebhookSecret))
+	event = <-done
+	if event.JobInstance.Status.Value != "COMPLETED_FAILED" {
+		t.Fatalf("Unexpected status %q.", event.JobInstance.Status.Value)
+	}
+}
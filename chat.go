@@ -0,0 +1,272 @@
+// Copyright 2024 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const (
+	// DefaultChatEvents are the events Slack and Teams messages are sent for by default.
+	DefaultChatEvents = "success,warning,failure"
+
+	// maxChatCounters is the most counters shown in a Slack or Teams message.
+	maxChatCounters = 10
+)
+
+// ChatSummary is the content of a Slack or Teams message about a run.
+type ChatSummary struct {
+	Title    string
+	Event    string
+	Status   string
+	Duration string
+	Counters []InstanceCounter
+	// MoreCounters is how many counters were left out.
+	MoreCounters int
+	Alerts       []string
+	Error        string
+	Link         string
+}
+
+// NewChatSummary returns the content of a Slack or Teams message about the notification.
+func NewChatSummary(notification *Notification) *ChatSummary {
+	summary := &ChatSummary{
+		Title: notification.Name,
+		Event: notification.Event,
+		Error: notification.Error,
+		Link:  notification.InstanceURL,
+	}
+	switch notification.Event {
+	case EventStart:
+		summary.Status = "Submitted"
+	case EventFailure:
+		summary.Status = "Failed"
+	}
+	if instance := notification.Instance; instance != nil {
+		summary.Status = instance.StatusDesc
+		if summary.Status == "" {
+			summary.Status = instance.Status
+		}
+		summary.Duration = instance.RunDuration
+		if summary.Duration == "" {
+			summary.Duration = instance.WallDuration
+		}
+		summary.Counters = instance.Counters
+		if len(summary.Counters) > maxChatCounters {
+			summary.MoreCounters = len(summary.Counters) - maxChatCounters
+			summary.Counters = summary.Counters[:maxChatCounters]
+		}
+		summary.Alerts = instance.Alerts
+	}
+	return summary
+}
+
+// counterName returns the description of the counter, or its type if it has no description.
+func counterName(counter InstanceCounter) string {
+	if counter.Desc != "" {
+		return counter.Desc
+	}
+	return counter.Type
+}
+
+// newSlackNotifier returns the Slack notifier configured in rc, or nil if no Slack webhook URLs are set.
+func (rc *RunConfig) newSlackNotifier() (*Notifier, error) {
+	notifier, err := rc.newBaseNotifier("Slack", rc.SlackURLs, rc.SlackEvents)
+	if notifier != nil {
+		notifier.Builder = SlackPayload
+	}
+	return notifier, err
+}
+
+// newTeamsNotifier returns the Teams notifier configured in rc, or nil if no Teams webhook URLs are set.
+func (rc *RunConfig) newTeamsNotifier() (*Notifier, error) {
+	notifier, err := rc.newBaseNotifier("Teams", rc.TeamsURLs, rc.TeamsEvents)
+	if notifier != nil {
+		notifier.Builder = TeamsPayload
+	}
+	return notifier, err
+}
+
+// slackText is a Block Kit text object.
+type slackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// slackBlock is a Block Kit header, section or context block.
+type slackBlock struct {
+	Type     string      `json:"type"`
+	Text     *slackText  `json:"text,omitempty"`
+	Fields   []slackText `json:"fields,omitempty"`
+	Elements []slackText `json:"elements,omitempty"`
+}
+
+// slackEscape escapes the characters Slack's mrkdwn uses for links and mentions.
+func slackEscape(text string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(text)
+}
+
+// slackEmoji returns the emoji shown before the status of the event.
+func slackEmoji(event string) string {
+	switch event {
+	case EventStart:
+		return ":arrow_forward:"
+	case EventSuccess:
+		return ":white_check_mark:"
+	case EventWarning:
+		return ":warning:"
+	default:
+		return ":x:"
+	}
+}
+
+// SlackPayload returns a Slack incoming webhook message about the notification, using Block Kit.
+func SlackPayload(notification *Notification) ([]byte, error) {
+	summary := NewChatSummary(notification)
+	mrkdwn := func(text string) *slackText {
+		return &slackText{Type: "mrkdwn", Text: text}
+	}
+	fields := []slackText{*mrkdwn(fmt.Sprintf("*Status*\n%v %v", slackEmoji(summary.Event), slackEscape(summary.Status)))}
+	if summary.Duration != "" {
+		fields = append(fields, *mrkdwn("*Duration*\n" + summary.Duration))
+	}
+	blocks := []slackBlock{
+		{Type: "header", Text: &slackText{Type: "plain_text", Text: summary.Title}},
+		{Type: "section", Fields: fields},
+	}
+	if len(summary.Counters) > 0 {
+		lines := []string{"*Counters*"}
+		for _, counter := range summary.Counters {
+			lines = append(lines, fmt.Sprintf("%v: %v", slackEscape(counterName(counter)), slackEscape(counter.Value)))
+		}
+		if summary.MoreCounters > 0 {
+			lines = append(lines, fmt.Sprintf("_and %v more_", summary.MoreCounters))
+		}
+		blocks = append(blocks, slackBlock{Type: "section", Text: mrkdwn(strings.Join(lines, "\n"))})
+	}
+	if len(summary.Alerts) > 0 {
+		lines := []string{"*Alerts*"}
+		for _, alert := range summary.Alerts {
+			lines = append(lines, "• "+slackEscape(alert))
+		}
+		blocks = append(blocks, slackBlock{Type: "section", Text: mrkdwn(strings.Join(lines, "\n"))})
+	}
+	if summary.Error != "" {
+		blocks = append(blocks, slackBlock{Type: "section", Text: mrkdwn("*Error*\n" + slackEscape(summary.Error))})
+	}
+	if summary.Link != "" {
+		blocks = append(blocks, slackBlock{Type: "context", Elements: []slackText{*mrkdwn(fmt.Sprintf("<%v|View job instance>", summary.Link))}})
+	}
+	return json.Marshal(struct {
+		Text   string       `json:"text"`
+		Blocks []slackBlock `json:"blocks"`
+	}{
+		Text:   fmt.Sprintf("%v: %v", summary.Title, summary.Status),
+		Blocks: blocks,
+	})
+}
+
+// teamsColour returns the Adaptive Card colour of the status of the event.
+func teamsColour(event string) string {
+	switch event {
+	case EventSuccess:
+		return "Good"
+	case EventWarning:
+		return "Warning"
+	case EventFailure:
+		return "Attention"
+	default:
+		return "Default"
+	}
+}
+
+// teamsFact is a fact in an Adaptive Card fact set.
+type teamsFact struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+}
+
+// teamsElement is an Adaptive Card text block or fact set.
+type teamsElement struct {
+	Type    string      `json:"type"`
+	Text    string      `json:"text,omitempty"`
+	Weight  string      `json:"weight,omitempty"`
+	Size    string      `json:"size,omitempty"`
+	Color   string      `json:"color,omitempty"`
+	Wrap    bool        `json:"wrap,omitempty"`
+	Spacing string      `json:"spacing,omitempty"`
+	Facts   []teamsFact `json:"facts,omitempty"`
+}
+
+// teamsAction is an Adaptive Card action which opens a URL.
+type teamsAction struct {
+	Type  string `json:"type"`
+	Title string `json:"title"`
+	URL   string `json:"url"`
+}
+
+// teamsCard is an Adaptive Card.
+type teamsCard struct {
+	Schema  string         `json:"$schema"`
+	Type    string         `json:"type"`
+	Version string         `json:"version"`
+	Body    []teamsElement `json:"body"`
+	Actions []teamsAction  `json:"actions,omitempty"`
+}
+
+// teamsAttachment is a message attachment holding an Adaptive Card.
+type teamsAttachment struct {
+	ContentType string    `json:"contentType"`
+	Content     teamsCard `json:"content"`
+}
+
+// TeamsPayload returns a Teams webhook message about the notification, using an Adaptive Card.
+func TeamsPayload(notification *Notification) ([]byte, error) {
+	summary := NewChatSummary(notification)
+	body := []teamsElement{
+		{Type: "TextBlock", Text: summary.Title, Weight: "Bolder", Size: "Medium", Wrap: true},
+		{Type: "TextBlock", Text: summary.Status, Weight: "Bolder", Color: teamsColour(summary.Event), Wrap: true, Spacing: "None"},
+	}
+	var facts []teamsFact
+	if summary.Duration != "" {
+		facts = append(facts, teamsFact{Title: "Duration", Value: summary.Duration})
+	}
+	for _, counter := range summary.Counters {
+		facts = append(facts, teamsFact{Title: counterName(counter), Value: counter.Value})
+	}
+	if summary.MoreCounters > 0 {
+		facts = append(facts, teamsFact{Title: "Other counters", Value: fmt.Sprintf("%v more", summary.MoreCounters)})
+	}
+	if len(facts) > 0 {
+		body = append(body, teamsElement{Type: "FactSet", Facts: facts})
+	}
+	if len(summary.Alerts) > 0 {
+		body = append(body,
+			teamsElement{Type: "TextBlock", Text: "Alerts", Weight: "Bolder"},
+			teamsElement{Type: "TextBlock", Text: "- " + strings.Join(summary.Alerts, "\n- "), Wrap: true, Spacing: "None"},
+		)
+	}
+	if summary.Error != "" {
+		body = append(body, teamsElement{Type: "TextBlock", Text: summary.Error, Color: "Attention", Wrap: true})
+	}
+	card := teamsCard{
+		Schema:  "http://adaptivecards.io/schemas/adaptive-card.json",
+		Type:    "AdaptiveCard",
+		Version: "1.4",
+		Body:    body,
+	}
+	if summary.Link != "" {
+		card.Actions = []teamsAction{{Type: "Action.OpenUrl", Title: "View job instance", URL: summary.Link}}
+	}
+	return json.Marshal(struct {
+		Type        string            `json:"type"`
+		Attachments []teamsAttachment `json:"attachments"`
+	}{
+		Type:        "message",
+		Attachments: []teamsAttachment{{ContentType: "application/vnd.microsoft.card.adaptive", Content: card}},
+	})
+}
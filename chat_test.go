@@ -0,0 +1,146 @@
+// Copyright 2024 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// testNotification returns the warning notification of a job instance with alerts and the given number of counters.
+func testNotification(counters int) *Notification {
+	output := &InstanceOutput{
+		Status:      "COMPLETED_WARNING",
+		StatusDesc:  "Completed with Warnings",
+		RunDuration: "2m0s",
+		Alerts:      []string{"Some records <skipped>"},
+	}
+	for i := 1; i <= counters; i++ {
+		output.Counters = append(output.Counters, InstanceCounter{Type: fmt.Sprintf("label.counter%v", i), Desc: fmt.Sprintf("Counter %v", i), Value: fmt.Sprint(i)})
+	}
+	return &Notification{
+		Event:       EventWarning,
+		Name:        "Nightly export",
+		InstanceURL: "https://example.com/almaws/v1/conf/jobs/M47/instances/12345",
+		Instance:    output,
+	}
+}
+
+func TestSlackPayload(t *testing.T) {
+	payload, err := SlackPayload(testNotification(12))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var message struct {
+		Text   string       `json:"text"`
+		Blocks []slackBlock `json:"blocks"`
+	}
+	err = json.Unmarshal(payload, &message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message.Text != "Nightly export: Completed with Warnings" || message.Blocks[0].Type != "header" || message.Blocks[0].Text.Text != "Nightly export" {
+		t.Errorf("Unexpected message %+v.", message)
+	}
+	text := blocksText(message.Blocks)
+	for _, expected := range []string{
+		":warning: Completed with Warnings",
+		"*Duration*\n2m0s",
+		"Counter 10: 10",
+		"_and 2 more_",
+		"• Some records &lt;skipped&gt;",
+		"<https://example.com/almaws/v1/conf/jobs/M47/instances/12345|View job instance>",
+	} {
+		if !strings.Contains(text, expected) {
+			t.Errorf("Expected %q in the message:\n%s", expected, payload)
+		}
+	}
+	if strings.Contains(text, "Counter 11") {
+		t.Errorf("Expected at most %v counters in the message:\n%s", maxChatCounters, payload)
+	}
+}
+
+// blocksText returns the text of the blocks.
+func blocksText(blocks []slackBlock) string {
+	var text []string
+	for _, block := range blocks {
+		if block.Text != nil {
+			text = append(text, block.Text.Text)
+		}
+		for _, field := range append(block.Fields, block.Elements...) {
+			text = append(text, field.Text)
+		}
+	}
+	return strings.Join(text, "\n")
+}
+
+func TestTeamsPayload(t *testing.T) {
+	payload, err := TeamsPayload(testNotification(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var message struct {
+		Type        string            `json:"type"`
+		Attachments []teamsAttachment `json:"attachments"`
+	}
+	err = json.Unmarshal(payload, &message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message.Type != "message" || len(message.Attachments) != 1 || message.Attachments[0].ContentType != "application/vnd.microsoft.card.adaptive" {
+		t.Fatalf("Unexpected message:\n%s", payload)
+	}
+	card := message.Attachments[0].Content
+	if card.Type != "AdaptiveCard" || card.Body[1].Text != "Completed with Warnings" || card.Body[1].Color != "Warning" {
+		t.Errorf("Unexpected card:\n%s", payload)
+	}
+	expectedFacts := []teamsFact{{Title: "Duration", Value: "2m0s"}, {Title: "Counter 1", Value: "1"}}
+	if fmt.Sprint(card.Body[2].Facts) != fmt.Sprint(expectedFacts) {
+		t.Errorf("Expected the facts %v, got %v.", expectedFacts, card.Body[2].Facts)
+	}
+	if card.Body[4].Text != "- Some records <skipped>" {
+		t.Errorf("Unexpected alerts %q.", card.Body[4].Text)
+	}
+	if len(card.Actions) != 1 || card.Actions[0].URL != "https://example.com/almaws/v1/conf/jobs/M47/instances/12345" {
+		t.Errorf("Unexpected actions %+v.", card.Actions)
+	}
+}
+
+func TestNotifyRouting(t *testing.T) {
+	received := map[string][]string{}
+	newServer := func(name string) *httptest.Server {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			received[name] = append(received[name], string(body))
+		}))
+		t.Cleanup(server.Close)
+		return server
+	}
+	slack, teams := newServer("slack"), newServer("teams")
+	rc := &RunConfig{
+		Name:           "Nightly export",
+		SlackURLs:      slack.URL,
+		SlackEvents:    "failure",
+		TeamsURLs:      teams.URL,
+		TeamsEvents:    DefaultChatEvents,
+		NotifyAttempts: 1,
+	}
+	notifiers := rc.newRunNotifiers(log.New(io.Discard, "", 0))
+	notifiers.notify(EventStart, nil, nil, nil)
+	notifiers.notify(EventWarning, nil, testNotification(1).Instance, nil)
+	notifiers.notify(EventFailure, nil, nil, ErrAlreadyRunning)
+	if len(received["slack"]) != 1 || !strings.Contains(received["slack"][0], ErrAlreadyRunning.Error()) {
+		t.Errorf("Expected only the failure message in Slack, got %q.", received["slack"])
+	}
+	if len(received["teams"]) != 2 || !strings.Contains(received["teams"][0], "Completed with Warnings") {
+		t.Errorf("Expected the warning and failure messages in Teams, got %q.", received["teams"])
+	}
+}
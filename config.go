@@ -0,0 +1,349 @@
+// Copyright 2024 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// DefaultsSection is the name of the configuration file section which applies to all jobs.
+	DefaultsSection = "defaults"
+
+	// JobsSectionPrefix is the prefix of configuration file sections which apply to one job.
+	JobsSectionPrefix = "jobs."
+)
+
+var (
+	// ErrInvalidConfig is returned when a configuration file can't be parsed.
+	ErrInvalidConfig = errors.New("invalid configuration file")
+
+	// ErrUnknownJob is returned when a job is not defined in the configuration file.
+	ErrUnknownJob = errors.New("job not found in configuration file")
+)
+
+// Config stores the settings read from a configuration file.
+//
+// The configuration file uses a subset of TOML. Keys are flag names,
+// and values are strings, integers, booleans, or arrays of those,
+// which are joined with commas. Settings in the [defaults] section
+// apply to every job, and settings in a [jobs.<name>] section apply
+// to the named job only.
+//
+//	[defaults]
+//	domain = "api-ca.hosted.exlibrisgroup.com"
+//	email = true
+//	mailto = ["systems@example.com", "eresources@example.com"]
+//
+//	[jobs.weekly-export]
+//	url = "/almaws/v1/conf/jobs/M47?op=run"
+//	params = "/etc/alma/weekly-export.xml"
+//	retries = 3
+type Config struct {
+	Defaults map[string]string
+	Jobs     map[string]map[string]string
+}
+
+// LoadConfig reads and parses the configuration file at path.
+func LoadConfig(path string) (*Config, error) {
+	configFile, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer configFile.Close()
+	return ParseConfig(configFile)
+}
+
+// ParseConfig parses the contents of a configuration file.
+func ParseConfig(r io.Reader) (*Config, error) {
+	config := &Config{
+		Defaults: map[string]string{},
+		Jobs:     map[string]map[string]string{},
+	}
+	sections, err := parseSections(r)
+	if err != nil {
+		return nil, err
+	}
+	for _, section := range sections {
+		switch {
+		case section.name == DefaultsSection:
+			config.Defaults = section.settings
+		case strings.HasPrefix(section.name, JobsSectionPrefix):
+			config.Jobs[strings.TrimPrefix(section.name, JobsSectionPrefix)] = section.settings
+		default:
+			return nil, fmt.Errorf("%w: line %v: unknown section %q", ErrInvalidConfig, section.line, section.name)
+		}
+	}
+	return config, nil
+}
+
+// section is a named table of settings in a file using the TOML subset.
+type section struct {
+	name     string
+	line     int
+	settings map[string]string
+}
+
+// parseSections parses the sections of a file which uses the TOML subset
+// described in the Config documentation. The sections are returned in the
+// order they appear. Quoted parts of section names are unquoted.
+func parseSections(r io.Reader) ([]*section, error) {
+	var sections []*section
+	seen := map[string]bool{}
+	var current *section
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(stripComment(scanner.Text()))
+		if line == "" {
+			continue
+		}
+		// A section header, like [defaults] or [jobs.<name>].
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") {
+				return nil, fmt.Errorf("%w: line %v: unterminated section header", ErrInvalidConfig, lineNum)
+			}
+			name, err := unquoteSectionName(strings.TrimSpace(line[1 : len(line)-1]))
+			if err != nil {
+				return nil, fmt.Errorf("%w: line %v: %v", ErrInvalidConfig, lineNum, err)
+			}
+			if seen[name] {
+				return nil, fmt.Errorf("%w: line %v: section %q is defined more than once", ErrInvalidConfig, lineNum, name)
+			}
+			seen[name] = true
+			current = &section{name: name, line: lineNum, settings: map[string]string{}}
+			sections = append(sections, current)
+			continue
+		}
+		// A key = value pair.
+		if current == nil {
+			return nil, fmt.Errorf("%w: line %v: setting outside of a section", ErrInvalidConfig, lineNum)
+		}
+		equals := strings.Index(line, "=")
+		if equals == -1 {
+			return nil, fmt.Errorf("%w: line %v: expected key = value", ErrInvalidConfig, lineNum)
+		}
+		key, err := unquoteKey(strings.TrimSpace(line[:equals]))
+		if err != nil || key == "" {
+			return nil, fmt.Errorf("%w: line %v: invalid key", ErrInvalidConfig, lineNum)
+		}
+		value, err := parseValue(strings.TrimSpace(line[equals+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %v: %v", ErrInvalidConfig, lineNum, err)
+		}
+		current.settings[key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return sections, nil
+}
+
+// unquoteSectionName unquotes the parts of a section name, like jobs."oclc sync".
+// Only the first dot separates parts, the remainder is one bare or quoted key.
+func unquoteSectionName(name string) (string, error) {
+	prefix, rest := name, ""
+	if dot := strings.Index(name, "."); dot != -1 {
+		prefix, rest = name[:dot], strings.TrimSpace(name[dot+1:])
+	}
+	prefix, err := unquoteKey(strings.TrimSpace(prefix))
+	if err != nil || prefix == "" {
+		return "", fmt.Errorf("invalid section name %q", name)
+	}
+	if rest == "" {
+		if strings.Contains(name, ".") {
+			return "", fmt.Errorf("invalid section name %q", name)
+		}
+		return prefix, nil
+	}
+	rest, err = unquoteKey(rest)
+	if err != nil || rest == "" {
+		return "", fmt.Errorf("invalid section name %q", name)
+	}
+	return prefix + "." + rest, nil
+}
+
+// JobNames returns the sorted names of the jobs in the configuration file.
+func (c *Config) JobNames() []string {
+	names := make([]string, 0, len(c.Jobs))
+	for name := range c.Jobs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// JobSettings returns the settings for a job, with the job's section
+// taking precedence over the defaults. If job is empty, only the defaults
+// are returned.
+func (c *Config) JobSettings(job string) (map[string]string, error) {
+	settings := map[string]string{}
+	for key, value := range c.Defaults {
+		settings[key] = value
+	}
+	if job == "" {
+		return settings, nil
+	}
+	jobSection, ok := c.Jobs[job]
+	if !ok {
+		return nil, fmt.Errorf("%w: %v", ErrUnknownJob, job)
+	}
+	for key, value := range jobSection {
+		settings[key] = value
+	}
+	return settings, nil
+}
+
+// ApplyConfig sets the flags in fs which have not already been set,
+// from the command line or the environment, using settings.
+// Settings which don't match a flag are an error.
+func ApplyConfig(fs *flag.FlagSet, settings map[string]string) error {
+	alreadySet := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		alreadySet[f.Name] = true
+	})
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if fs.Lookup(key) == nil {
+			return fmt.Errorf("%w: unknown setting %q", ErrInvalidConfig, key)
+		}
+		if alreadySet[key] {
+			continue
+		}
+		err := fs.Set(key, settings[key])
+		if err != nil {
+			return fmt.Errorf("%w: setting %q: %v", ErrInvalidConfig, key, err)
+		}
+	}
+	return nil
+}
+
+// stripComment removes a # comment which is not inside a quoted string.
+func stripComment(line string) string {
+	var quote rune
+	escaped := false
+	for i, r := range line {
+		switch {
+		case escaped:
+			escaped = false
+		case quote == '"' && r == '\\':
+			escaped = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// unquoteKey returns a bare or quoted key without its quotes.
+func unquoteKey(key string) (string, error) {
+	if strings.HasPrefix(key, `"`) || strings.HasPrefix(key, "'") {
+		return parseString(key)
+	}
+	for _, r := range key {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return "", fmt.Errorf("invalid character %q in key", r)
+		}
+	}
+	return key, nil
+}
+
+// parseValue converts a value into the string form used when setting a flag.
+func parseValue(value string) (string, error) {
+	switch {
+	case value == "":
+		return "", errors.New("missing value")
+	case strings.HasPrefix(value, "["):
+		if !strings.HasSuffix(value, "]") {
+			return "", errors.New("arrays must be on one line")
+		}
+		var elements []string
+		for _, element := range splitArray(value[1 : len(value)-1]) {
+			element = strings.TrimSpace(element)
+			if element == "" {
+				continue
+			}
+			parsed, err := parseValue(element)
+			if err != nil {
+				return "", err
+			}
+			elements = append(elements, parsed)
+		}
+		return strings.Join(elements, ","), nil
+	case strings.HasPrefix(value, `"`) || strings.HasPrefix(value, "'"):
+		return parseString(value)
+	case value == "true" || value == "false":
+		return value, nil
+	default:
+		number := strings.ReplaceAll(value, "_", "")
+		_, err := strconv.ParseInt(number, 10, 64)
+		if err == nil {
+			return number, nil
+		}
+		// Floats are used by settings like backoffmultiplier.
+		_, err = strconv.ParseFloat(number, 64)
+		if err != nil {
+			return "", fmt.Errorf("unsupported value %q", value)
+		}
+		return number, nil
+	}
+}
+
+// parseString unquotes a basic "string" or a literal 'string'.
+func parseString(value string) (string, error) {
+	if len(value) >= 2 && strings.HasPrefix(value, "'") && strings.HasSuffix(value, "'") {
+		return value[1 : len(value)-1], nil
+	}
+	unquoted, err := strconv.Unquote(value)
+	if err != nil {
+		return "", fmt.Errorf("invalid string %v", value)
+	}
+	return unquoted, nil
+}
+
+// splitArray splits the contents of an array on commas which are not inside quoted strings.
+func splitArray(contents string) []string {
+	var elements []string
+	var quote rune
+	escaped := false
+	start := 0
+	for i, r := range contents {
+		switch {
+		case escaped:
+			escaped = false
+		case quote == '"' && r == '\\':
+			escaped = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == ',':
+			elements = append(elements, contents[start:i])
+			start = i + 1
+		}
+	}
+	return append(elements, contents[start:])
+}
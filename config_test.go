@@ -0,0 +1,186 @@
+// Copyright 2024 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testConfig = `# Shared settings.
+[defaults]
+domain = "api-ca.hosted.exlibrisgroup.com"
+email = true
+mailto = ["systems@example.com", "eresources@example.com"] # Two recipients.
+retries = 5
+
+[jobs.weekly-export]
+url = "/almaws/v1/conf/jobs/M47?op=run"
+params = '/etc/alma/weekly export #1.xml'
+retries = 3
+
+[jobs."oclc sync"]
+url = "/almaws/v1/conf/jobs/M48?op=run"
+`
+
+func TestParseConfig(t *testing.T) {
+	config, err := ParseConfig(strings.NewReader(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedDefaults := map[string]string{
+		"domain":  "api-ca.hosted.exlibrisgroup.com",
+		"email":   "true",
+		"mailto":  "systems@example.com,eresources@example.com",
+		"retries": "5",
+	}
+	if !reflect.DeepEqual(expectedDefaults, config.Defaults) {
+		t.Logf("\n%#v\n%#v\n", expectedDefaults, config.Defaults)
+		t.Fatal("Expected defaults and parsed defaults are not equal.")
+	}
+
+	if !reflect.DeepEqual([]string{"oclc sync", "weekly-export"}, config.JobNames()) {
+		t.Fatalf("Unexpected job names %v.", config.JobNames())
+	}
+
+	settings, err := config.JobSettings("weekly-export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings["retries"] != "3" || settings["domain"] != "api-ca.hosted.exlibrisgroup.com" {
+		t.Fatalf("Job section didn't take precedence over defaults: %v.", settings)
+	}
+	if settings["params"] != "/etc/alma/weekly export #1.xml" {
+		t.Fatalf("Unexpected params %q.", settings["params"])
+	}
+
+	_, err = config.JobSettings("missing")
+	if !errors.Is(err, ErrUnknownJob) {
+		t.Fatalf("Expected ErrUnknownJob, got %v.", err)
+	}
+}
+
+func TestParseConfigErrors(t *testing.T) {
+	tests := map[string]string{
+		"setting outside section": `domain = "x"`,
+		"unknown section":         "[other]\n",
+		"duplicate job":           "[jobs.a]\n[jobs.a]\n",
+		"missing value":           "[defaults]\ndomain =\n",
+		"unsupported value":       "[defaults]\ndomain = example.com\n",
+		"multi-line array":        "[defaults]\nmailto = [\n  \"a@example.com\",\n]\n",
+		"unterminated string":     "[defaults]\ndomain = \"x\n",
+	}
+	for name, content := range tests {
+		_, err := ParseConfig(strings.NewReader(content))
+		if !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("%v: expected ErrInvalidConfig, got %v.", name, err)
+		}
+	}
+}
+
+func TestApplyConfig(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	domain := fs.String("domain", "", "")
+	retries := fs.Int("retries", 5, "")
+	sendEmail := fs.Bool("email", false, "")
+	err := fs.Parse([]string{"-retries", "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ApplyConfig(fs, map[string]string{
+		"domain":  "api-ca.hosted.exlibrisgroup.com",
+		"retries": "3",
+		"email":   "true",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *domain != "api-ca.hosted.exlibrisgroup.com" || !*sendEmail {
+		t.Fatal("Unset flags were not set from the configuration.")
+	}
+	if *retries != 1 {
+		t.Fatal("A flag set on the command line was overridden by the configuration.")
+	}
+
+	err = ApplyConfig(fs, map[string]string{"domian": "typo"})
+	if !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("Expected ErrInvalidConfig for an unknown setting, got %v.", err)
+	}
+}
+
+func TestConfigureFlagSetCommandSettings(t *testing.T) {
+	config, err := ParseConfig(strings.NewReader(`[defaults]
+schedule = "0 2 * * *"
+format = "json"
+webhooksecret = "secret"
+
+[jobs.weekly-export]
+url = "/almaws/v1/conf/jobs/M47?op=run"
+jobid = "M47"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The run command ignores the settings of the daemon, list-jobs, history, listen and init-params commands.
+	rc := &RunConfig{}
+	fs := flag.NewFlagSet("run", flag.ContinueOnError)
+	rc.RegisterFlags(fs)
+	err = configureFlagSet(fs, config, "weekly-export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rc.JobPath != "/almaws/v1/conf/jobs/M47?op=run" || rc.Name != "weekly-export" {
+		t.Errorf("Unexpected run configuration %+v.", rc)
+	}
+
+	// The history command uses its own settings.
+	hs := &historySettings{}
+	fs = flag.NewFlagSet("history", flag.ContinueOnError)
+	(&RunConfig{}).RegisterFlags(fs)
+	hs.RegisterFlags(fs)
+	err = configureFlagSet(fs, config, "weekly-export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hs.Format != "json" || hs.JobID != "M47" {
+		t.Errorf("Unexpected history settings %+v.", hs)
+	}
+
+	config.Defaults["formatt"] = "json"
+	fs = flag.NewFlagSet("run", flag.ContinueOnError)
+	(&RunConfig{}).RegisterFlags(fs)
+	err = configureFlagSet(fs, config, "weekly-export")
+	if !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("Expected ErrInvalidConfig for an unknown setting, got %v.", err)
+	}
+}
+
+func TestConfigureFlagSetFloat(t *testing.T) {
+	config, err := ParseConfig(strings.NewReader("[defaults]\nbackoffmultiplier = 1.5\n\n[jobs.weekly-export]\nbackoffmultiplier = 2.5e0\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Defaults["backoffmultiplier"] != "1.5" {
+		t.Fatalf("Unexpected default %q.", config.Defaults["backoffmultiplier"])
+	}
+	for name, expected := range map[string]float64{"": 1.5, "weekly-export": 2.5} {
+		rc := &RunConfig{}
+		fs := flag.NewFlagSet("run", flag.ContinueOnError)
+		rc.RegisterFlags(fs)
+		err = configureFlagSet(fs, config, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rc.Retry.Multiplier != expected {
+			t.Errorf("%q: expected a backoff multiplier of %v, got %v.", name, expected, rc.Retry.Multiplier)
+		}
+	}
+}
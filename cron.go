@@ -0,0 +1,254 @@
+// Copyright 2024 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidCronSchedule is returned when a cron expression can't be parsed.
+var ErrInvalidCronSchedule = errors.New("invalid cron schedule")
+
+// cronSearchYears is how far into the future Next looks for a matching time.
+const cronSearchYears = 5
+
+// CronSchedule is a parsed five field cron expression
+// (minute, hour, day of month, month, day of week) in a time zone.
+//
+// Schedules are evaluated in wall clock time in the schedule's location.
+// A time which is skipped when the clocks go forward for daylight saving time
+// runs at the first instant after the transition. A time which is repeated
+// when the clocks go back runs once, at its first occurrence.
+type CronSchedule struct {
+	minute     uint64
+	hour       uint64
+	dayOfMonth uint64
+	month      uint64
+	dayOfWeek  uint64
+	// When either day field is unrestricted, both must match.
+	// Otherwise, a time matches if either day field matches.
+	anyDayOfMonth bool
+	anyDayOfWeek  bool
+	location      *time.Location
+	// spec is the expression the schedule was parsed from.
+	spec string
+}
+
+// cronField describes the allowed values for one field of a cron expression.
+type cronField struct {
+	name  string
+	min   int
+	max   int
+	names []string
+}
+
+// cronFields returns the fields of a cron expression, in order.
+func cronFields() []cronField {
+	return []cronField{
+		{name: "minute", min: 0, max: 59},
+		{name: "hour", min: 0, max: 23},
+		{name: "day of month", min: 1, max: 31},
+		{name: "month", min: 1, max: 12, names: []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}},
+		// 7 is accepted as Sunday, and folded into 0 after parsing.
+		{name: "day of week", min: 0, max: 7, names: []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}},
+	}
+}
+
+// ParseCronSchedule parses a five field cron expression, or one of the
+// descriptors @yearly, @annually, @monthly, @weekly, @daily, @midnight or @hourly.
+// Times are evaluated in loc.
+func ParseCronSchedule(spec string, loc *time.Location) (*CronSchedule, error) {
+	original := strings.TrimSpace(spec)
+	switch original {
+	case "@yearly", "@annually":
+		spec = "0 0 1 1 *"
+	case "@monthly":
+		spec = "0 0 1 * *"
+	case "@weekly":
+		spec = "0 0 * * 0"
+	case "@daily", "@midnight":
+		spec = "0 0 * * *"
+	case "@hourly":
+		spec = "0 * * * *"
+	}
+	parts := strings.Fields(spec)
+	if len(parts) != 5 {
+		return nil, fmt.Errorf("%w: %q: expected 5 fields, found %v", ErrInvalidCronSchedule, spec, len(parts))
+	}
+	var bits [5]uint64
+	for i, field := range cronFields() {
+		parsed, err := parseCronField(parts[i], field)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q: %v", ErrInvalidCronSchedule, spec, err)
+		}
+		bits[i] = parsed
+	}
+	// Sunday can be 0 or 7.
+	if bits[4]&(1<<7) != 0 {
+		bits[4] = bits[4]&^(1<<7) | 1
+	}
+	if loc == nil {
+		loc = time.Local
+	}
+	return &CronSchedule{
+		minute:        bits[0],
+		hour:          bits[1],
+		dayOfMonth:    bits[2],
+		month:         bits[3],
+		dayOfWeek:     bits[4],
+		anyDayOfMonth: parts[2] == "*" || parts[2] == "?",
+		anyDayOfWeek:  parts[4] == "*" || parts[4] == "?",
+		location:      loc,
+		spec:          original,
+	}, nil
+}
+
+// parseCronField parses a comma separated list of values, ranges and steps into a bitset.
+func parseCronField(value string, field cronField) (uint64, error) {
+	var bits uint64
+	for _, item := range strings.Split(value, ",") {
+		step := 1
+		if slash := strings.Index(item, "/"); slash != -1 {
+			var err error
+			step, err = strconv.Atoi(item[slash+1:])
+			if err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step in %v field: %q", field.name, item)
+			}
+			item = item[:slash]
+		}
+		low, high := field.min, field.max
+		switch {
+		case item == "*" || item == "?":
+		case strings.Contains(item, "-"):
+			bounds := strings.SplitN(item, "-", 2)
+			var err error
+			low, err = parseCronValue(bounds[0], field)
+			if err != nil {
+				return 0, err
+			}
+			high, err = parseCronValue(bounds[1], field)
+			if err != nil {
+				return 0, err
+			}
+			if low > high {
+				return 0, fmt.Errorf("invalid range in %v field: %q", field.name, item)
+			}
+		default:
+			var err error
+			low, err = parseCronValue(item, field)
+			if err != nil {
+				return 0, err
+			}
+			// A single value with a step, like 5/15, runs from the value to the maximum.
+			if step == 1 {
+				high = low
+			}
+		}
+		for v := low; v <= high; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// parseCronValue parses a number or a name in a cron field.
+func parseCronValue(value string, field cronField) (int, error) {
+	for i, name := range field.names {
+		if strings.EqualFold(value, name) {
+			return i + field.min, nil
+		}
+	}
+	v, err := strconv.Atoi(value)
+	if err != nil || v < field.min || v > field.max {
+		return 0, fmt.Errorf("invalid value in %v field: %q", field.name, value)
+	}
+	return v, nil
+}
+
+// String returns the expression the schedule was parsed from.
+func (s *CronSchedule) String() string {
+	return s.spec
+}
+
+// Location returns the time zone the schedule is evaluated in.
+func (s *CronSchedule) Location() *time.Location {
+	return s.location
+}
+
+// Next returns the first time after t which matches the schedule, or the
+// zero time if no time matches in the next few years.
+func (s *CronSchedule) Next(t time.Time) time.Time {
+	// Search using wall clock times, represented in UTC so that
+	// arithmetic isn't affected by daylight saving time.
+	civil := wallClock(t.In(s.location)).Truncate(time.Minute).Add(time.Minute)
+	limit := civil.Year() + cronSearchYears
+	for civil.Year() <= limit {
+		switch {
+		case s.month&(1<<uint(civil.Month())) == 0:
+			civil = time.Date(civil.Year(), civil.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		case !s.matchesDay(civil):
+			civil = time.Date(civil.Year(), civil.Month(), civil.Day()+1, 0, 0, 0, 0, time.UTC)
+		case s.hour&(1<<uint(civil.Hour())) == 0:
+			civil = civil.Truncate(time.Hour).Add(time.Hour)
+		case s.minute&(1<<uint(civil.Minute())) == 0:
+			civil = civil.Add(time.Minute)
+		default:
+			next := s.instant(civil)
+			if next.After(t) {
+				return next
+			}
+			civil = civil.Add(time.Minute)
+		}
+	}
+	return time.Time{}
+}
+
+// matchesDay reports whether the day of month and day of week fields match the wall clock time.
+func (s *CronSchedule) matchesDay(civil time.Time) bool {
+	domMatch := s.dayOfMonth&(1<<uint(civil.Day())) != 0
+	dowMatch := s.dayOfWeek&(1<<uint(civil.Weekday())) != 0
+	if s.anyDayOfMonth || s.anyDayOfWeek {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+// instant converts a wall clock time to the first instant in the schedule's
+// location which shows that wall clock time. If the wall clock time was skipped
+// by a daylight saving time transition, the instant of the transition is returned.
+func (s *CronSchedule) instant(civil time.Time) time.Time {
+	guess := time.Date(civil.Year(), civil.Month(), civil.Day(), civil.Hour(), civil.Minute(), 0, 0, s.location)
+	var first time.Time
+	for _, probe := range []time.Duration{-3 * time.Hour, 0, 3 * time.Hour} {
+		_, offset := guess.Add(probe).Zone()
+		candidate := civil.Add(-time.Duration(offset) * time.Second).In(s.location)
+		if wallClock(candidate).Equal(civil) && (first.IsZero() || candidate.Before(first)) {
+			first = candidate
+		}
+	}
+	if !first.IsZero() {
+		return first
+	}
+	// The wall clock time doesn't exist. Using the offset from before the
+	// transition gives an instant after it, so walk back to the transition.
+	_, offset := guess.Add(-3 * time.Hour).Zone()
+	next := civil.Add(-time.Duration(offset) * time.Second).In(s.location)
+	for {
+		previous := next.Add(-time.Minute)
+		if !wallClock(previous).After(civil) {
+			return next
+		}
+		next = previous
+	}
+}
+
+// wallClock returns the wall clock time shown by t, in UTC.
+func wallClock(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+}
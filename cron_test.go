@@ -0,0 +1,105 @@
+// Copyright 2024 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseCronScheduleErrors(t *testing.T) {
+	specs := []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"* * * foo *",
+	}
+	for _, spec := range specs {
+		_, err := ParseCronSchedule(spec, time.UTC)
+		if !errors.Is(err, ErrInvalidCronSchedule) {
+			t.Errorf("%q: expected ErrInvalidCronSchedule, got %v.", spec, err)
+		}
+	}
+}
+
+func TestCronScheduleNext(t *testing.T) {
+	tests := []struct {
+		spec     string
+		from     string
+		expected string
+	}{
+		{"0 3 * * *", "2024-01-01T00:00:00Z", "2024-01-01T03:00:00Z"},
+		{"0 3 * * *", "2024-01-01T03:00:00Z", "2024-01-02T03:00:00Z"},
+		{"*/15 * * * *", "2024-01-01T00:07:30Z", "2024-01-01T00:15:00Z"},
+		{"30 6 * * mon-fri", "2024-01-05T07:00:00Z", "2024-01-08T06:30:00Z"},
+		{"0 0 1 jan *", "2024-06-01T00:00:00Z", "2025-01-01T00:00:00Z"},
+		{"0 0 29 2 *", "2024-03-01T00:00:00Z", "2028-02-29T00:00:00Z"},
+		{"@weekly", "2024-01-01T00:00:00Z", "2024-01-07T00:00:00Z"},
+		{"0 0 * * 7", "2024-01-01T00:00:00Z", "2024-01-07T00:00:00Z"},
+		// Restricted day of month and day of week match either.
+		{"0 0 15 * fri", "2024-01-01T00:00:00Z", "2024-01-05T00:00:00Z"},
+	}
+	for _, test := range tests {
+		schedule, err := ParseCronSchedule(test.spec, time.UTC)
+		if err != nil {
+			t.Fatal(err)
+		}
+		from, _ := time.Parse(time.RFC3339, test.from)
+		expected, _ := time.Parse(time.RFC3339, test.expected)
+		next := schedule.Next(from)
+		if !next.Equal(expected) {
+			t.Errorf("%q from %v: expected %v, got %v.", test.spec, test.from, expected, next)
+		}
+	}
+}
+
+func TestCronScheduleNextDaylightSavingTime(t *testing.T) {
+	loc, err := time.LoadLocation("America/Toronto")
+	if err != nil {
+		t.Skip("America/Toronto time zone data isn't available:", err)
+	}
+	tests := []struct {
+		spec     string
+		from     string
+		expected []string
+	}{
+		// 2:30 doesn't exist on 2024-03-10, the job runs when the clocks go forward.
+		{"30 2 * * *", "2024-03-09T12:00:00-05:00", []string{
+			"2024-03-10T03:00:00-04:00",
+			"2024-03-11T02:30:00-04:00",
+		}},
+		// 1:30 happens twice on 2024-11-03, the job runs once.
+		{"30 1 * * *", "2024-11-02T12:00:00-04:00", []string{
+			"2024-11-03T01:30:00-04:00",
+			"2024-11-04T01:30:00-05:00",
+		}},
+		// Daily jobs keep their wall clock time across the transition.
+		{"0 9 * * *", "2024-03-09T12:00:00-05:00", []string{
+			"2024-03-10T09:00:00-04:00",
+		}},
+	}
+	for _, test := range tests {
+		schedule, err := ParseCronSchedule(test.spec, loc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		from, _ := time.Parse(time.RFC3339, test.from)
+		for _, e := range test.expected {
+			expected, _ := time.Parse(time.RFC3339, e)
+			next := schedule.Next(from)
+			if !next.Equal(expected) {
+				t.Errorf("%q from %v: expected %v, got %v.", test.spec, from, expected, next)
+			}
+			from = next
+		}
+	}
+}
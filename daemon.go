@@ -0,0 +1,150 @@
+// Copyright 2024 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Settings in the configuration file which control when the daemon command runs a job.
+const (
+	ScheduleSetting = "schedule"
+	TimezoneSetting = "timezone"
+	JitterSetting   = "jitter"
+	OverlapSetting  = "overlap"
+)
+
+// isScheduleSetting reports whether key is one of the settings which control when the daemon command runs a job.
+func isScheduleSetting(key string) bool {
+	switch key {
+	case ScheduleSetting, TimezoneSetting, JitterSetting, OverlapSetting:
+		return true
+	default:
+		return false
+	}
+}
+
+// daemonCommand runs the jobs in the configuration file on their schedules.
+func daemonCommand(args []string) {
+	rc := &RunConfig{}
+	fs := newFlagSet("daemon", "Run the jobs in a configuration file on their cron schedules.")
+	rc.RegisterFlags(fs)
+	configPath := fs.String("config", "", "A configuration file storing default and per-job settings, including schedules. Required.")
+	jobNames := fs.String("jobs", "", "The jobs in the configuration file to schedule, comma delimited. All jobs with a schedule are used by default.")
+	parseFlags(fs, args)
+
+	if *configPath == "" {
+		exitConfigError("FATAL: A configuration file is required for the daemon command.")
+	}
+	config, err := LoadConfig(*configPath)
+	if err != nil {
+		exitConfigError("FATAL: Error loading configuration file:", err)
+	}
+
+	names := config.JobNames()
+	if *jobNames != "" {
+		names = TrimSpaceAll(strings.Split(*jobNames, ","))
+	}
+	var jobs []*ScheduledJob
+	for _, name := range names {
+		job, err := newScheduledJob(fs, config, name)
+		if err != nil {
+			exitConfigError(fmt.Sprintf("FATAL: Job %v: %v", name, err))
+		}
+		if job == nil {
+			log.Printf("Job %v has no schedule, it will not be run by the daemon.\n", name)
+			continue
+		}
+		log.Printf("Scheduled job %v (%v, %v, overlap %v, jitter %v).\n", name, job.Schedule, job.Schedule.Location(), job.Overlap, job.Jitter)
+		jobs = append(jobs, job)
+	}
+	if len(jobs) == 0 {
+		exitConfigError("FATAL: No jobs in the configuration file have a schedule.")
+	}
+
+	// Stop scheduling and cancel runs in progress when the process is interrupted.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	log.Printf("alma-api-job-runner version %v scheduling %v jobs.\n", version, len(jobs))
+	RunScheduler(ctx, log.Default(), jobs)
+}
+
+// newScheduledJob builds the scheduled job for a job in the configuration file.
+// Settings which were set on the daemon's command line or from the environment
+// take precedence over the job's section and the defaults.
+// If the job doesn't have a schedule, nil is returned.
+func newScheduledJob(daemonFlags *flag.FlagSet, config *Config, name string) (*ScheduledJob, error) {
+	settings, err := config.JobSettings(name)
+	if err != nil {
+		return nil, err
+	}
+	if settings[ScheduleSetting] == "" {
+		return nil, nil
+	}
+
+	// Build the job's run configuration.
+	rc, err := jobRunConfig(daemonFlags, config, name)
+	if err != nil {
+		return nil, err
+	}
+	err = rc.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse the schedule settings.
+	loc := time.Local
+	if settings[TimezoneSetting] != "" {
+		loc, err = time.LoadLocation(settings[TimezoneSetting])
+		if err != nil {
+			return nil, fmt.Errorf("%w: timezone: %v", ErrInvalidConfig, err)
+		}
+	}
+	schedule, err := ParseCronSchedule(settings[ScheduleSetting], loc)
+	if err != nil {
+		return nil, err
+	}
+	var jitter time.Duration
+	if settings[JitterSetting] != "" {
+		jitter, err = time.ParseDuration(settings[JitterSetting])
+		if err != nil {
+			return nil, fmt.Errorf("%w: jitter: %v", ErrInvalidConfig, err)
+		}
+	}
+	overlap := OverlapSkip
+	if settings[OverlapSetting] != "" {
+		overlap, err = ParseOverlapPolicy(settings[OverlapSetting])
+		if err != nil {
+			return nil, err
+		}
+	}
+	// Runs which are allowed to overlap don't take the lock, which would skip all but the first.
+	if overlap == OverlapAllow {
+		rc.LockDir = ""
+	}
+
+	return &ScheduledJob{
+		Name:     name,
+		Schedule: schedule,
+		Jitter:   jitter,
+		Overlap:  overlap,
+		Run: func(ctx context.Context) {
+			_, err := rc.Run(ctx, os.Stderr, "["+name+"] ")
+			if err != nil && !errors.Is(err, ErrJobSkipped) {
+				log.Printf("[%v] Run failed: %v\n", name, err)
+			}
+		},
+	}, nil
+}
@@ -0,0 +1,191 @@
+// Copyright 2024 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testDaemonConfig = `[defaults]
+domain = "api-ca.hosted.exlibrisgroup.com"
+key = "abc123"
+params = "params.xml"
+schedule = "*/15 * * * *"
+timezone = "America/Toronto"
+
+[jobs.export]
+url = "/almaws/v1/conf/jobs/M47?op=run"
+overlap = "%v"
+
+[jobs.manual]
+url = "/almaws/v1/conf/jobs/M48?op=run"
+schedule = ""
+`
+
+// fakeClock is a schedulerClock whose sleeps return immediately after advancing the time.
+// Once it has woken up wakeups times, the next sleep cancels the scheduler.
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	wakeups int
+	woken   []time.Time
+	cancel  context.CancelFunc
+}
+
+// clock returns the schedulerClock backed by c.
+func (c *fakeClock) clock() schedulerClock {
+	return schedulerClock{
+		now: func() time.Time {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			return c.now
+		},
+		sleep: func(ctx context.Context, d time.Duration) error {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			if len(c.woken) == c.wakeups {
+				c.cancel()
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			c.now = c.now.Add(d)
+			c.woken = append(c.woken, c.now)
+			return nil
+		},
+	}
+}
+
+func TestDaemonSchedule(t *testing.T) {
+	for policy, expectedRuns := range map[OverlapPolicy]int{OverlapSkip: 1, OverlapQueue: 1, OverlapAllow: 3} {
+		config, err := ParseConfig(strings.NewReader(fmt.Sprintf(testDaemonConfig, policy)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		daemonFlags := flag.NewFlagSet("daemon", flag.ContinueOnError)
+		(&RunConfig{}).RegisterFlags(daemonFlags)
+
+		job, err := newScheduledJob(daemonFlags, config, "manual")
+		if err != nil || job != nil {
+			t.Fatalf("Expected a job without a schedule to be left out, got %v, %v.", job, err)
+		}
+		job, err = newScheduledJob(daemonFlags, config, "export")
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The schedule comes from the defaults.
+		if job.Schedule.String() != "*/15 * * * *" || job.Schedule.Location().String() != "America/Toronto" || job.Overlap != policy {
+			t.Fatalf("Unexpected job %v, %v, %v.", job.Schedule, job.Schedule.Location(), job.Overlap)
+		}
+
+		// Each run lasts until the scheduler stops, so later runs overlap it.
+		mu := new(sync.Mutex)
+		runs := 0
+		job.Run = func(ctx context.Context) {
+			mu.Lock()
+			runs++
+			mu.Unlock()
+			<-ctx.Done()
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		start := time.Date(2024, time.March, 1, 9, 5, 0, 0, job.Schedule.Location())
+		clock := &fakeClock{now: start, wakeups: 3, cancel: cancel}
+		runScheduler(ctx, log.New(io.Discard, "", 0), []*ScheduledJob{job}, clock.clock())
+
+		expected := []time.Time{start.Add(10 * time.Minute), start.Add(25 * time.Minute), start.Add(40 * time.Minute)}
+		if fmt.Sprint(clock.woken) != fmt.Sprint(expected) {
+			t.Errorf("%v: expected the scheduler to wake at %v, got %v.", policy, expected, clock.woken)
+		}
+		if runs != expectedRuns {
+			t.Errorf("%v: expected %v runs, got %v.", policy, expectedRuns, runs)
+		}
+	}
+}
+
+func TestDaemonOverlapAllowSkipsLock(t *testing.T) {
+	// Each submission waits until both runs have submitted, which can only happen if neither is skipped by the lock.
+	both := make(chan struct{})
+	mu := new(sync.Mutex)
+	submissions := 0
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/almaws/v1/conf/jobs/M47":
+			mu.Lock()
+			submissions++
+			if submissions == 2 {
+				close(both)
+			}
+			mu.Unlock()
+			select {
+			case <-both:
+			case <-time.After(5 * time.Second):
+			}
+			fmt.Fprintf(w, `<job><additional_info link="https://%v/almaws/v1/conf/jobs/M47/instances/12345">Job submitted.</additional_info></job>`, r.Host)
+		case r.URL.Path == "/almaws/v1/conf/jobs/M47/instances/12345":
+			fmt.Fprint(w, `<job_instance><id>12345</id><end_time>2015-04-15T13:07:44.359Z</end_time><status desc="Completed Successfully">COMPLETED_SUCCESS</status></job_instance>`)
+		default:
+			t.Errorf("Unexpected request %v %v.", r.Method, r.URL)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	// The runs use the default HTTP client, which needs to trust the test server.
+	defer func(client *http.Client) { http.DefaultClient = client }(http.DefaultClient)
+	http.DefaultClient = server.Client()
+
+	dir := t.TempDir()
+	params := filepath.Join(dir, "params.xml")
+	err := os.WriteFile(params, []byte(`<job><parameters><parameter><name>set_id</name><value>1</value></parameter></parameters></job>`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := ParseConfig(strings.NewReader(fmt.Sprintf(`[jobs.export]
+domain = %q
+key = "abc123"
+url = "/almaws/v1/conf/jobs/M47?op=run"
+params = %q
+outputfile = %q
+lockdir = %q
+statedir = ""
+schedule = "*/15 * * * *"
+overlap = "allow"
+`, strings.TrimPrefix(server.URL, "https://"), params, filepath.Join(dir, "instance.xml"), dir)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	daemonFlags := flag.NewFlagSet("daemon", flag.ContinueOnError)
+	(&RunConfig{}).RegisterFlags(daemonFlags)
+	job, err := newScheduledJob(daemonFlags, config, "export")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	runs := new(sync.WaitGroup)
+	for i := 0; i < 2; i++ {
+		runs.Add(1)
+		go func() {
+			defer runs.Done()
+			job.Run(ctx)
+		}()
+	}
+	runs.Wait()
+	if submissions != 2 {
+		t.Errorf("Expected both overlapping runs to submit the job, got %v submissions.", submissions)
+	}
+}
@@ -0,0 +1,210 @@
+// Copyright 2024 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"os/signal"
+	"path"
+	"strings"
+	"syscall"
+
+	"github.com/cu-library/alma-api-job-runner/alma"
+)
+
+var (
+	// ErrInvalidDriftPolicy is returned when a parameter drift policy isn't recognized.
+	ErrInvalidDriftPolicy = errors.New("invalid parameter drift policy")
+
+	// ErrParameterDrift is returned by Run when the parameters don't match
+	// the job's definition in Alma and the drift policy is strict.
+	ErrParameterDrift = errors.New("the parameters don't match the job's definition in Alma")
+)
+
+// DriftPolicy controls whether the parameters are compared to the job's
+// definition in Alma before the job is submitted, and what happens if they differ.
+type DriftPolicy string
+
+const (
+	// DriftOff doesn't check the parameters.
+	DriftOff DriftPolicy = "off"
+
+	// DriftWarn logs the differences, and submits the job.
+	DriftWarn DriftPolicy = "warn"
+
+	// DriftStrict doesn't submit the job if there are differences, and reports the run as failed.
+	DriftStrict DriftPolicy = "strict"
+)
+
+// ParseDriftPolicy returns the DriftPolicy named by s.
+func ParseDriftPolicy(s string) (DriftPolicy, error) {
+	switch policy := DriftPolicy(s); policy {
+	case DriftOff, DriftWarn, DriftStrict:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("%w: %q, expected off, warn or strict", ErrInvalidDriftPolicy, s)
+	}
+}
+
+// JobID returns the ID of the job in the job URL, like M47 in /almaws/v1/conf/jobs/M47?op=run.
+func JobID(jobPath string) (string, error) {
+	jobURL, err := url.Parse(jobPath)
+	if err != nil {
+		return "", err
+	}
+	id := path.Base(jobURL.Path)
+	if id == "." || id == "/" {
+		return "", fmt.Errorf("%w: the job URL %q doesn't include a job ID", ErrInvalidSettings, jobPath)
+	}
+	return id, nil
+}
+
+// parameterDrift compares the parameters to the job's definition in Alma.
+func (rc *RunConfig) parameterDrift(ctx context.Context, client *alma.Client, params alma.AlmaJob) (alma.ParameterDrift, error) {
+	id, err := JobID(rc.JobPath)
+	if err != nil {
+		return alma.ParameterDrift{}, err
+	}
+	job, err := client.GetJob(ctx, id)
+	if err != nil {
+		return alma.ParameterDrift{}, fmt.Errorf("getting the job's definition: %w", err)
+	}
+	return alma.CompareParameters(params.Parameters, job.Parameters), nil
+}
+
+// checkDrift compares the parameters to the job's definition in Alma and applies
+// the drift policy. With the warn policy, the differences are logged, and the
+// returned note is set if there are any.
+func (rc *RunConfig) checkDrift(ctx context.Context, client *alma.Client, logger *log.Logger, params alma.AlmaJob) (note string, err error) {
+	if rc.ParamDrift == DriftOff || rc.ParamDrift == "" {
+		return "", nil
+	}
+	drift, err := rc.parameterDrift(ctx, client, params)
+	if err != nil {
+		if rc.ParamDrift == DriftStrict {
+			return "", err
+		}
+		logger.Println("Unable to check the parameters for drift: ", err)
+		return "", nil
+	}
+	if !drift.Drifted() {
+		logger.Println("The parameters match the job's definition in Alma.")
+		return "", nil
+	}
+	messages := drift.Messages()
+	for _, message := range messages {
+		logger.Println("Warning:", message)
+	}
+	if rc.ParamDrift == DriftStrict {
+		return "", fmt.Errorf("%w: %v", ErrParameterDrift, strings.Join(messages, "; "))
+	}
+	return "parameter drift", nil
+}
+
+// joinNotes joins the notes which aren't empty.
+func joinNotes(notes ...string) string {
+	var nonEmpty []string
+	for _, note := range notes {
+		if note != "" {
+			nonEmpty = append(nonEmpty, note)
+		}
+	}
+	return strings.Join(nonEmpty, ", ")
+}
+
+// driftCommand compares the parameters files of the jobs in the configuration
+// file to the jobs' definitions in Alma.
+func driftCommand(args []string) {
+	rc := &RunConfig{}
+	fs := newFlagSet("drift", "Check the parameters files of the jobs in a configuration file against the jobs' definitions in Alma.")
+	rc.RegisterFlags(fs)
+	configPath := fs.String("config", "", "A configuration file storing default and per-job settings. Required.")
+	jobNames := fs.String("jobs", "", "The jobs in the configuration file to check, comma delimited. All jobs are checked by default.")
+	parseFlags(fs, args)
+
+	if *configPath == "" {
+		exitConfigError("FATAL: A configuration file is required for the drift command.")
+	}
+	config, err := LoadConfig(*configPath)
+	if err != nil {
+		exitConfigError("FATAL: Error loading configuration file:", err)
+	}
+	names := config.JobNames()
+	if *jobNames != "" {
+		names = TrimSpaceAll(strings.Split(*jobNames, ","))
+	}
+	if len(names) == 0 {
+		exitConfigError("FATAL: The configuration file has no jobs.")
+	}
+
+	// Cancel any in-progress requests when the process is interrupted.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	code := ExitSuccess
+	drifted := 0
+	for _, name := range names {
+		logger := log.New(os.Stderr, "["+name+"] ", log.LstdFlags|log.Lmsgprefix)
+		drift, strict, err := checkJobDrift(ctx, fs, config, name, logger)
+		if err != nil {
+			logger.Println("Error: ", err)
+			code = MaxExitCode(code, driftErrorExitCode(err))
+			continue
+		}
+		if !drift.Drifted() {
+			logger.Println("The parameters match the job's definition in Alma.")
+			continue
+		}
+		drifted++
+		for _, message := range drift.Messages() {
+			logger.Println("Warning:", message)
+		}
+		if strict {
+			code = MaxExitCode(code, ExitParamDrift)
+		}
+	}
+	log.Printf("%v of %v jobs have parameter drift.\n", drifted, len(names))
+	if code != ExitSuccess {
+		stop()
+		os.Exit(code)
+	}
+}
+
+// driftErrorExitCode returns the exit code for a job which couldn't be checked for drift.
+func driftErrorExitCode(err error) int {
+	if errors.Is(err, ErrInvalidSettings) || errors.Is(err, ErrInvalidConfig) {
+		return ExitConfigError
+	}
+	return ExitFailure
+}
+
+// checkJobDrift compares the parameters file of a job in the configuration file
+// to the job's definition in Alma. It also reports whether the job's drift policy is strict.
+func checkJobDrift(ctx context.Context, parent *flag.FlagSet, config *Config, name string, logger *log.Logger) (drift alma.ParameterDrift, strict bool, err error) {
+	rc, err := jobRunConfig(parent, config, name)
+	if err != nil {
+		return drift, false, err
+	}
+	err = rc.Validate()
+	if err != nil {
+		return drift, false, err
+	}
+	params, err := LoadParameters(rc.Params)
+	if err != nil {
+		return drift, false, fmt.Errorf("loading parameters: %w", err)
+	}
+	client, err := rc.newClient(logger)
+	if err != nil {
+		return drift, false, err
+	}
+	drift, err = rc.parameterDrift(ctx, client, params)
+	return drift, rc.ParamDrift == DriftStrict, err
+}
@@ -0,0 +1,68 @@
+// Copyright 2024 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cu-library/alma-api-job-runner/alma"
+)
+
+func TestJobID(t *testing.T) {
+	id, err := JobID("/almaws/v1/conf/jobs/M47?op=run")
+	if err != nil || id != "M47" {
+		t.Errorf("Expected M47, got %q, %v.", id, err)
+	}
+	_, err = JobID("?op=run")
+	if !errors.Is(err, ErrInvalidSettings) {
+		t.Errorf("Expected ErrInvalidSettings, got %v.", err)
+	}
+}
+
+func TestCheckDrift(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/almaws/v1/conf/jobs/M47" {
+			t.Errorf("Unexpected request %v.", r.URL)
+		}
+		fmt.Fprint(w, `<job><id>M47</id><parameters><parameter><name desc="Set">set_id</name><value></value></parameter></parameters></job>`)
+	}))
+	defer server.Close()
+
+	client, err := alma.NewClient(
+		alma.WithDomain(strings.TrimPrefix(server.URL, "https://")),
+		alma.WithKey("testkey"),
+		alma.WithHTTPClient(server.Client()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := log.New(io.Discard, "", 0)
+	params := alma.AlmaJob{Parameters: []alma.Parameter{{Name: alma.DescAndValue{Value: "old_set"}}}}
+
+	tests := []struct {
+		policy DriftPolicy
+		note   string
+		err    error
+	}{
+		{DriftOff, "", nil},
+		{DriftWarn, "parameter drift", nil},
+		{DriftStrict, "", ErrParameterDrift},
+	}
+	for _, test := range tests {
+		rc := &RunConfig{JobPath: "/almaws/v1/conf/jobs/M47?op=run", ParamDrift: test.policy}
+		note, err := rc.checkDrift(context.Background(), client, logger, params)
+		if note != test.note || !errors.Is(err, test.err) {
+			t.Errorf("%v: expected %q, %v, got %q, %v.", test.policy, test.note, test.err, note, err)
+		}
+	}
+}
@@ -0,0 +1,272 @@
+// Copyright 2024 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// base64LineLength is the length of the lines of base64 encoded attachments.
+const base64LineLength = 76
+
+// EmailReport is the content of an email report.
+type EmailReport struct {
+	Subject string
+	// Text is the plain text version of the report.
+	Text string
+	// HTML, if set, is sent as an alternative to Text.
+	HTML        string
+	Attachments []Attachment
+}
+
+// Attachment is a file attached to an email report.
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+// BuildMessage returns the email report as an RFC 5322 message. The body is the
+// text, or a multipart/alternative of the text and HTML if the report has HTML.
+// If the report has attachments, the body and attachments are sent as multipart/mixed.
+func BuildMessage(report *EmailReport, from string, to []string, date time.Time) ([]byte, error) {
+	messageID, err := newMessageID(from, date)
+	if err != nil {
+		return nil, err
+	}
+	header, body, err := bodyPart(report)
+	if err != nil {
+		return nil, err
+	}
+	if len(report.Attachments) > 0 {
+		content := new(bytes.Buffer)
+		mixed := multipart.NewWriter(content)
+		part, err := mixed.CreatePart(header)
+		if err != nil {
+			return nil, err
+		}
+		_, _ = part.Write(body)
+		for _, attachment := range report.Attachments {
+			err = writeAttachment(mixed, attachment)
+			if err != nil {
+				return nil, err
+			}
+		}
+		err = mixed.Close()
+		if err != nil {
+			return nil, err
+		}
+		header = textproto.MIMEHeader{}
+		header.Set("Content-Type", mime.FormatMediaType("multipart/mixed", map[string]string{"boundary": mixed.Boundary()}))
+		body = content.Bytes()
+	}
+
+	message := new(bytes.Buffer)
+	writeHeader := func(key, value string) {
+		if value != "" {
+			fmt.Fprintf(message, "%v: %v\r\n", key, value)
+		}
+	}
+	writeHeader("From", from)
+	writeHeader("To", strings.Join(to, ", "))
+	writeHeader("Subject", mime.QEncoding.Encode("utf-8", report.Subject))
+	writeHeader("Date", date.Format(time.RFC1123Z))
+	writeHeader("Message-ID", messageID)
+	writeHeader("MIME-Version", "1.0")
+	writeHeader("Content-Type", header.Get("Content-Type"))
+	writeHeader("Content-Transfer-Encoding", header.Get("Content-Transfer-Encoding"))
+	message.WriteString("\r\n")
+	message.Write(body)
+	return message.Bytes(), nil
+}
+
+// bodyPart returns the headers and content of the report's body: the text,
+// or a multipart/alternative of the text and HTML.
+func bodyPart(report *EmailReport) (textproto.MIMEHeader, []byte, error) {
+	if report.HTML == "" {
+		return textPart("text/plain", report.Text)
+	}
+	content := new(bytes.Buffer)
+	alternative := multipart.NewWriter(content)
+	for _, alternativePart := range []struct{ mediaType, content string }{
+		{"text/plain", report.Text},
+		{"text/html", report.HTML},
+	} {
+		header, body, err := textPart(alternativePart.mediaType, alternativePart.content)
+		if err != nil {
+			return nil, nil, err
+		}
+		part, err := alternative.CreatePart(header)
+		if err != nil {
+			return nil, nil, err
+		}
+		_, _ = part.Write(body)
+	}
+	err := alternative.Close()
+	if err != nil {
+		return nil, nil, err
+	}
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Type", mime.FormatMediaType("multipart/alternative", map[string]string{"boundary": alternative.Boundary()}))
+	return header, content.Bytes(), nil
+}
+
+// textPart returns the headers and quoted-printable content of a text part.
+func textPart(mediaType, content string) (textproto.MIMEHeader, []byte, error) {
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Type", mime.FormatMediaType(mediaType, map[string]string{"charset": "utf-8"}))
+	header.Set("Content-Transfer-Encoding", "quoted-printable")
+	body := new(bytes.Buffer)
+	qp := quotedprintable.NewWriter(body)
+	_, err := io.WriteString(qp, content)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = qp.Close()
+	return header, body.Bytes(), err
+}
+
+// writeAttachment writes the attachment as a base64 encoded part.
+func writeAttachment(mixed *multipart.Writer, attachment Attachment) error {
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Type", attachment.ContentType)
+	header.Set("Content-Transfer-Encoding", "base64")
+	header.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Filename}))
+	part, err := mixed.CreatePart(header)
+	if err != nil {
+		return err
+	}
+	encoded := base64.StdEncoding.EncodeToString(attachment.Data)
+	for len(encoded) > base64LineLength {
+		_, _ = io.WriteString(part, encoded[:base64LineLength]+"\r\n")
+		encoded = encoded[base64LineLength:]
+	}
+	_, err = io.WriteString(part, encoded+"\r\n")
+	return err
+}
+
+// newMessageID returns a unique Message-ID, using the domain of the from address.
+func newMessageID(from string, date time.Time) (string, error) {
+	random := make([]byte, 12)
+	_, err := rand.Read(random)
+	if err != nil {
+		return "", err
+	}
+	domain := "alma-api-job-runner"
+	if at := strings.LastIndex(from, "@"); at >= 0 && at < len(from)-1 {
+		domain = strings.TrimSuffix(from[at+1:], ">")
+	}
+	return fmt.Sprintf("<%v.%v@%v>", date.UnixNano(), hex.EncodeToString(random), domain), nil
+}
+
+// htmlReportTemplate is the template of the HTML version of email reports.
+const htmlReportTemplate = `<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.Subject}}</title></head>
+<body style="font-family: sans-serif; font-size: 14px; color: #24292f;">
+<h2 style="margin-bottom: 4px;">{{.Subject}}</h2>
+{{- range .Instances}}
+<h3 style="margin-bottom: 4px;">{{.Name}}: <span style="color: {{outcomeColour .Outcome}};">{{if .StatusDesc}}{{.StatusDesc}}{{else}}{{.Status}}{{end}}</span></h3>
+<p style="margin-top: 0;"><a href="{{.InstanceURL}}">Job instance {{.ID}}</a></p>
+<table style="border-collapse: collapse;">
+{{- if .SubmitTime}}<tr><th style="text-align: left; padding: 2px 12px 2px 0;">Submitted</th><td>{{.SubmitTime}}</td></tr>{{end}}
+{{- if .StartTime}}<tr><th style="text-align: left; padding: 2px 12px 2px 0;">Started</th><td>{{.StartTime}}</td></tr>{{end}}
+{{- if .EndTime}}<tr><th style="text-align: left; padding: 2px 12px 2px 0;">Ended</th><td>{{.EndTime}}</td></tr>{{end}}
+{{- if .QueueDuration}}<tr><th style="text-align: left; padding: 2px 12px 2px 0;">Queue wait</th><td>{{.QueueDuration}}</td></tr>{{end}}
+{{- if .RunDuration}}<tr><th style="text-align: left; padding: 2px 12px 2px 0;">Execution time</th><td>{{.RunDuration}}</td></tr>{{end}}
+{{- if .WallDuration}}<tr><th style="text-align: left; padding: 2px 12px 2px 0;">Wall time</th><td>{{.WallDuration}}</td></tr>{{end}}
+</table>
+{{- if .Alerts}}
+<h4 style="margin-bottom: 4px;">Alerts</h4>
+<ul style="margin-top: 0;">{{range .Alerts}}<li>{{.}}</li>{{end}}</ul>
+{{- end}}
+{{- if .Counters}}
+<h4 style="margin-bottom: 4px;">Counters</h4>
+<table style="border-collapse: collapse;">
+<tr><th style="text-align: left; border-bottom: 1px solid #d0d7de; padding: 4px 12px 4px 0;">Counter</th><th style="text-align: right; border-bottom: 1px solid #d0d7de; padding: 4px 0;">Value</th></tr>
+{{- range .Counters}}
+<tr><td style="padding: 4px 12px 4px 0;">{{if .Desc}}{{.Desc}}{{else}}{{.Type}}{{end}}</td><td style="text-align: right; padding: 4px 0;">{{.Value}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+{{- else}}
+<p style="color: {{outcomeColour "failed"}};">No job instance finished.</p>
+{{- end}}
+<h4 style="margin-bottom: 4px;">Log</h4>
+<pre style="font-size: 12px; background: #f6f8fa; padding: 8px;">{{.Log}}</pre>
+</body>
+</html>
+`
+
+// outcomeColour returns the colour used to show a job instance's outcome.
+func outcomeColour(outcome string) string {
+	switch outcome {
+	case OutcomeSuccess:
+		return "#1a7f37"
+	case OutcomeWarning:
+		return "#9a6700"
+	case OutcomeFailed:
+		return "#cf222e"
+	default:
+		return "#57606a"
+	}
+}
+
+// RenderHTMLReport returns the HTML version of an email report, showing the
+// status, times, alerts and counters of each final job instance, then the log.
+func RenderHTMLReport(subject string, outputs []*InstanceOutput, logText string) (string, error) {
+	tmpl, err := template.New("report").Funcs(template.FuncMap{"outcomeColour": outcomeColour}).Parse(htmlReportTemplate)
+	if err != nil {
+		return "", err
+	}
+	html := new(strings.Builder)
+	err = tmpl.Execute(html, struct {
+		Subject   string
+		Instances []*InstanceOutput
+		Log       string
+	}{subject, outputs, logText})
+	return html.String(), err
+}
+
+// NewEmailReport returns the email report of the run. The log is the report's
+// text, and is attached with each final job instance in the output format, or
+// as XML if the output format is text.
+func (rc *RunConfig) NewEmailReport(subject, logText string, outputs []*InstanceOutput) (*EmailReport, error) {
+	html, err := RenderHTMLReport(subject, outputs, logText)
+	if err != nil {
+		return nil, err
+	}
+	report := &EmailReport{Subject: subject, Text: logText, HTML: html}
+	format, contentType := OutputXML, "application/xml"
+	if rc.Output == OutputJSON {
+		format, contentType = OutputJSON, "application/json"
+	}
+	for _, output := range outputs {
+		data := new(bytes.Buffer)
+		err := WriteInstanceOutputs(data, []*InstanceOutput{output}, format)
+		if err != nil {
+			return nil, err
+		}
+		report.Attachments = append(report.Attachments, Attachment{
+			Filename:    fmt.Sprintf("job-instance-%v.%v", output.ID, format),
+			ContentType: contentType,
+			Data:        data.Bytes(),
+		})
+	}
+	report.Attachments = append(report.Attachments, Attachment{Filename: "log.txt", ContentType: "text/plain; charset=utf-8", Data: []byte(logText)})
+	return report, nil
+}
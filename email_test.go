@@ -0,0 +1,116 @@
+// Copyright 2024 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cu-library/alma-api-job-runner/alma"
+)
+
+func TestBuildMessage(t *testing.T) {
+	report := &EmailReport{
+		Subject:     "Nightly export -- Completed Successfully",
+		Text:        "Job finished.\n",
+		HTML:        "<p>Job finished.</p>",
+		Attachments: []Attachment{{Filename: "log.txt", ContentType: "text/plain; charset=utf-8", Data: []byte("Job finished.\n")}},
+	}
+	date := time.Date(2024, time.January, 8, 10, 0, 0, 0, time.UTC)
+	message, err := BuildMessage(report, "runner@library.example.com", []string{"a@example.com", "b@example.com"}, date)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := mail.ReadMessage(bytes.NewReader(message))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, expected := range map[string]string{
+		"From":         "runner@library.example.com",
+		"To":           "a@example.com, b@example.com",
+		"Subject":      report.Subject,
+		"Date":         "Mon, 08 Jan 2024 10:00:00 +0000",
+		"MIME-Version": "1.0",
+	} {
+		if value := parsed.Header.Get(key); value != expected {
+			t.Errorf("%v: expected %q, got %q.", key, expected, value)
+		}
+	}
+	if messageID := parsed.Header.Get("Message-ID"); !strings.HasSuffix(messageID, "@library.example.com>") {
+		t.Errorf("Unexpected Message-ID %q.", messageID)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/mixed" {
+		t.Fatalf("Unexpected Content-Type %q.", parsed.Header.Get("Content-Type"))
+	}
+	mixed := multipart.NewReader(parsed.Body, params["boundary"])
+	body, err := mixed.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mediaType, params, err = mime.ParseMediaType(body.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("Unexpected body Content-Type %q.", body.Header.Get("Content-Type"))
+	}
+	alternative := multipart.NewReader(body, params["boundary"])
+	for _, expected := range []struct{ mediaType, content string }{
+		{"text/plain", report.Text},
+		{"text/html", report.HTML},
+	} {
+		part, err := alternative.NextPart()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, _ := io.ReadAll(part)
+		if !strings.HasPrefix(part.Header.Get("Content-Type"), expected.mediaType) || strings.ReplaceAll(string(content), "\r\n", "\n") != expected.content {
+			t.Errorf("Unexpected %v part %q.", expected.mediaType, content)
+		}
+	}
+
+	attachment, err := mixed.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attachment.FileName() != "log.txt" || attachment.Header.Get("Content-Transfer-Encoding") != "base64" {
+		t.Errorf("Unexpected attachment headers %v.", attachment.Header)
+	}
+	_, err = mixed.NextPart()
+	if err != io.EOF {
+		t.Errorf("Expected only one attachment, got %v.", err)
+	}
+}
+
+func TestNewEmailReport(t *testing.T) {
+	rc := &RunConfig{Name: "Nightly export", Output: OutputJSON, DisplayTimezone: "UTC"}
+	instance := &alma.AlmaJobInstance{
+		ID:       "12345",
+		Link:     "https://example.com/almaws/v1/conf/jobs/M47/instances/12345",
+		Status:   &alma.DescAndValue{Desc: "Completed with Errors", Value: "COMPLETED_FAILED"},
+		Alerts:   []alma.DescAndValue{{Desc: "<Some records failed>", Value: "alert_general_error"}},
+		Counters: []alma.Counter{{Type: alma.DescAndValue{Desc: "Records failed", Value: "label.failed"}, Value: "3"}},
+	}
+	report, err := rc.NewEmailReport("Nightly export -- Completed with Errors", "log line\n", []*InstanceOutput{rc.NewInstanceOutput(instance, nil)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"#cf222e", "Records failed", "&lt;Some records failed&gt;", instance.Link, "log line"} {
+		if !strings.Contains(report.HTML, expected) {
+			t.Errorf("Expected %q in the HTML report:\n%v", expected, report.HTML)
+		}
+	}
+	if len(report.Attachments) != 2 || report.Attachments[0].Filename != "job-instance-12345.json" || report.Attachments[1].Filename != "log.txt" {
+		t.Fatalf("Unexpected attachments %+v.", report.Attachments)
+	}
+	if !bytes.Contains(report.Attachments[0].Data, []byte(`"status": "COMPLETED_FAILED"`)) {
+		t.Errorf("Unexpected instance attachment:\n%s", report.Attachments[0].Data)
+	}
+}
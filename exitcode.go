@@ -0,0 +1,109 @@
+// Copyright 2024 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+
+	"github.com/cu-library/alma-api-job-runner/alma"
+)
+
+// The exit codes of the commands. Every command exits with ExitConfigError
+// when its settings are invalid, and the commands which run or monitor jobs
+// use the other codes to describe the outcome.
+const (
+	// ExitSuccess is used when the job completed successfully, or the run was skipped.
+	ExitSuccess = 0
+
+	// ExitFailure is used for errors which don't have their own exit code.
+	ExitFailure = 1
+
+	// ExitConfigError is used when the settings or parameters are missing or invalid.
+	ExitConfigError = 2
+
+	// ExitSubmitFailure is used when the job couldn't be submitted.
+	ExitSubmitFailure = 3
+
+	// ExitMonitorFailure is used when the job instance couldn't be monitored until it finished.
+	ExitMonitorFailure = 4
+
+	// ExitJobFailed is used when the job failed or was aborted in Alma.
+	ExitJobFailed = 5
+
+	// ExitJobWarning is used when the job completed with warnings, unless warnings are ignored.
+	ExitJobWarning = 6
+
+	// ExitMonitorTimeout is used when the job instance didn't finish before monitoring stopped.
+	ExitMonitorTimeout = 7
+
+	// ExitParamDrift is used when the parameters don't match the job's definition in Alma,
+	// and the job's drift policy is strict. The drift command uses it too.
+	ExitParamDrift = 8
+)
+
+// ExitCode returns the exit code for the outcome of a run: the final job
+// instance, or the error the run failed with. If failOnWarning is false,
+// jobs which completed with warnings are treated as successful.
+func ExitCode(instance *alma.AlmaJobInstance, err error, failOnWarning bool) int {
+	if err != nil {
+		return errorExitCode(err)
+	}
+	if instance == nil {
+		return ExitFailure
+	}
+	status := instance.JobStatus()
+	switch {
+	case status.IsSuccess():
+		return ExitSuccess
+	case status.IsWarning() && !failOnWarning:
+		return ExitSuccess
+	case status.IsWarning():
+		return ExitJobWarning
+	default:
+		return ExitJobFailed
+	}
+}
+
+// errorExitCode returns the exit code for the error a run failed with.
+func errorExitCode(err error) int {
+	if errors.Is(err, ErrJobSkipped) {
+		return ExitSuccess
+	}
+	if errors.Is(err, ErrParameterDrift) {
+		return ExitParamDrift
+	}
+	if errors.Is(err, ErrInvalidSettings) {
+		return ExitConfigError
+	}
+	var runErr *RunError
+	if !errors.As(err, &runErr) {
+		return ExitFailure
+	}
+	switch runErr.Stage {
+	case StageConfig:
+		return ExitConfigError
+	case StageSubmit:
+		return ExitSubmitFailure
+	case StageMonitor:
+		if errors.Is(err, alma.ErrMonitorTimeout) {
+			return ExitMonitorTimeout
+		}
+		return ExitMonitorFailure
+	default:
+		return ExitFailure
+	}
+}
+
+// MaxExitCode returns the highest of the exit codes, which is used
+// by the commands which run or monitor several jobs at once.
+func MaxExitCode(codes ...int) int {
+	maxCode := ExitSuccess
+	for _, code := range codes {
+		if code > maxCode {
+			maxCode = code
+		}
+	}
+	return maxCode
+}
@@ -0,0 +1,119 @@
+// Copyright 2024 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cu-library/alma-api-job-runner/alma"
+)
+
+func TestExitCode(t *testing.T) {
+	instance := func(status alma.JobStatus) *alma.AlmaJobInstance {
+		return &alma.AlmaJobInstance{Status: &alma.DescAndValue{Value: string(status)}}
+	}
+	errSubmit := errors.New("connection refused")
+	tests := []struct {
+		name          string
+		instance      *alma.AlmaJobInstance
+		err           error
+		failOnWarning bool
+		expected      int
+	}{
+		{"success", instance(alma.StatusCompletedSuccess), nil, true, ExitSuccess},
+		{"no bulks", instance(alma.StatusCompletedNoBulks), nil, true, ExitSuccess},
+		{"failed", instance(alma.StatusCompletedFailed), nil, true, ExitJobFailed},
+		{"aborted", instance(alma.StatusSystemAborted), nil, false, ExitJobFailed},
+		{"warning", instance(alma.StatusCompletedWarning), nil, true, ExitJobWarning},
+		{"ignored warning", instance(alma.StatusCompletedWarning), nil, false, ExitSuccess},
+		{"skipped", nil, fmt.Errorf("%w: job instance 1 is Running", ErrJobSkipped), true, ExitSuccess},
+		{"settings", nil, fmt.Errorf("%w: a domain is required", ErrInvalidSettings), true, ExitConfigError},
+		{"params", nil, &RunError{Stage: StageConfig, Err: errSubmit}, true, ExitConfigError},
+		{"drift", nil, &RunError{Stage: StageConfig, Err: fmt.Errorf("%w: set_id was removed", ErrParameterDrift)}, true, ExitParamDrift},
+		{"submit", nil, &RunError{Stage: StageSubmit, Err: errSubmit}, true, ExitSubmitFailure},
+		{"monitor", nil, &RunError{Stage: StageMonitor, Err: &alma.RetryError{Attempts: 3, Err: errSubmit}}, true, ExitMonitorFailure},
+		{"timeout", nil, &RunError{Stage: StageMonitor, Err: fmt.Errorf("%w: 1h", alma.ErrMonitorTimeout)}, true, ExitMonitorTimeout},
+		{"other", nil, errSubmit, true, ExitFailure},
+	}
+	for _, test := range tests {
+		code := ExitCode(test.instance, test.err, test.failOnWarning)
+		if code != test.expected {
+			t.Errorf("%v: expected exit code %v, got %v.", test.name, test.expected, code)
+		}
+	}
+	if code := MaxExitCode(ExitSuccess, ExitJobWarning, ExitSubmitFailure); code != ExitJobWarning {
+		t.Errorf("Expected the highest exit code, got %v.", code)
+	}
+}
+
+func TestDriftErrorExitCode(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected int
+	}{
+		{fmt.Errorf("%w: a domain is required", ErrInvalidSettings), ExitConfigError},
+		{fmt.Errorf("%w: unknown setting", ErrInvalidConfig), ExitConfigError},
+		{errors.New("connection refused"), ExitFailure},
+	}
+	for _, test := range tests {
+		if code := driftErrorExitCode(test.err); code != test.expected {
+			t.Errorf("%v: expected exit code %v, got %v.", test.err, test.expected, code)
+		}
+	}
+}
+
+func TestRunWithoutStatus(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/almaws/v1/conf/jobs/M47":
+			fmt.Fprintf(w, `<job><additional_info link="https://%v/almaws/v1/conf/jobs/M47/instances/12345">Job submitted.</additional_info></job>`, r.Host)
+		case r.URL.Path == "/almaws/v1/conf/jobs/M47/instances/12345":
+			// The instance has ended, but has no status.
+			fmt.Fprint(w, `<job_instance><id>12345</id><end_time>2015-04-15T13:07:44.359Z</end_time></job_instance>`)
+		default:
+			t.Errorf("Unexpected request %v %v.", r.Method, r.URL)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	params := filepath.Join(dir, "params.xml")
+	err := os.WriteFile(params, []byte(`<job><parameters><parameter><name>set_id</name><value>1</value></parameter></parameters></job>`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc := &RunConfig{}
+	rc.RegisterFlags(flag.NewFlagSet("run", flag.ContinueOnError))
+	rc.Domain = strings.TrimPrefix(server.URL, "https://")
+	rc.Key = "testkey"
+	rc.JobPath = "/almaws/v1/conf/jobs/M47?op=run"
+	rc.Params = params
+	rc.OutputFile = filepath.Join(dir, "instance.xml")
+	rc.LockDir = ""
+	rc.StateDir = ""
+	rc.httpClient = server.Client()
+
+	instance, err := rc.Run(context.Background(), io.Discard, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := ExitCode(instance, err, rc.FailOnWarning); code != ExitJobFailed {
+		t.Errorf("Expected an instance without a status to exit with %v, got %v.", ExitJobFailed, code)
+	}
+	if instance.StatusDesc() != "Unknown status" {
+		t.Errorf("Unexpected status description %q.", instance.StatusDesc())
+	}
+}
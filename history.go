@@ -0,0 +1,268 @@
+// Copyright 2024 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cu-library/alma-api-job-runner/alma"
+)
+
+const (
+	// historyDateLayout is the format of the history command's date range flags.
+	historyDateLayout = "2006-01-02"
+
+	// defaultHistoryDays is how many days of job instances the history command lists by default.
+	defaultHistoryDays = 30
+)
+
+// HistoryEntry is the summary of a job instance printed by the history command.
+type HistoryEntry struct {
+	ID          string `json:"id"`
+	Status      string `json:"status"`
+	SubmittedBy string `json:"submitted_by"`
+	SubmitTime  string `json:"submit_time"`
+	StartTime   string `json:"start_time"`
+	EndTime     string `json:"end_time"`
+	// QueueDuration is the time from submission to start, RunDuration from start
+	// to end, and WallDuration from submission to end. Each is empty if unknown.
+	QueueDuration string            `json:"queue_duration"`
+	RunDuration   string            `json:"run_duration"`
+	WallDuration  string            `json:"wall_duration"`
+	Counters      []InstanceCounter `json:"counters"`
+}
+
+// InstanceCounter is a counter of a job instance, like the number of records processed.
+type InstanceCounter struct {
+	Type  string `json:"type"`
+	Desc  string `json:"desc"`
+	Value string `json:"value"`
+}
+
+// HistoryFilter selects the job instances and counters printed by the history command.
+// Zero values aren't used as filters.
+type HistoryFilter struct {
+	// SubmittedBy matches the ID or name of the instance's submitter, ignoring case.
+	SubmittedBy string
+	// Counters are the types or descriptions of the counters to print, ignoring case.
+	Counters []string
+}
+
+// historySettings are the settings of the history command, besides the run settings.
+type historySettings struct {
+	JobID     string
+	From      string
+	To        string
+	Status    string
+	Submitter string
+	Counters  string
+	Format    string
+}
+
+// RegisterFlags registers the history command's settings as flags in fs.
+func (hs *historySettings) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&hs.JobID, "jobid", "", "The ID of the job. (ex: M47) Defaults to the ID in the url setting.")
+	fs.StringVar(&hs.From, "from", "", fmt.Sprintf("List instances submitted on or after this date. (ex: 2024-01-31) Defaults to %v days before the to date.", defaultHistoryDays))
+	fs.StringVar(&hs.To, "to", "", "List instances submitted on or before this date. Defaults to today.")
+	fs.StringVar(&hs.Status, "status", "", "Only list instances with this status. (ex: COMPLETED_SUCCESS)")
+	fs.StringVar(&hs.Submitter, "submitter", "", "Only list instances submitted by this user, matching the user's ID or name, ignoring case.")
+	fs.StringVar(&hs.Counters, "counters", "", "The counters to print, by type or description, comma delimited. All counters are printed by default.")
+	fs.StringVar(&hs.Format, "format", FormatTable, "The output format: table, json or csv.")
+}
+
+// historyCommand lists the past instances of a job.
+func historyCommand(args []string) {
+	rc := &RunConfig{}
+	fs := newFlagSet("history", "List the instances of a job submitted over a date range, with their status, times and counters.")
+	rc.RegisterFlags(fs)
+	configPath, jobName := registerConfigFlags(fs)
+	hs := &historySettings{}
+	hs.RegisterFlags(fs)
+	parseFlags(fs, args)
+
+	var query alma.JobInstancesQuery
+	configureCommand(fs, *configPath, *jobName, func() error {
+		if hs.JobID == "" && rc.JobPath != "" {
+			id, err := JobID(rc.JobPath)
+			if err != nil {
+				return err
+			}
+			hs.JobID = id
+		}
+		if hs.JobID == "" {
+			return fmt.Errorf("%w: %v, using the jobid or url setting", ErrInvalidSettings, ErrMissingJobID)
+		}
+		var err error
+		query, err = historyQuery(hs.From, hs.To, hs.Status, time.Now())
+		if err != nil {
+			return err
+		}
+		err = checkFormat(hs.Format)
+		if err != nil {
+			return err
+		}
+		return rc.ValidateMonitor()
+	})
+
+	// Cancel any in-progress requests when the process is interrupted.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	client, err := rc.newClient(log.Default())
+	if err != nil {
+		exitConfigError("FATAL: Error creating Alma API client:", err)
+	}
+	jobURL, err := client.URL(alma.JobPath(hs.JobID))
+	if err != nil {
+		exitConfigError("FATAL: Error building the job URL:", err)
+	}
+	instances, err := client.AllJobInstances(ctx, jobURL, query)
+	if err != nil {
+		stop()
+		log.Fatalln("FATAL: Error listing job instances:", err)
+	}
+	filter := HistoryFilter{SubmittedBy: hs.Submitter}
+	if hs.Counters != "" {
+		filter.Counters = TrimSpaceAll(strings.Split(hs.Counters, ","))
+	}
+	err = WriteHistory(os.Stdout, HistoryEntries(instances, filter, rc.displayLocation()), hs.Format)
+	if err != nil {
+		stop()
+		log.Fatalln("FATAL:", err)
+	}
+}
+
+// historyQuery builds the query for the job instances submitted between from and to,
+// which are dates like 2024-01-31. By default, to is today and from is
+// defaultHistoryDays before to.
+func historyQuery(from, to, status string, now time.Time) (alma.JobInstancesQuery, error) {
+	query := alma.JobInstancesQuery{SubmitDateTo: now, Status: strings.ToUpper(status)}
+	var err error
+	if to != "" {
+		query.SubmitDateTo, err = time.Parse(historyDateLayout, to)
+		if err != nil {
+			return query, fmt.Errorf("%w: to: %v", ErrInvalidSettings, err)
+		}
+	}
+	query.SubmitDateFrom = query.SubmitDateTo.AddDate(0, 0, -defaultHistoryDays)
+	if from != "" {
+		query.SubmitDateFrom, err = time.Parse(historyDateLayout, from)
+		if err != nil {
+			return query, fmt.Errorf("%w: from: %v", ErrInvalidSettings, err)
+		}
+	}
+	if query.SubmitDateFrom.After(query.SubmitDateTo) {
+		return query, fmt.Errorf("%w: the from date is after the to date", ErrInvalidSettings)
+	}
+	return query, nil
+}
+
+// HistoryEntries summarizes the job instances which match the filter, with their times in loc.
+func HistoryEntries(instances []alma.AlmaJobInstance, filter HistoryFilter, loc *time.Location) []HistoryEntry {
+	entries := make([]HistoryEntry, 0, len(instances))
+	for i := range instances {
+		instance := &instances[i]
+		if filter.SubmittedBy != "" && (instance.SubmittedBy == nil ||
+			(!strings.EqualFold(instance.SubmittedBy.Value, filter.SubmittedBy) && !strings.EqualFold(instance.SubmittedBy.Desc, filter.SubmittedBy))) {
+			continue
+		}
+		timing := NewInstanceTiming(instance, loc)
+		entry := HistoryEntry{
+			ID:            instance.ID,
+			SubmitTime:    formatTime(timing.Submit),
+			StartTime:     formatTime(timing.Start),
+			EndTime:       formatTime(timing.End),
+			QueueDuration: timing.Queue,
+			RunDuration:   timing.Run,
+			WallDuration:  timing.Wall,
+			Counters:      []InstanceCounter{},
+		}
+		if instance.SubmittedBy != nil {
+			entry.SubmittedBy = instance.SubmittedBy.Value
+		}
+		if instance.Status != nil {
+			entry.Status = instance.Status.Value
+		}
+		for _, counter := range instance.Counters {
+			if !matchesCounter(counter, filter.Counters) {
+				continue
+			}
+			entry.Counters = append(entry.Counters, InstanceCounter{Type: counter.Type.Value, Desc: counter.Type.Desc, Value: counter.Value})
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// matchesCounter reports whether the counter's type or description is one of names,
+// ignoring case. Every counter matches if names is empty.
+func matchesCounter(counter alma.Counter, names []string) bool {
+	if len(names) == 0 {
+		return true
+	}
+	for _, name := range names {
+		if strings.EqualFold(counter.Type.Value, name) || strings.EqualFold(counter.Type.Desc, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// countersText returns the counters on one line, like "Records processed: 5, Records failed: 0".
+func countersText(counters []InstanceCounter) string {
+	parts := make([]string, 0, len(counters))
+	for _, counter := range counters {
+		name := counter.Desc
+		if name == "" {
+			name = counter.Type
+		}
+		parts = append(parts, fmt.Sprintf("%v: %v", name, counter.Value))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// WriteHistory writes the history entries to w in the format.
+func WriteHistory(w io.Writer, entries []HistoryEntry, format string) error {
+	err := checkFormat(format)
+	if err != nil {
+		return err
+	}
+	switch format {
+	case FormatTable:
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tSTATUS\tSUBMITTED BY\tSUBMITTED\tSTARTED\tENDED\tQUEUED\tRAN\tTOTAL\tCOUNTERS")
+		for _, entry := range entries {
+			fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n", entry.ID, entry.Status, entry.SubmittedBy,
+				entry.SubmitTime, entry.StartTime, entry.EndTime, entry.QueueDuration, entry.RunDuration, entry.WallDuration, countersText(entry.Counters))
+		}
+		return tw.Flush()
+	case FormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(entries)
+	case FormatCSV:
+		cw := csv.NewWriter(w)
+		_ = cw.Write([]string{"id", "status", "submitted_by", "submit_time", "start_time", "end_time", "queue_duration", "run_duration", "wall_duration", "counters"})
+		for _, entry := range entries {
+			_ = cw.Write([]string{entry.ID, entry.Status, entry.SubmittedBy, entry.SubmitTime, entry.StartTime, entry.EndTime,
+				entry.QueueDuration, entry.RunDuration, entry.WallDuration, countersText(entry.Counters)})
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+// Copyright 2024 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cu-library/alma-api-job-runner/alma"
+)
+
+func TestHistoryQuery(t *testing.T) {
+	now := time.Date(2024, time.March, 31, 12, 0, 0, 0, time.UTC)
+	query, err := historyQuery("", "", "completed_success", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !query.SubmitDateTo.Equal(now) || !query.SubmitDateFrom.Equal(now.AddDate(0, 0, -defaultHistoryDays)) || query.Status != "COMPLETED_SUCCESS" {
+		t.Errorf("Unexpected default query %+v.", query)
+	}
+	query, err = historyQuery("2024-01-01", "2024-01-31", "", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if query.SubmitDateFrom.Format(historyDateLayout) != "2024-01-01" || query.SubmitDateTo.Format(historyDateLayout) != "2024-01-31" {
+		t.Errorf("Unexpected query %+v.", query)
+	}
+	for _, dates := range [][2]string{{"2024-02-01", "2024-01-31"}, {"January", ""}} {
+		_, err = historyQuery(dates[0], dates[1], "", now)
+		if !errors.Is(err, ErrInvalidSettings) {
+			t.Errorf("%v: expected ErrInvalidSettings, got %v.", dates, err)
+		}
+	}
+}
+
+func TestHistoryEntries(t *testing.T) {
+	instances := []alma.AlmaJobInstance{
+		{
+			ID:          "1",
+			SubmittedBy: &alma.DescAndValue{Desc: "API, Alma", Value: "exl_api"},
+			SubmitTime:  "2024-01-08T10:00:00.000Z",
+			StartTime:   "2024-01-08T10:00:05.000Z",
+			EndTime:     "2024-01-08T10:02:05.000Z",
+			Status:      &alma.DescAndValue{Desc: "Completed Successfully", Value: "COMPLETED_SUCCESS"},
+			Counters: []alma.Counter{
+				{Type: alma.DescAndValue{Desc: "Records processed", Value: "label.processed"}, Value: "120"},
+				{Type: alma.DescAndValue{Desc: "Records failed", Value: "label.failed"}, Value: "0"},
+			},
+		},
+		{
+			ID:          "2",
+			SubmittedBy: &alma.DescAndValue{Desc: "Smith, Jo", Value: "jsmith"},
+			Status:      &alma.DescAndValue{Value: "FAILED"},
+		},
+	}
+	loc := time.FixedZone("EST", -5*60*60)
+	entries := HistoryEntries(instances, HistoryFilter{SubmittedBy: "EXL_API", Counters: []string{"records processed"}}, loc)
+	if len(entries) != 1 || entries[0].ID != "1" || entries[0].RunDuration != "2m0s" || len(entries[0].Counters) != 1 {
+		t.Fatalf("Unexpected entries %+v.", entries)
+	}
+
+	buffer := new(bytes.Buffer)
+	err := WriteHistory(buffer, entries, FormatCSV)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "id,status,submitted_by,submit_time,start_time,end_time,queue_duration,run_duration,wall_duration,counters\n" +
+		"1,COMPLETED_SUCCESS,exl_api,2024-01-08T05:00:00-05:00,2024-01-08T05:00:05-05:00,2024-01-08T05:02:05-05:00,5s,2m0s,2m5s,Records processed: 120\n"
+	if buffer.String() != expected {
+		t.Errorf("Unexpected CSV output:\n%q", buffer.String())
+	}
+}
@@ -0,0 +1,166 @@
+// Copyright 2024 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/cu-library/alma-api-job-runner/alma"
+)
+
+// ErrMissingJobID is returned when the job to generate parameters for isn't known.
+var ErrMissingJobID = errors.New("a job ID is required")
+
+// initParamsSettings are the settings of the init-params command, besides the run settings.
+type initParamsSettings struct {
+	JobID string
+	Force bool
+}
+
+// RegisterFlags registers the init-params command's settings as flags in fs.
+func (ps *initParamsSettings) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&ps.JobID, "jobid", "", "The ID of the job. (ex: M47) Defaults to the ID in the url setting.")
+	fs.BoolVar(&ps.Force, "force", false, "Overwrite the parameters file if it exists.")
+}
+
+// initParamsCommand writes a parameters file for a job,
+// using the job's definition in Alma.
+func initParamsCommand(args []string) {
+	rc := &RunConfig{}
+	fs := newFlagSet("init-params", "Write a parameters file for a job, listing each of the job's parameters with its current value. "+
+		"The file is written to the params path, or stdout if it isn't set.")
+	rc.RegisterFlags(fs)
+	configPath, jobName := registerConfigFlags(fs)
+	ps := &initParamsSettings{}
+	ps.RegisterFlags(fs)
+	parseFlags(fs, args)
+	configureCommand(fs, *configPath, *jobName, func() error {
+		if ps.JobID == "" && rc.JobPath != "" {
+			id, err := JobID(rc.JobPath)
+			if err != nil {
+				return err
+			}
+			ps.JobID = id
+		}
+		if ps.JobID == "" {
+			return fmt.Errorf("%w: %v, using the jobid or url setting", ErrInvalidSettings, ErrMissingJobID)
+		}
+		return rc.ValidateMonitor()
+	})
+
+	// Cancel any in-progress requests when the process is interrupted.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	client, err := rc.newClient(log.Default())
+	if err != nil {
+		exitConfigError("FATAL: Error creating Alma API client:", err)
+	}
+	job, err := client.GetJob(ctx, ps.JobID)
+	if err != nil {
+		stop()
+		log.Fatalln("FATAL: Error getting the job:", err)
+	}
+
+	if rc.Params == "" {
+		err = WriteParamsSkeleton(os.Stdout, job, time.Now())
+	} else {
+		err = writeParamsFile(rc.Params, job, ps.Force)
+	}
+	if err != nil {
+		stop()
+		log.Fatalln("FATAL: Error writing the parameters:", err)
+	}
+	if rc.Params != "" {
+		log.Printf("Wrote the parameters of job %v to %v.\n", ps.JobID, rc.Params)
+	}
+}
+
+// writeParamsFile writes the parameters skeleton of the job to the file at path,
+// which isn't replaced if it exists unless force is true.
+func writeParamsFile(path string, job *alma.AlmaJob, force bool) error {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if force {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	file, err := os.OpenFile(path, flags, 0o644)
+	if err != nil {
+		return err
+	}
+	err = WriteParamsSkeleton(file, job, time.Now())
+	closeErr := file.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+// WriteParamsSkeleton writes a parameters file for the job, which LoadParameters can read.
+// Each parameter is preceded by a comment with its description, and parameters which
+// are likely to need a set ID or a date are marked with a TODO.
+func WriteParamsSkeleton(w io.Writer, job *alma.AlmaJob, generated time.Time) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "<!-- Parameters for job %v: %v -->\n", xmlComment(job.ID), xmlComment(job.Name))
+	fmt.Fprintf(bw, "<!-- Generated by alma-api-job-runner %v on %v from %v. -->\n", xmlComment(version), generated.Format(time.RFC3339), alma.JobPath(job.ID))
+	fmt.Fprintln(bw, "<job>")
+	fmt.Fprintln(bw, "  <parameters>")
+	for _, param := range job.Parameters {
+		comment := param.Name.Desc
+		if comment == "" {
+			comment = param.Name.Value
+		}
+		if needs := paramNeeds(param); needs != "" {
+			comment += " (TODO: set " + needs + ")"
+		}
+		fmt.Fprintf(bw, "    <!-- %v -->\n", xmlComment(comment))
+		fmt.Fprintln(bw, "    <parameter>")
+		fmt.Fprintf(bw, "      <name>%v</name>\n", xmlText(param.Name.Value))
+		fmt.Fprintf(bw, "      <value>%v</value>\n", xmlText(param.Value))
+		fmt.Fprintln(bw, "    </parameter>")
+	}
+	fmt.Fprintln(bw, "  </parameters>")
+	fmt.Fprintln(bw, "</job>")
+	return bw.Flush()
+}
+
+// paramNeeds returns "a set ID" or "a date" if the parameter is likely to need one, based on its name and description.
+func paramNeeds(param alma.Parameter) string {
+	name := strings.ToLower(param.Name.Value + " " + param.Name.Desc)
+	switch {
+	case strings.Contains(name, "set_id"), strings.Contains(name, "set id"):
+		return "a set ID"
+	case strings.Contains(name, "date"):
+		return "a date"
+	default:
+		return ""
+	}
+}
+
+// xmlText escapes s for use as XML character data.
+func xmlText(s string) string {
+	escaped := new(strings.Builder)
+	_ = xml.EscapeText(escaped, []byte(s))
+	return escaped.String()
+}
+
+// xmlComment makes s safe to use in an XML comment, which can't contain "--".
+func xmlComment(s string) string {
+	for strings.Contains(s, "--") {
+		s = strings.ReplaceAll(s, "--", "- -")
+	}
+	return strings.TrimSuffix(s, "-")
+}
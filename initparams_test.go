@@ -0,0 +1,64 @@
+// Copyright 2024 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cu-library/alma-api-job-runner/alma"
+)
+
+func TestWriteParamsSkeleton(t *testing.T) {
+	job := &alma.AlmaJob{
+		ID:   "M47",
+		Name: "Export Physical Items -- weekly",
+		Parameters: []alma.Parameter{
+			{Name: alma.DescAndValue{Desc: "Set", Value: "set_id"}},
+			{Name: alma.DescAndValue{Desc: "From date", Value: "from_date"}},
+			{Name: alma.DescAndValue{Desc: "Export format", Value: "task_ExportParams_outputFormat_string"}, Value: "CSV & XML"},
+		},
+	}
+	output := new(bytes.Buffer)
+	err := WriteParamsSkeleton(output, job, time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		"<!-- Parameters for job M47: Export Physical Items - - weekly -->",
+		"<!-- Set (TODO: set a set ID) -->",
+		"<!-- From date (TODO: set a date) -->",
+		"<!-- Export format -->",
+		"<value>CSV &amp; XML</value>",
+	} {
+		if !strings.Contains(output.String(), expected) {
+			t.Errorf("Expected %q in:\n%v", expected, output)
+		}
+	}
+
+	// The file can be read by LoadParameters.
+	paramsPath := filepath.Join(t.TempDir(), "params.xml")
+	err = os.WriteFile(paramsPath, output.Bytes(), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadParameters(paramsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []alma.Parameter{
+		{Name: alma.DescAndValue{Value: "set_id"}},
+		{Name: alma.DescAndValue{Value: "from_date"}},
+		{Name: alma.DescAndValue{Value: "task_ExportParams_outputFormat_string"}, Value: "CSV & XML"},
+	}
+	if !reflect.DeepEqual(loaded.Parameters, expected) {
+		t.Fatalf("Expected %+v, got %+v.", expected, loaded.Parameters)
+	}
+}
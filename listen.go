@@ -0,0 +1,112 @@
+// Copyright 2024 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/cu-library/alma-api-job-runner/alma"
+)
+
+const (
+	// DefaultWebhookDeadline is how long to wait for the job end webhook by default.
+	DefaultWebhookDeadline = 6 * time.Hour
+
+	// shutdownTimeout is how long to wait for webhook deliveries in progress when shutting down.
+	shutdownTimeout = 5 * time.Second
+)
+
+// listenSettings are the settings of the listen command, besides the run settings.
+type listenSettings struct {
+	Address  string
+	Path     string
+	Secret   string
+	Deadline time.Duration
+}
+
+// RegisterFlags registers the listen command's settings as flags in fs.
+func (ls *listenSettings) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&ls.Address, "listen", ":8080", "The address the webhook listener binds to.")
+	fs.StringVar(&ls.Path, "webhookpath", "/webhooks", "The path of the webhook listener's URL.")
+	fs.StringVar(&ls.Secret, "webhooksecret", "", "The secret of the webhook integration profile in Alma. Required.")
+	fs.DurationVar(&ls.Deadline, "webhookdeadline", DefaultWebhookDeadline, "How long to wait for the job end webhook before polling the job instance.")
+}
+
+// listenCommand submits a job, then waits for Alma's job end webhook
+// instead of polling the job instance.
+func listenCommand(args []string) {
+	rc := &RunConfig{}
+	fs := newFlagSet("listen", "Run a manual job in Alma, waiting for its job end webhook instead of polling.")
+	rc.RegisterFlags(fs)
+	configPath, jobName := registerConfigFlags(fs)
+	ls := &listenSettings{}
+	ls.RegisterFlags(fs)
+	parseFlags(fs, args)
+	configureRun(fs, rc, *configPath, *jobName)
+
+	if ls.Secret == "" {
+		exitConfigError("FATAL: The webhook secret is required. https://developers.exlibrisgroup.com/alma/integrations/webhooks/")
+	}
+
+	// Bind the webhook listener's address before anything else,
+	// so that the job isn't submitted if its webhook can't be received.
+	listener, err := net.Listen("tcp", ls.Address)
+	if err != nil {
+		log.Println("FATAL: Error starting the webhook listener:", err)
+		os.Exit(ExitConfigError)
+	}
+	log.Printf("Listening for webhooks at %v%v\n", listener.Addr(), ls.Path)
+
+	// Cancel any in-progress requests when the process is interrupted.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	instance, err := ls.Run(ctx, rc, listener, os.Stderr)
+	code := ExitCode(instance, err, rc.FailOnWarning)
+	if code != ExitSuccess {
+		stop()
+		os.Exit(code)
+	}
+}
+
+// Run serves webhooks on listener while it runs the job, so that the job end
+// webhook is received instead of polling the job instance. The listener is
+// closed once the run finishes.
+func (ls *listenSettings) Run(ctx context.Context, rc *RunConfig, listener net.Listener, logOutput io.Writer) (*alma.AlmaJobInstance, error) {
+	// Start the webhook listener before the job is submitted,
+	// so that the job end webhook isn't missed.
+	rc.Webhooks = alma.NewWebhookListener(ls.Secret, nil)
+	rc.WebhookDeadline = ls.Deadline
+	mux := http.NewServeMux()
+	mux.Handle(ls.Path, rc.Webhooks)
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Serve(listener)
+	}()
+
+	instance, err := rc.Run(ctx, logOutput, "")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	_ = server.Shutdown(shutdownCtx)
+	if serveErr := <-served; !errors.Is(serveErr, http.ErrServerClosed) {
+		log.Println("Webhook listener stopped: ", serveErr)
+	}
+	return instance, err
+}
@@ -0,0 +1,102 @@
+// Copyright 2024 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cu-library/alma-api-job-runner/alma"
+)
+
+func TestListenRun(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ls := &listenSettings{Path: "/webhooks", Secret: "secret", Deadline: time.Minute}
+	webhookURL := fmt.Sprintf("http://%v%v", listener.Addr(), ls.Path)
+
+	mu := new(sync.Mutex)
+	polls := 0
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/almaws/v1/conf/jobs/M47":
+			// Alma sends the job end webhook once the job finishes.
+			body := []byte(`{"id": "1", "action": "JOB_END", "job_instance": {"id": "12345", "status": {"value": "COMPLETED_SUCCESS", "desc": "Completed Successfully"}}}`)
+			mac := hmac.New(sha256.New, []byte(ls.Secret))
+			_, _ = mac.Write(body)
+			req, _ := http.NewRequestWithContext(r.Context(), http.MethodPost, webhookURL, bytes.NewReader(body))
+			req.Header.Set(alma.WebhookSignatureHeader, base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Errorf("Error sending the webhook: %v", err)
+			} else {
+				resp.Body.Close()
+			}
+			fmt.Fprintf(w, `<job><additional_info link="https://%v/almaws/v1/conf/jobs/M47/instances/12345">Job submitted.</additional_info></job>`, r.Host)
+		case r.URL.Path == "/almaws/v1/conf/jobs/M47/instances/12345":
+			mu.Lock()
+			polls++
+			mu.Unlock()
+			fmt.Fprint(w, `<job_instance><id>12345</id><end_time>2015-04-15T13:07:44.359Z</end_time><status desc="Completed Successfully">COMPLETED_SUCCESS</status></job_instance>`)
+		default:
+			t.Errorf("Unexpected request %v %v.", r.Method, r.URL)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	params := filepath.Join(dir, "params.xml")
+	err = os.WriteFile(params, []byte(`<job><parameters><parameter><name>set_id</name><value>1</value></parameter></parameters></job>`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc := &RunConfig{}
+	rc.RegisterFlags(flag.NewFlagSet("listen", flag.ContinueOnError))
+	rc.Domain = strings.TrimPrefix(server.URL, "https://")
+	rc.Key = "testkey"
+	rc.JobPath = "/almaws/v1/conf/jobs/M47?op=run"
+	rc.Params = params
+	rc.OutputFile = filepath.Join(dir, "instance.xml")
+	rc.LockDir = ""
+	rc.StateDir = ""
+	rc.httpClient = server.Client()
+
+	logOutput := new(bytes.Buffer)
+	instance, err := ls.Run(context.Background(), rc, listener, logOutput)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if instance.JobStatus() != alma.StatusCompletedSuccess || polls != 1 {
+		t.Errorf("Expected one request for the final job instance, got %v, status %v.", polls, instance.JobStatus())
+	}
+	// The listener's messages are logged with the run.
+	for _, expected := range []string{"Received job end webhook for job instance 12345.", "Job end webhook received"} {
+		if !strings.Contains(logOutput.String(), expected) {
+			t.Errorf("Expected %q in the log:\n%v", expected, logOutput)
+		}
+	}
+	// The listener is closed once the run finishes.
+	_, err = http.Get(webhookURL) //nolint:noctx
+	if err == nil {
+		t.Error("Expected the webhook listener to be closed.")
+	}
+}
@@ -0,0 +1,162 @@
+// Copyright 2024 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/cu-library/alma-api-job-runner/alma"
+)
+
+// ErrInvalidFormat is returned when an output format isn't recognized.
+var ErrInvalidFormat = errors.New("invalid output format")
+
+// Output formats of the list-jobs and history commands.
+const (
+	FormatTable = "table"
+	FormatJSON  = "json"
+	FormatCSV   = "csv"
+)
+
+// JobListing is the summary of a job printed by the list-jobs command.
+type JobListing struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Category string `json:"category"`
+	// URL is the submit URL of manual jobs, which is used as the url setting.
+	URL string `json:"url,omitempty"`
+}
+
+// listJobsSettings are the settings of the list-jobs command, besides the run settings.
+type listJobsSettings struct {
+	Type         string
+	Category     string
+	NameContains string
+	Format       string
+}
+
+// RegisterFlags registers the list-jobs command's settings as flags in fs.
+func (ls *listJobsSettings) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&ls.Type, "type", "", "Only list jobs of this type: MANUAL, SCHEDULED or OTHER.")
+	fs.StringVar(&ls.Category, "category", "", "Only list jobs in this category. (ex: NORMALIZATION)")
+	fs.StringVar(&ls.NameContains, "namecontains", "", "Only list jobs whose name contains this text, ignoring case.")
+	fs.StringVar(&ls.Format, "format", FormatTable, "The output format: table, json or csv.")
+}
+
+// listJobsCommand prints the institution's jobs, to help find the URL
+// and parameters of the jobs to run.
+func listJobsCommand(args []string) {
+	rc := &RunConfig{}
+	fs := newFlagSet("list-jobs", "List the jobs defined in Alma, with the URL used to submit each manual job.")
+	rc.RegisterFlags(fs)
+	configPath, jobName := registerConfigFlags(fs)
+	ls := &listJobsSettings{}
+	ls.RegisterFlags(fs)
+	parseFlags(fs, args)
+	configureCommand(fs, *configPath, *jobName, func() error {
+		err := checkFormat(ls.Format)
+		if err != nil {
+			return err
+		}
+		return rc.ValidateMonitor()
+	})
+
+	// Cancel any in-progress requests when the process is interrupted.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	client, err := rc.newClient(log.Default())
+	if err != nil {
+		exitConfigError("FATAL: Error creating Alma API client:", err)
+	}
+	jobs, err := client.AllJobs(ctx, alma.JobsQuery{Type: strings.ToUpper(ls.Type), Category: strings.ToUpper(ls.Category)})
+	if err != nil {
+		stop()
+		log.Fatalln("FATAL: Error listing jobs:", err)
+	}
+	listings := JobListings(jobs, ls.NameContains)
+	err = WriteJobListings(os.Stdout, listings, ls.Format)
+	if err != nil {
+		stop()
+		log.Fatalln("FATAL:", err)
+	}
+}
+
+// JobListings summarizes the jobs whose name contains nameContains, ignoring case.
+func JobListings(jobs []alma.AlmaJob, nameContains string) []JobListing {
+	nameContains = strings.ToLower(nameContains)
+	listings := make([]JobListing, 0, len(jobs))
+	for _, job := range jobs {
+		if !strings.Contains(strings.ToLower(job.Name), nameContains) {
+			continue
+		}
+		listing := JobListing{ID: job.ID, Name: job.Name}
+		if job.Type != nil {
+			listing.Type = job.Type.Value
+		}
+		if job.Category != nil {
+			listing.Category = job.Category.Desc
+		}
+		// Only manual jobs can be submitted using the API.
+		if listing.Type == "MANUAL" {
+			listing.URL = alma.SubmitPath(job.ID)
+		}
+		listings = append(listings, listing)
+	}
+	return listings
+}
+
+// checkFormat returns an error if the output format isn't recognized.
+func checkFormat(format string) error {
+	switch format {
+	case FormatTable, FormatJSON, FormatCSV:
+		return nil
+	default:
+		return fmt.Errorf("%w: %q, expected table, json or csv", ErrInvalidFormat, format)
+	}
+}
+
+// WriteJobListings writes the job listings to w in the format.
+func WriteJobListings(w io.Writer, listings []JobListing, format string) error {
+	err := checkFormat(format)
+	if err != nil {
+		return err
+	}
+	switch format {
+	case FormatTable:
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tNAME\tCATEGORY\tURL")
+		for _, listing := range listings {
+			fmt.Fprintf(tw, "%v\t%v\t%v\t%v\n", listing.ID, listing.Name, listing.Category, listing.URL)
+		}
+		return tw.Flush()
+	case FormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(listings)
+	case FormatCSV:
+		cw := csv.NewWriter(w)
+		_ = cw.Write([]string{"id", "name", "type", "category", "url"})
+		for _, listing := range listings {
+			_ = cw.Write([]string{listing.ID, listing.Name, listing.Type, listing.Category, listing.URL})
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+// Copyright 2024 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/cu-library/alma-api-job-runner/alma"
+)
+
+func TestJobListings(t *testing.T) {
+	jobs := []alma.AlmaJob{
+		{ID: "M1", Name: "Export Physical Items", Type: &alma.DescAndValue{Value: "MANUAL"}, Category: &alma.DescAndValue{Desc: "Export", Value: "EXPORT"}},
+		{ID: "S2", Name: "Export to Primo", Type: &alma.DescAndValue{Value: "SCHEDULED"}},
+		{ID: "M3", Name: "Change Physical Items", Type: &alma.DescAndValue{Value: "MANUAL"}},
+	}
+	listings := JobListings(jobs, "EXPORT")
+	if len(listings) != 2 {
+		t.Fatalf("Expected 2 jobs with export in their name, got %+v.", listings)
+	}
+	if listings[0].URL != "/almaws/v1/conf/jobs/M1?op=run" || listings[0].Category != "Export" {
+		t.Errorf("Unexpected listing %+v.", listings[0])
+	}
+	if listings[1].URL != "" {
+		t.Errorf("Expected scheduled jobs to have no submit URL, got %+v.", listings[1])
+	}
+
+	expected := map[string]string{
+		FormatTable: "ID  NAME                   CATEGORY  URL\n" +
+			"M1  Export Physical Items  Export    /almaws/v1/conf/jobs/M1?op=run\n" +
+			"S2  Export to Primo                  \n",
+		FormatCSV: "id,name,type,category,url\n" +
+			"M1,Export Physical Items,MANUAL,Export,/almaws/v1/conf/jobs/M1?op=run\n" +
+			"S2,Export to Primo,SCHEDULED,,\n",
+		FormatJSON: `[
+  {
+    "id": "M1",
+    "name": "Export Physical Items",
+    "type": "MANUAL",
+    "category": "Export",
+    "url": "/almaws/v1/conf/jobs/M1?op=run"
+  },
+  {
+    "id": "S2",
+    "name": "Export to Primo",
+    "type": "SCHEDULED",
+    "category": ""
+  }
+]
+`,
+	}
+	for format, output := range expected {
+		buffer := new(bytes.Buffer)
+		err := WriteJobListings(buffer, listings, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if buffer.String() != output {
+			t.Errorf("Unexpected %v output:\n%q", format, buffer.String())
+		}
+	}
+	err := WriteJobListings(new(bytes.Buffer), listings, "yaml")
+	if !errors.Is(err, ErrInvalidFormat) {
+		t.Errorf("Expected ErrInvalidFormat, got %v.", err)
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright 2024 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cu-library/alma-api-job-runner/alma"
+)
+
+// lockPollInterval is how often a run waiting for the lock tries to take it.
+const lockPollInterval = time.Second
+
+// ErrLocked is returned by AcquireLock when another run of the same job holds the lock.
+var ErrLocked = errors.New("another run of the job holds the lock")
+
+// RunLock is an advisory lock which prevents two runs of the same job from overlapping on one host.
+// The lock file stores the PID of the process holding the lock.
+type RunLock struct {
+	file *os.File
+}
+
+// LockPath returns the path of the lock file for the job at jobPath on
+// the Alma API server domain, in the directory dir.
+func LockPath(dir, domain, jobPath string) string {
+	sum := sha256.Sum256([]byte(domain + jobPath))
+	return filepath.Join(dir, "alma-api-job-runner-"+hex.EncodeToString(sum[:8])+".lock")
+}
+
+// AcquireLock takes the lock at path. If another process holds the lock, ErrLocked
+// is returned, unless wait is true, in which case the lock is tried until it is
+// free or the context is done.
+func AcquireLock(ctx context.Context, path string, wait bool, logger *log.Logger) (*RunLock, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	waiting := false
+	for {
+		locked, err := lockFile(file)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		if locked {
+			break
+		}
+		holder := describeLockHolder(readLockPID(file))
+		if !wait {
+			file.Close()
+			return nil, fmt.Errorf("%w: %v, held by %v", ErrLocked, path, holder)
+		}
+		if !waiting {
+			logger.Printf("Waiting for the lock %v, held by %v.\n", path, holder)
+			waiting = true
+		}
+		err = alma.Sleep(ctx, lockPollInterval)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+
+	// A PID left in the lock file is from a run which didn't release the lock.
+	pid := readLockPID(file)
+	if pid != 0 && pid != os.Getpid() && !processExists(pid) {
+		logger.Printf("Found a stale lock left by PID %v, which is no longer running.\n", pid)
+	}
+	err = writeLockPID(file, os.Getpid())
+	if err != nil {
+		_ = unlockFile(file)
+		file.Close()
+		return nil, err
+	}
+	return &RunLock{file: file}, nil
+}
+
+// Release clears the PID from the lock file and releases the lock.
+func (l *RunLock) Release() error {
+	err := l.file.Truncate(0)
+	unlockErr := unlockFile(l.file)
+	closeErr := l.file.Close()
+	switch {
+	case err != nil:
+		return err
+	case unlockErr != nil:
+		return unlockErr
+	default:
+		return closeErr
+	}
+}
+
+// readLockPID returns the PID stored in the lock file, or 0 if there isn't one.
+func readLockPID(file *os.File) int {
+	contents, err := io.ReadAll(io.NewSectionReader(file, 0, 64))
+	if err != nil {
+		return 0
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(contents)))
+	if err != nil {
+		return 0
+	}
+	return pid
+}
+
+// writeLockPID replaces the contents of the lock file with pid.
+func writeLockPID(file *os.File, pid int) error {
+	err := file.Truncate(0)
+	if err != nil {
+		return err
+	}
+	_, err = file.WriteAt([]byte(strconv.Itoa(pid)+"\n"), 0)
+	if err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+// describeLockHolder describes the process with the PID read from a held lock.
+func describeLockHolder(pid int) string {
+	switch {
+	case pid == 0:
+		return "an unknown process"
+	case !processExists(pid):
+		return fmt.Sprintf("PID %v, which is no longer running", pid)
+	default:
+		return fmt.Sprintf("PID %v", pid)
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2024 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLockPath(t *testing.T) {
+	a := LockPath("/tmp", "api-ca.hosted.exlibrisgroup.com", "/almaws/v1/conf/jobs/M1?op=run")
+	b := LockPath("/tmp", "api-ca.hosted.exlibrisgroup.com", "/almaws/v1/conf/jobs/M2?op=run")
+	c := LockPath("/tmp", "api-eu.hosted.exlibrisgroup.com", "/almaws/v1/conf/jobs/M1?op=run")
+	if a == b || a == c {
+		t.Fatalf("Expected different jobs to use different lock files, got %v, %v and %v.", a, b, c)
+	}
+}
+
+func TestAcquireLock(t *testing.T) {
+	path := LockPath(t.TempDir(), "api-ca.hosted.exlibrisgroup.com", "/almaws/v1/conf/jobs/M1?op=run")
+	logOutput := new(bytes.Buffer)
+	logger := log.New(logOutput, "", 0)
+
+	lock, err := AcquireLock(context.Background(), path, false, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(string(contents)) != strconv.Itoa(os.Getpid()) {
+		t.Fatalf("Expected the lock file to store our PID, got %q.", contents)
+	}
+	_, err = AcquireLock(context.Background(), path, false, logger)
+	if !errors.Is(err, ErrLocked) {
+		t.Fatalf("Expected ErrLocked, got %v.", err)
+	}
+
+	// A waiting run takes the lock once it is released.
+	acquired := make(chan error)
+	go func() {
+		second, err := AcquireLock(context.Background(), path, true, logger)
+		if err == nil {
+			err = second.Release()
+		}
+		acquired <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	err = lock.Release()
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-acquired:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("The waiting run didn't take the lock.")
+	}
+}
+
+func TestAcquireLockStale(t *testing.T) {
+	path := LockPath(t.TempDir(), "api-ca.hosted.exlibrisgroup.com", "/almaws/v1/conf/jobs/M1?op=run")
+	// PIDs are limited to 2^22 on Linux, so this process can't exist.
+	err := os.WriteFile(path, []byte("99999999\n"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logOutput := new(bytes.Buffer)
+	lock, err := AcquireLock(context.Background(), path, false, log.New(logOutput, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lock.Release()
+	if !strings.Contains(logOutput.String(), "stale lock left by PID 99999999") {
+		t.Fatalf("Expected the stale lock to be reported, got %q.", logOutput.String())
+	}
+}
@@ -0,0 +1,39 @@
+// Copyright 2024 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile takes the lock on file unless the PID stored in it belongs to a running process.
+// Windows has no flock, so unlike on other systems, two processes starting at
+// the same moment might both take the lock.
+func lockFile(file *os.File) (bool, error) {
+	pid := readLockPID(file)
+	return pid == 0 || pid == os.Getpid() || !processExists(pid), nil
+}
+
+// unlockFile does nothing, the lock is released when the PID is cleared from the file.
+func unlockFile(file *os.File) error {
+	return nil
+}
+
+// processExists reports whether a process with the PID is running.
+func processExists(pid int) bool {
+	const stillActive = 259
+	handle, err := syscall.OpenProcess(syscall.PROCESS_QUERY_INFORMATION, false, uint32(pid))
+	if err != nil {
+		return false
+	}
+	defer syscall.CloseHandle(handle)
+	var exitCode uint32
+	err = syscall.GetExitCodeProcess(handle, &exitCode)
+	return err == nil && exitCode == stillActive
+}
@@ -5,23 +5,20 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"encoding/xml"
 	"flag"
 	"fmt"
 	"io"
 	"log"
-	"net/http"
-	"net/smtp"
-	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"runtime"
-	"strconv"
 	"strings"
-	"time"
+	"syscall"
 
+	"github.com/cu-library/alma-api-job-runner/alma"
 	"github.com/cu-library/overridefromenv"
 )
 
@@ -37,52 +34,96 @@ const (
 )
 
 func main() {
-	// Define the command line flags.
-	name := flag.String("name", "Alma API Job Runner", "The name for the job, used for logging and reports only.")
-	domain := flag.String("domain", "", "The domain of the Alma API server URL to use. Required. (ex: api-ca.hosted.exlibrisgroup.com)")
-	key := flag.String("key", "", "The Alma API key. Required.")
-	jobPath := flag.String("url", "", "The URL to which the job's parameters should be POST'd. Starts with a /. Required.")
-	params := flag.String("params", "", "A file storing the XML representation of the job's parameters. Required.")
-	timeout := flag.Int("timeout", 10, "The number of seconds to wait on the Alma API when submitting requests.")
-	maxRetries := flag.Int("retries", 5, "If calling the Alma API results in an error, how many times will the job be resubmitted.")
-	sendEmail := flag.Bool("email", false, "Send an email report.")
-	smtpServer := flag.String("smtpserver", "", "The SMTP server to use for sending report emails.")
-	smtpPort := flag.Int("smtpport", DefaultSMTPPort, "The port to use when connecting to the SMTP server.")
-	smtpUsername := flag.String("smtpusername", "", "The username to use when connecting to the SMTP server.")
-	smtpPassword := flag.String("smtppassword", "", "The password/secret to use when connecting to the SMTP server.")
-	smtpAuthMethod := flag.String("smtpauthmethod", "", "The Auth method used by the SMTP server: plain or crammd5. No authentication is used by default.")
-	mailTo := flag.String("mailto", "", "The email address to send reports to, comma delimited.")
-	mailFrom := flag.String("mailfrom", "", "The email address reports are send from.")
+	// The first argument selects the command. If it's a flag,
+	// the run command is used, which was the tool's only behaviour
+	// before commands were added.
+	command, args := "run", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
+	switch command {
+	case "run":
+		runCommand(args)
+	case "daemon":
+		daemonCommand(args)
+	case "workflow":
+		workflowCommand(args)
+	case "listen":
+		listenCommand(args)
+	case "resume":
+		resumeCommand(args)
+	case "watch":
+		watchCommand(args)
+	case "list-jobs":
+		listJobsCommand(args)
+	case "init-params":
+		initParamsCommand(args)
+	case "drift":
+		driftCommand(args)
+	case "history":
+		historyCommand(args)
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q.\n", command)
+		printCommands(os.Stderr)
+		os.Exit(2)
+	}
+}
 
-	// Define the Usage function, which prints to Stderr
-	// helpful information about the tool.
-	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "alma-api-job-runner:\n")
-		fmt.Fprintf(os.Stderr, "Run a manual job in Alma using the Jobs API.\n")
+// printCommands prints the list of commands.
+func printCommands(w io.Writer) {
+	fmt.Fprintln(w, "Usage: alma-api-job-runner [command] [flags]")
+	fmt.Fprintln(w, "Commands:")
+	fmt.Fprintln(w, "  run         Submit a job and monitor it until it completes. (default)")
+	fmt.Fprintln(w, "  daemon      Run the jobs in a configuration file on their cron schedules.")
+	fmt.Fprintln(w, "  workflow    Run a graph of jobs, where each job runs after the jobs it depends on succeed.")
+	fmt.Fprintln(w, "  listen      Submit a job and wait for its job end webhook, polling only as a fallback.")
+	fmt.Fprintln(w, "  resume      Resume monitoring runs which stopped before their job finished, and send their reports.")
+	fmt.Fprintln(w, "  watch       Monitor job instances which are already running, without submitting a job.")
+	fmt.Fprintln(w, "  list-jobs   List the jobs defined in Alma, with the URL used to submit each manual job.")
+	fmt.Fprintln(w, "  history     List the instances of a job submitted over a date range, with their status, times and counters.")
+	fmt.Fprintln(w, "  drift       Check the parameters files of the jobs in a configuration file against the jobs' definitions in Alma.")
+	fmt.Fprintln(w, "  init-params Write a parameters file for a job, using the job's definition in Alma.")
+}
+
+// newFlagSet returns a FlagSet for a command. Its Usage function
+// prints to Stderr helpful information about the tool.
+func newFlagSet(command, description string) *flag.FlagSet {
+	fs := flag.NewFlagSet(command, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "alma-api-job-runner %v:\n", command)
+		fmt.Fprintf(os.Stderr, "%v\n", description)
 		fmt.Fprintf(os.Stderr, "Version %v\n", version)
-		fmt.Fprintf(flag.CommandLine.Output(), "Compiled with %v\n", runtime.Version())
-		flag.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "Compiled with %v\n", runtime.Version())
+		printCommands(os.Stderr)
+		fs.PrintDefaults()
 		fmt.Fprintln(os.Stderr, "  Environment variables read when flag is unset:")
 
-		flag.VisitAll(func(f *flag.Flag) {
+		fs.VisitAll(func(f *flag.Flag) {
 			fmt.Fprintf(os.Stderr, "  %v%v\n", EnvPrefix, strings.ToUpper(f.Name))
 		})
 	}
+	return fs
+}
 
+// parseFlags processes the command's flags. If any flags have not been set,
+// environment variables which set them are used. Afterwards, the environment
+// variables which might contain secrets are unset.
+func parseFlags(fs *flag.FlagSet, args []string) {
 	// Process the flags.
-	flag.Parse()
+	_ = fs.Parse(args)
 
 	// If any flags have not been set, see if there are
 	// environment variables that set them.
-	err := overridefromenv.Override(flag.CommandLine, EnvPrefix)
+	err := overridefromenv.Override(fs, EnvPrefix)
 	if err != nil {
-		log.Fatalln(err)
+		exitConfigError("FATAL:", err)
 	}
 
 	// Unset the environment variables which contain secrets.
 	log.Println("Checking the environment for variables which might contain secrets, and unsetting them.")
-	flag.VisitAll(func(f *flag.Flag) {
-		if strings.Contains(f.Name, "pass") || strings.Contains(f.Name, "key") {
+	fs.VisitAll(func(f *flag.Flag) {
+		if isSecretSetting(f.Name) {
 			key := fmt.Sprintf("%v%v", EnvPrefix, strings.ToUpper(f.Name))
 			_, set := os.LookupEnv(key)
 			if set {
@@ -91,130 +132,185 @@ func main() {
 			}
 		}
 	})
+}
 
-	// Exit if any required flags are not set.
-	if *domain == "" {
-		log.Fatal("FATAL: An Alma API Server domain is required. https://developers.exlibrisgroup.com/alma/apis/#calling")
-	}
-	if *key == "" {
-		log.Fatal("FATAL: An Alma API Key is required. https://developers.exlibrisgroup.com/alma/apis/#defining")
-	}
-	if *jobPath == "" {
-		log.Fatal("FATAL: A URL is required. https://developers.exlibrisgroup.com/blog/Working-with-the-Alma-Jobs-API/")
-	}
-	if *params == "" {
-		log.Fatal("FATAL: An XML file of the job's parameters is required. https://developers.exlibrisgroup.com/blog/Working-with-the-Alma-Jobs-API/")
-	}
-	if *sendEmail {
-		if *smtpServer == "" {
-			log.Fatal("FATAL: A SMTP server is required if the email option is being used.")
-		}
-		if *mailTo == "" {
-			log.Fatal("FATAL: At least one email address to send reports to must be provided if using the email option.")
-		}
-		if *mailFrom == "" {
-			log.Fatal("FATAL: The email address reports are sent from must be provided if using the email option.")
-		}
-		if *smtpAuthMethod != "" {
-			if *smtpAuthMethod != "crammd5" && *smtpAuthMethod != "plain" {
-				log.Fatal("FATAL: 'crammd5' and 'plain' are the only supported auth methods for connecting to the SMTP server.")
-			}
+// isSecretSetting reports whether the setting might contain a secret, like a
+// password, key or token. Notification headers often carry credentials, and
+// Slack and Teams webhook URLs are credentials themselves.
+func isSecretSetting(name string) bool {
+	for _, part := range []string{"pass", "key", "secret", "token"} {
+		if strings.Contains(name, part) {
+			return true
 		}
 	}
+	switch name {
+	case "notifyheaders", "slackurls", "teamsurls":
+		return true
+	default:
+		return false
+	}
+}
 
-	// Create a buffer to store the email message.
-	// The email report is a copy of the log messages.
-	emailMessage := new(bytes.Buffer)
+// runCommand submits a job and monitors it until it completes.
+func runCommand(args []string) {
+	// Define the command line flags.
+	rc := &RunConfig{}
+	fs := newFlagSet("run", "Run a manual job in Alma using the Jobs API.")
+	rc.RegisterFlags(fs)
+	configPath, jobName := registerConfigFlags(fs)
+	parseFlags(fs, args)
+	configureRun(fs, rc, *configPath, *jobName)
+
+	// Cancel any in-progress requests when the process is interrupted.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	instance, err := rc.Run(ctx, os.Stderr, "")
+	code := ExitCode(instance, err, rc.FailOnWarning)
+	if code != ExitSuccess {
+		stop()
+		os.Exit(code)
+	}
+}
 
-	// Split log output to both stderr and the email message.
-	log.SetOutput(io.MultiWriter(os.Stderr, emailMessage))
+// registerConfigFlags defines the flags which select a configuration file and its job section.
+func registerConfigFlags(fs *flag.FlagSet) (configPath, jobName *string) {
+	configPath = fs.String("config", "", "A configuration file storing default and per-job settings.")
+	jobName = fs.String("job", "", "The job section of the configuration file to use.")
+	return configPath, jobName
+}
 
-	// Add the arguments to the output for later debugging.
-	log.Println(*name)
-	log.Println("Using alma-api-job-runner version", version)
-	log.Println("Alma API server domain (domain):", *domain)
-	log.Println("Job URL (url):", *jobPath)
-	log.Println("Parameters file (params):", *params)
-	log.Println("Sending email (email):", *sendEmail)
+// configureRun sets any unset flags from the configuration file, if one is used,
+// then exits if any required flags are not set.
+func configureRun(fs *flag.FlagSet, rc *RunConfig, configPath, jobName string) {
+	configureCommand(fs, configPath, jobName, rc.Validate)
+	rc.job = jobName
+}
 
-	// A closure to optionally send the report email and exit
-	// with a non-zero error code.
-	optionalEmailAndQuit := func() {
-		if *sendEmail {
-			err := SendEmail(*name+" -- error", emailMessage, *smtpServer, *smtpPort, *mailTo, *mailFrom, *smtpUsername, *smtpPassword, *smtpAuthMethod)
-			if err != nil {
-				log.Println(err)
-			}
+// configureCommand sets any unset flags from the configuration file, if one is used,
+// then exits if validate returns an error.
+func configureCommand(fs *flag.FlagSet, configPath, jobName string, validate func() error) {
+	// If any flags are still unset, see if the configuration
+	// file's job section or defaults set them.
+	if configPath != "" {
+		err := applyConfigFile(fs, configPath, jobName)
+		if err != nil {
+			exitConfigError(err)
 		}
-		os.Exit(1)
+	} else if jobName != "" {
+		exitConfigError("FATAL: A configuration file is required if the job option is being used.")
 	}
 
-	// Build the request to the Alma API.
-	jobURL, err := url.Parse(fmt.Sprintf("https://%v%v", *domain, *jobPath))
+	// Exit if any required flags are not set.
+	err := validate()
 	if err != nil {
-		log.Println("Error building final url from arguments: ", err)
-		optionalEmailAndQuit()
+		exitConfigError("FATAL:", err)
 	}
-	log.Println("Going to submit parameters to:", jobURL)
 
-	// Load the parameters XML file.
-	// This is done to check that the XML is well formed and valid.
-	loadedParams, err := LoadParameters(*params)
-	if err != nil {
-		log.Println("Error loading parameters: ", err)
-		optionalEmailAndQuit()
+	if configPath != "" {
+		log.Println("Configuration file (config):", configPath)
+		log.Println("Configuration job section (job):", jobName)
 	}
+}
 
-	// Log the parameters.
-	log.Println("Parameters:")
-	for _, param := range loadedParams.Parameters {
-		log.Printf(" %v: %v\n", param.Name.Value, param.Value)
-	}
+// exitConfigError logs the error in the command's settings and exits with ExitConfigError.
+func exitConfigError(v ...interface{}) {
+	log.Println(v...)
+	os.Exit(ExitConfigError)
+}
 
-	// Retry for max retries.
-	jobInstanceLink, err := RetrySubmitJob(*maxRetries, jobURL, *timeout, *key, loadedParams)
+// applyConfigFile loads the configuration file and sets the unset flags
+// in fs from the job's section and the defaults.
+func applyConfigFile(fs *flag.FlagSet, configPath, jobName string) error {
+	config, err := LoadConfig(configPath)
 	if err != nil {
-		log.Println("Error when submitting job: ", err)
-		optionalEmailAndQuit()
+		return fmt.Errorf("error loading configuration file: %w", err)
 	}
-	log.Println("Successful job submission.")
+	return configureFlagSet(fs, config, jobName)
+}
 
-	instanceURL, err := url.Parse(jobInstanceLink)
+// configureFlagSet sets the unset flags in fs from the job's section and the
+// defaults of a loaded configuration file. Settings which belong to other
+// commands are ignored.
+// If the name flag is still unset afterwards, it is set to the job's name.
+func configureFlagSet(fs *flag.FlagSet, config *Config, jobName string) error {
+	settings, err := config.JobSettings(jobName)
 	if err != nil {
-		log.Printf("Error parsing instance url (%v) from job additional info: %v\n", jobInstanceLink, err)
-		optionalEmailAndQuit()
+		return err
 	}
-	log.Println("Going to monitor job at: ", instanceURL)
-
-	instance, err := MonitorJobInstance(instanceURL, *timeout, *key)
+	for key := range settings {
+		if fs.Lookup(key) == nil && isCommandSetting(key) {
+			delete(settings, key)
+		}
+	}
+	err = ApplyConfig(fs, settings)
 	if err != nil {
-		log.Println("Error monitoring job instance: ", err)
-		optionalEmailAndQuit()
+		return err
 	}
+	if jobName != "" && !isFlagSet(fs, "name") {
+		return fs.Set("name", jobName)
+	}
+	return nil
+}
 
-	// Print the XML output of the final job instance to stdout.
-	marshaledInstance, err := xml.MarshalIndent(instance, "", "  ")
-	if err == nil {
-		fmt.Println(string(marshaledInstance))
-		_, err := emailMessage.Write(marshaledInstance)
-		if err != nil {
-			log.Println("Error writing XML representation to email message: ", err)
+// isCommandSetting reports whether key is a setting used by only some commands,
+// which the other commands ignore.
+func isCommandSetting(key string) bool {
+	if isScheduleSetting(key) {
+		return true
+	}
+	for _, settings := range []interface{ RegisterFlags(*flag.FlagSet) }{
+		&listenSettings{},
+		&listJobsSettings{},
+		&initParamsSettings{},
+		&historySettings{},
+	} {
+		fs := flag.NewFlagSet("settings", flag.ContinueOnError)
+		settings.RegisterFlags(fs)
+		if fs.Lookup(key) != nil {
+			return true
 		}
 	}
+	return false
+}
 
-	if *sendEmail {
-		subject := fmt.Sprintf("%v -- %v", *name, instance.Status.Desc)
-		err := SendEmail(subject, emailMessage, *smtpServer, *smtpPort, *mailTo, *mailFrom, *smtpUsername, *smtpPassword, *smtpAuthMethod)
-		if err != nil {
-			log.Println(err)
-		} else {
-			log.Println("Email sent successfully")
+// isFlagSet reports whether the named flag in fs has been set.
+func isFlagSet(fs *flag.FlagSet, name string) bool {
+	set := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
 		}
+	})
+	return set
+}
+
+// jobRunConfig builds the run configuration for a job in the configuration file.
+// The flags which were set in parent, on the command line or from the environment,
+// take precedence over the job's section and the defaults.
+func jobRunConfig(parent *flag.FlagSet, config *Config, jobName string) (*RunConfig, error) {
+	rc := &RunConfig{}
+	fs := flag.NewFlagSet(jobName, flag.ContinueOnError)
+	rc.RegisterFlags(fs)
+	var err error
+	parent.Visit(func(f *flag.Flag) {
+		if fs.Lookup(f.Name) != nil && err == nil {
+			err = fs.Set(f.Name, f.Value.String())
+		}
+	})
+	if err != nil {
+		return nil, err
 	}
+	err = configureFlagSet(fs, config, jobName)
+	if err != nil {
+		return nil, err
+	}
+	rc.job = jobName
+	return rc, nil
 }
 
 // LoadParameters reads and unmarshals the contents of the params file.
-func LoadParameters(params string) (loadedParams AlmaJob, err error) {
+func LoadParameters(params string) (loadedParams alma.AlmaJob, err error) {
 	// Get the absolute path of params, not strictly necessary
 	// but it makes error messages more clear.
 	paramsFilePath, err := filepath.Abs(params)
@@ -237,190 +333,6 @@ func LoadParameters(params string) (loadedParams AlmaJob, err error) {
 	return loadedParams, nil
 }
 
-// RetrySubmitJob retries SubmitJob max retries times.
-func RetrySubmitJob(maxRetries int, url *url.URL, timeout int, key string, params AlmaJob) (jobInstanceLink string, err error) {
-	for retry := 0; retry < maxRetries; retry++ {
-		// Submit the Job, get the job instance ID back.
-		jobInstanceLink, err := SubmitJob(url, timeout, key, params)
-		if err != nil {
-			// We encountered some error, retry with backoff.
-			log.Println("Failed to submit job: ", err)
-			sleepSeconds := (retry + 1) * (retry + 1)
-			sleepDur := time.Duration(sleepSeconds) * time.Second
-			log.Printf("Retrying in %v (%v/%v)\n", sleepDur, retry+1, maxRetries)
-			time.Sleep(sleepDur)
-			continue
-		}
-		return jobInstanceLink, nil
-	}
-	return "", fmt.Errorf("%w: maximum number of retries reached", ErrAPIError)
-}
-
-// SubmitJob sends a POST HTTP request to the Alma API to execute the job.
-func SubmitJob(url *url.URL, timeout int, key string, params AlmaJob) (jobInstanceLink string, err error) {
-	// Setup the job parameter data as a io.Reader.
-	marshaledParams := new(bytes.Buffer)
-	encoder := xml.NewEncoder(marshaledParams)
-	err = encoder.Encode(params)
-	if err != nil {
-		return "", err
-	}
-
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
-	defer cancel()
-
-	// Setup the request.
-	request, err := http.NewRequestWithContext(ctx, "POST", url.String(), marshaledParams)
-	if err != nil {
-		return "", err
-	}
-	request.Header.Add("Authorization", "apikey "+key)
-	request.Header.Add("Content-Type", "application/xml")
-
-	// Do the request.
-	resp, err := http.DefaultClient.Do(request)
-	if err != nil {
-		return "", err
-	}
-
-	// Log the remaning number of API calls.
-	remainingCalls := resp.Header.Get("X-Exl-Api-Remaining")
-	if remainingCalls != "" {
-		log.Printf("%v Alma API calls remaining.\n", remainingCalls)
-	}
-
-	// If the response was a 400 error, we can (usually) parse the returned XML.
-	if resp.StatusCode == 400 {
-		apiErr := &APIError{}
-		decoder := xml.NewDecoder(resp.Body)
-		err := decoder.Decode(apiErr)
-		// If the decode failed, still drain and close the response body.
-		_, _ = io.Copy(io.Discard, resp.Body)
-		resp.Body.Close()
-		if err != nil {
-			return "", fmt.Errorf("alma API request failed, HTTP status %v, couldn't read body: %w", resp.Status, err)
-		}
-		return "", fmt.Errorf("alma API request failed, HTTP status %v, %w", resp.Status, apiErr.Collapse())
-	}
-
-	// If the Status != OK, there was an error we didn't catch yet.
-	if resp.StatusCode != 200 {
-		bodyBytes, err := io.ReadAll(resp.Body)
-		resp.Body.Close()
-		if err != nil {
-			return "", fmt.Errorf("alma API request failed: %v couldn't read body: %w", resp.Status, err)
-		}
-		return "", fmt.Errorf("alma API request failed: %v - %v: %w", resp.Status, string(bodyBytes), ErrAPIError)
-	}
-
-	// Decode the job and return the job instance ID.
-	returnedJob := &AlmaJob{}
-	decoder := xml.NewDecoder(resp.Body)
-	err = decoder.Decode(returnedJob)
-	_, _ = io.Copy(io.Discard, resp.Body)
-	resp.Body.Close()
-	if err != nil {
-		return "", err
-	}
-	return returnedJob.AdditionalInfo.Link, nil
-}
-
-// MonitorJobInstance will request the job instance until the job is complete or
-// approximately 23 hours passes.
-func MonitorJobInstance(url *url.URL, timeout int, key string) (instance *AlmaJobInstance, err error) {
-	for i := 1; i < 2761; i++ {
-		instance, err := GetJobInstance(url, timeout, key)
-		if err != nil {
-			return instance, err
-		}
-		log.Println("Job Status: ", instance.Status.Desc)
-		if instance.EndTime != "" && instance.Status.Value != "FINALIZING" {
-			return instance, nil
-		}
-		time.Sleep(30 * time.Second)
-	}
-	return instance, fmt.Errorf("%w: job monitor has been running for 23 hours, exiting", ErrAPIError)
-}
-
-// GetJobInstance sends a GET HTTP request to the Alma API to get job instance data.
-func GetJobInstance(url *url.URL, timeout int, key string) (instance *AlmaJobInstance, err error) {
-	instance = &AlmaJobInstance{}
-
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
-	defer cancel()
-
-	// Setup the request.
-	request, err := http.NewRequestWithContext(ctx, "GET", url.String(), nil)
-	if err != nil {
-		return instance, err
-	}
-	request.Header.Add("Authorization", "apikey "+key)
-
-	// Do the request.
-	resp, err := http.DefaultClient.Do(request)
-	if err != nil {
-		return instance, err
-	}
-
-	// Log the remaning number of API calls.
-	remainingCalls := resp.Header.Get("X-Exl-Api-Remaining")
-	if remainingCalls != "" {
-		log.Printf("%v Alma API calls remaining.\n", remainingCalls)
-	}
-
-	// If the response was a 400 error, we can (usually) parse the returned XML.
-	if resp.StatusCode == 400 {
-		apiErr := &APIError{}
-		decoder := xml.NewDecoder(resp.Body)
-		err := decoder.Decode(apiErr)
-		// If the decode failed, still drain and close the response body.
-		_, _ = io.Copy(io.Discard, resp.Body)
-		resp.Body.Close()
-		if err != nil {
-			return instance, fmt.Errorf("alma API request failed, HTTP status %v, couldn't read body: %w", resp.Status, err)
-		}
-		return instance, fmt.Errorf("alma API request failed, HTTP status %v, %w", resp.Status, apiErr.Collapse())
-	}
-
-	// If the Status != OK, there was an error we didn't catch yet.
-	if resp.StatusCode != 200 {
-		bodyBytes, err := io.ReadAll(resp.Body)
-		resp.Body.Close()
-		if err != nil {
-			return instance, fmt.Errorf("alma API request failed: %v couldn't read body: %w", resp.Status, err)
-		}
-		return instance, fmt.Errorf("alma API request failed: %v - %v: %w", resp.Status, string(bodyBytes), ErrAPIError)
-	}
-
-	// Decode the job and return the job instance ID.
-	decoder := xml.NewDecoder(resp.Body)
-	err = decoder.Decode(instance)
-	_, _ = io.Copy(io.Discard, resp.Body)
-	resp.Body.Close()
-	if err != nil {
-		return instance, err
-	}
-	return instance, nil
-}
-
-// SendEmail sends an email using the provided configuration.
-func SendEmail(subject string, emailMessage *bytes.Buffer, smtpServer string, smtpPort int, mailTo, mailFrom, smtpUsername, smtpPassword, smtpAuthMethod string) error {
-	var auth smtp.Auth
-	if smtpAuthMethod == "crammd5" {
-		auth = smtp.CRAMMD5Auth(smtpUsername, smtpPassword)
-	} else if smtpAuthMethod == "plain" {
-		auth = smtp.PlainAuth("", smtpUsername, smtpPassword, smtpServer)
-	}
-	to := TrimSpaceAll(strings.Split(mailTo, ","))
-	finalMsg := new(bytes.Buffer)
-	finalMsg.WriteString(fmt.Sprintf("To: %v\r\n", strings.Join(to, ", ")))
-	finalMsg.WriteString(fmt.Sprintf("Subject: %v\r\n", subject))
-	finalMsg.WriteString("\r\n")
-	bodyMsg := bytes.ReplaceAll(emailMessage.Bytes(), []byte("\n"), []byte("\r\n"))
-	finalMsg.Write(bodyMsg)
-	return smtp.SendMail(smtpServer+":"+strconv.Itoa(smtpPort), auth, mailFrom, to, finalMsg.Bytes())
-}
-
 // TrimSpaceAll returns a version of trimMe where each element has been TrimSpace'd.
 func TrimSpaceAll(trimMe []string) []string {
 	var trimmed []string
@@ -6,32 +6,35 @@ package main
 
 import (
 	"encoding/xml"
+	"flag"
 	"os"
 	"reflect"
 	"testing"
+
+	"github.com/cu-library/alma-api-job-runner/alma"
 )
 
 func TestLoadParameters(t *testing.T) {
-	expectedJob := AlmaJob{
+	expectedJob := alma.AlmaJob{
 		XMLName: xml.Name{
 			Space: "",
 			Local: "job",
 		},
-		Parameters: []Parameter{
+		Parameters: []alma.Parameter{
 			{
-				Name: DescAndValue{
+				Name: alma.DescAndValue{
 					Value: "task_MmsTaggingParams_boolean",
 				},
 				Value: "NONE",
 			},
 			{
-				Name: DescAndValue{
+				Name: alma.DescAndValue{
 					Value: "set_id",
 				},
 				Value: "4000000000000",
 			},
 			{
-				Name: DescAndValue{
+				Name: alma.DescAndValue{
 					Value: "job_name",
 				},
 				Value: "A Job Name Here",
@@ -78,3 +81,36 @@ func TestLoadParameters(t *testing.T) {
 		t.Fatal("Expected job and job loaded through LoadParameters() are not equal.")
 	}
 }
+
+func TestParseFlagsUnsetsSecrets(t *testing.T) {
+	secrets := map[string]string{
+		"SMTPPASSWORD":          "password",
+		"NOTIFYHEADERS":         "Authorization: Bearer abc123",
+		"NOTIFYSECRET":          "signing-secret",
+		"KEY":                   "apikey",
+		"SLACKURLS":             "https://hooks.slack.com/services/T0/B0/abc",
+		"TEAMSURLS":             "https://example.webhook.office.com/abc",
+		"SMTPOAUTHREFRESHTOKEN": "refresh-token",
+	}
+	for name, value := range secrets {
+		t.Setenv(EnvPrefix+name, value)
+	}
+	t.Setenv(EnvPrefix+"NAME", "Nightly export")
+
+	rc := &RunConfig{}
+	fs := flag.NewFlagSet("run", flag.ContinueOnError)
+	rc.RegisterFlags(fs)
+	parseFlags(fs, nil)
+
+	if rc.NotifyHeaders != secrets["NOTIFYHEADERS"] || rc.SlackURLs != secrets["SLACKURLS"] || rc.SMTPOAuthRefreshToken != "refresh-token" || rc.Name != "Nightly export" {
+		t.Fatalf("Expected the settings to be read from the environment, got %+v.", rc)
+	}
+	for name := range secrets {
+		if _, set := os.LookupEnv(EnvPrefix + name); set {
+			t.Errorf("Expected %v to be unset.", EnvPrefix+name)
+		}
+	}
+	if _, set := os.LookupEnv(EnvPrefix + "NAME"); !set {
+		t.Errorf("Expected %v to be left set.", EnvPrefix+"NAME")
+	}
+}
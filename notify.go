@@ -0,0 +1,380 @@
+// Copyright 2024 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/cu-library/alma-api-job-runner/alma"
+)
+
+var (
+	// ErrInvalidNotification is returned when the notification settings are invalid,
+	// or the notification template doesn't produce JSON.
+	ErrInvalidNotification = errors.New("invalid notification")
+
+	// ErrNotificationRejected is returned when a notification URL responds with an error status.
+	ErrNotificationRejected = errors.New("the notification was rejected")
+
+	// ErrNotificationFailed is returned when a notification couldn't be delivered to every URL.
+	ErrNotificationFailed = errors.New("sending the notification failed")
+)
+
+// Notification events.
+const (
+	EventStart   = "start"
+	EventSuccess = "success"
+	EventWarning = "warning"
+	EventFailure = "failure"
+)
+
+const (
+	// SignatureHeader holds the hex encoded HMAC-SHA256 of the payload, prefixed with "sha256=".
+	SignatureHeader = "X-Alma-Job-Runner-Signature"
+
+	// EventHeader holds the notification's event.
+	EventHeader = "X-Alma-Job-Runner-Event"
+
+	// DefaultNotifyAttempts is the default number of times each notification is attempted.
+	DefaultNotifyAttempts = 3
+
+	// notifyTimeout is how long each attempt to deliver a notification can take.
+	notifyTimeout = 30 * time.Second
+
+	// maxNotifyResponseSize is the largest part of a failed response which is reported.
+	maxNotifyResponseSize = 512
+)
+
+// Notification is the data sent when a run starts or finishes. It is the payload's
+// template data, and the payload if no template is used.
+type Notification struct {
+	Event       string          `json:"event"`
+	Name        string          `json:"name"`
+	Version     string          `json:"runner_version"`
+	Domain      string          `json:"domain"`
+	JobPath     string          `json:"url,omitempty"`
+	InstanceURL string          `json:"instance_url,omitempty"`
+	Time        string          `json:"time"`
+	Error       string          `json:"error,omitempty"`
+	Instance    *InstanceOutput `json:"instance,omitempty"`
+}
+
+// Notifier POSTs JSON notifications to URLs.
+type Notifier struct {
+	// Name describes the notifier in log messages.
+	Name   string
+	URLs   []string
+	Events []string
+	// Builder, if set, builds the payload from the Notification.
+	Builder func(*Notification) ([]byte, error)
+	// Template, if set, builds the payload from the Notification instead.
+	Template *template.Template
+	Headers  http.Header
+	// Secret, if set, is the key of the HMAC-SHA256 signature sent in SignatureHeader.
+	Secret   string
+	Attempts int
+	// Backoff sets the delay between attempts.
+	Backoff    alma.RetryPolicy
+	HTTPClient *http.Client
+}
+
+// notifyEvent returns the event describing how a run ended, or an empty string if
+// the run was skipped. Job instances without a success or warning status are failures.
+func notifyEvent(output *InstanceOutput, err error) string {
+	switch {
+	case errors.Is(err, ErrJobSkipped):
+		return ""
+	case err != nil || output == nil:
+		return EventFailure
+	case output.Outcome == OutcomeSuccess:
+		return EventSuccess
+	case output.Outcome == OutcomeWarning:
+		return EventWarning
+	default:
+		return EventFailure
+	}
+}
+
+// ParseNotifyTemplate parses a notification template. The json function encodes a value as JSON,
+// so strings can be quoted safely, like {"text": {{json .Name}}}.
+func ParseNotifyTemplate(name, text string) (*template.Template, error) {
+	return template.New(name).Funcs(template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			encoded, err := json.Marshal(v)
+			return string(encoded), err
+		},
+	}).Parse(text)
+}
+
+// parseHeaders parses comma delimited "Name: value" headers.
+func parseHeaders(value string) (http.Header, error) {
+	headers := http.Header{}
+	for _, header := range splitList(value) {
+		colon := strings.Index(header, ":")
+		if colon < 1 {
+			return nil, fmt.Errorf("%w: the header %q isn't formatted as Name: value", ErrInvalidNotification, header)
+		}
+		headers.Add(strings.TrimSpace(header[:colon]), strings.TrimSpace(header[colon+1:]))
+	}
+	return headers, nil
+}
+
+// newBaseNotifier returns a notifier for the comma delimited URLs and events,
+// or nil if no URLs are set.
+func (rc *RunConfig) newBaseNotifier(name, urlList, eventList string) (*Notifier, error) {
+	urls := splitList(urlList)
+	if len(urls) == 0 {
+		return nil, nil
+	}
+	for _, notifyURL := range urls {
+		parsed, err := url.Parse(notifyURL)
+		if err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" {
+			return nil, fmt.Errorf("%w: %q isn't an http or https URL", ErrInvalidNotification, notifyURL)
+		}
+	}
+	events := splitList(eventList)
+	for _, event := range events {
+		switch event {
+		case EventStart, EventSuccess, EventWarning, EventFailure:
+		default:
+			return nil, fmt.Errorf("%w: unknown %v event %q, expected start, success, warning or failure", ErrInvalidNotification, name, event)
+		}
+	}
+	if rc.NotifyAttempts < 1 {
+		return nil, fmt.Errorf("%w: each notification must be attempted at least once", ErrInvalidNotification)
+	}
+	return &Notifier{
+		Name:       name,
+		URLs:       urls,
+		Events:     events,
+		Attempts:   rc.NotifyAttempts,
+		Backoff:    alma.DefaultRetryPolicy(),
+		HTTPClient: &http.Client{Timeout: notifyTimeout},
+	}, nil
+}
+
+// newNotifier returns the webhook notifier configured in rc, or nil if no notification URLs are set.
+func (rc *RunConfig) newNotifier() (*Notifier, error) {
+	notifier, err := rc.newBaseNotifier("webhook", rc.NotifyURLs, rc.NotifyEvents)
+	if notifier == nil || err != nil {
+		return nil, err
+	}
+	notifier.Headers, err = parseHeaders(rc.NotifyHeaders)
+	if err != nil {
+		return nil, err
+	}
+	notifier.Secret = rc.NotifySecret
+	if rc.NotifyTemplate != "" {
+		text, err := os.ReadFile(rc.NotifyTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidNotification, err)
+		}
+		notifier.Template, err = ParseNotifyTemplate(rc.NotifyTemplate, string(text))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidNotification, err)
+		}
+	}
+	return notifier, nil
+}
+
+// NewNotification returns the notification of the event. The instance URL is taken
+// from the final job instance's output if instanceURL is nil.
+func (rc *RunConfig) NewNotification(event string, instanceURL *url.URL, output *InstanceOutput, err error) *Notification {
+	notification := &Notification{
+		Event:    event,
+		Name:     rc.Name,
+		Version:  version,
+		Domain:   rc.Domain,
+		JobPath:  rc.JobPath,
+		Time:     time.Now().In(rc.displayLocation()).Format(time.RFC3339),
+		Instance: output,
+	}
+	if instanceURL != nil {
+		notification.InstanceURL = instanceURL.String()
+	} else if output != nil {
+		notification.InstanceURL = output.InstanceURL
+	}
+	if err != nil {
+		notification.Error = err.Error()
+	}
+	return notification
+}
+
+// notifiers returns the webhook, Slack and Teams notifiers configured in rc.
+func (rc *RunConfig) notifiers() ([]*Notifier, error) {
+	var notifiers []*Notifier
+	for _, newNotifier := range []func() (*Notifier, error){rc.newNotifier, rc.newSlackNotifier, rc.newTeamsNotifier} {
+		notifier, err := newNotifier()
+		if err != nil {
+			return nil, err
+		}
+		if notifier != nil {
+			notifiers = append(notifiers, notifier)
+		}
+	}
+	return notifiers, nil
+}
+
+// runNotifiers sends the notifications of one run, using the notifiers built when the run starts.
+type runNotifiers struct {
+	rc        *RunConfig
+	logger    *log.Logger
+	notifiers []*Notifier
+}
+
+// newRunNotifiers builds the notifiers of a run. If they can't be built, the error
+// is logged, and the run's notifications aren't sent.
+func (rc *RunConfig) newRunNotifiers(logger *log.Logger) *runNotifiers {
+	notifiers, err := rc.notifiers()
+	if err != nil {
+		logger.Println("Error: ", err)
+	}
+	return &runNotifiers{rc: rc, logger: logger, notifiers: notifiers}
+}
+
+// notify sends the notification of the event using each notifier which sends the event.
+// Errors are logged, and don't fail the run.
+func (n *runNotifiers) notify(event string, instanceURL *url.URL, output *InstanceOutput, err error) {
+	notification := n.rc.NewNotification(event, instanceURL, output, err)
+	for _, notifier := range n.notifiers {
+		if !containsString(notifier.Events, event) {
+			continue
+		}
+		// The run's context isn't used, so that a run which is interrupted still sends its failure
+		// notification. Sending stops once every attempt to every URL, and the waits between them,
+		// could have timed out.
+		ctx, cancel := context.WithTimeout(context.Background(), notifier.timeout())
+		notifyErr := notifier.Notify(ctx, notification)
+		cancel()
+		if notifyErr != nil {
+			n.logger.Printf("Error sending the %v notification: %v\n", notifier.Name, notifyErr)
+			continue
+		}
+		n.logger.Printf("Sent the %v %v notification.\n", event, notifier.Name)
+	}
+}
+
+// Payload returns the JSON payload of the notification.
+func (n *Notifier) Payload(notification *Notification) ([]byte, error) {
+	if n.Builder != nil {
+		return n.Builder(notification)
+	}
+	if n.Template == nil {
+		return json.Marshal(notification)
+	}
+	payload := new(bytes.Buffer)
+	err := n.Template.Execute(payload, notification)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidNotification, err)
+	}
+	if !json.Valid(payload.Bytes()) {
+		return nil, fmt.Errorf("%w: the template %v didn't produce valid JSON", ErrInvalidNotification, n.Template.Name())
+	}
+	return payload.Bytes(), nil
+}
+
+// Sign returns the value of the signature header of the payload.
+func Sign(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Notify POSTs the notification to each URL, attempting each up to Attempts times.
+// Network errors, 429 and 5xx responses are retried.
+func (n *Notifier) Notify(ctx context.Context, notification *Notification) error {
+	payload, err := n.Payload(notification)
+	if err != nil {
+		return err
+	}
+	var failures []string
+	for _, notifyURL := range n.URLs {
+		err := n.post(ctx, notifyURL, notification.Event, payload)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%v: %v", notifyURL, err))
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("%w: %v", ErrNotificationFailed, strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// timeout returns how long sending a notification to every URL can take,
+// including the backoff between the attempts to each URL.
+func (n *Notifier) timeout() time.Duration {
+	perURL := time.Duration(n.Attempts) * notifyTimeout
+	for attempt := 1; attempt < n.Attempts; attempt++ {
+		perURL += n.Backoff.Backoff(attempt - 1)
+	}
+	return time.Duration(len(n.URLs)) * perURL
+}
+
+// post sends the payload to the URL, retrying transient failures.
+func (n *Notifier) post(ctx context.Context, notifyURL, event string, payload []byte) error {
+	var err error
+	for attempt := 0; attempt < n.Attempts; attempt++ {
+		if attempt > 0 {
+			sleepErr := alma.Sleep(ctx, n.Backoff.Backoff(attempt-1))
+			if sleepErr != nil {
+				return sleepErr
+			}
+		}
+		var retry bool
+		retry, err = n.attempt(ctx, notifyURL, event, payload)
+		if err == nil || !retry {
+			return err
+		}
+	}
+	return err
+}
+
+// attempt sends the payload to the URL once. It reports whether a failure can be retried.
+func (n *Notifier) attempt(ctx context.Context, notifyURL, event string, payload []byte) (bool, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, notifyURL, bytes.NewReader(payload))
+	if err != nil {
+		return false, err
+	}
+	for name, values := range n.Headers {
+		request.Header[name] = values
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "alma-api-job-runner/"+version)
+	request.Header.Set(EventHeader, event)
+	if n.Secret != "" {
+		request.Header.Set(SignatureHeader, Sign(n.Secret, payload))
+	}
+	httpClient := n.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: notifyTimeout}
+	}
+	resp, err := httpClient.Do(request)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return false, nil
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxNotifyResponseSize))
+	err = fmt.Errorf("%w: %v %v", ErrNotificationRejected, resp.Status, strings.TrimSpace(string(body)))
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, err
+}
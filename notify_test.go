@@ -0,0 +1,196 @@
+// Copyright 2024 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cu-library/alma-api-job-runner/alma"
+)
+
+func TestNotifyEvent(t *testing.T) {
+	for _, test := range []struct {
+		output   *InstanceOutput
+		err      error
+		expected string
+	}{
+		{&InstanceOutput{Outcome: OutcomeSuccess}, nil, EventSuccess},
+		{&InstanceOutput{Outcome: OutcomeWarning}, nil, EventWarning},
+		{&InstanceOutput{Outcome: OutcomeUnknown}, nil, EventFailure},
+		{nil, &RunError{Stage: StageSubmit, Err: ErrAlreadyRunning}, EventFailure},
+		{nil, ErrJobSkipped, ""},
+	} {
+		if event := notifyEvent(test.output, test.err); event != test.expected {
+			t.Errorf("%+v, %v: expected %q, got %q.", test.output, test.err, test.expected, event)
+		}
+	}
+}
+
+func TestNotify(t *testing.T) {
+	var bodies []string
+	var headers []http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		headers = append(headers, r.Header)
+		// The first attempt fails with a transient error, and is retried.
+		if len(bodies) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer server.Close()
+
+	templatePath := filepath.Join(t.TempDir(), "ticket.tmpl")
+	err := os.WriteFile(templatePath, []byte(`{"title": {{json .Name}}, "event": "{{.Event}}"{{with .Instance}}, "status": {{json .Status}}, "ran": {{json .RunDuration}}{{range .Counters}}, {{json .Type}}: {{.Value}}{{end}}{{end}}}`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc := &RunConfig{
+		Name:           `Nightly "export"`,
+		NotifyURLs:     server.URL,
+		NotifyEvents:   "success,failure",
+		NotifyTemplate: templatePath,
+		NotifyHeaders:  "Authorization: Bearer abc123, X-Team: library",
+		NotifySecret:   "shared secret",
+		NotifyAttempts: 2,
+	}
+	notifier, err := rc.newNotifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+	notifier.Backoff = alma.RetryPolicy{BaseDelay: time.Millisecond, Multiplier: 1}
+	output := &InstanceOutput{Status: "COMPLETED_SUCCESS", RunDuration: "2m0s", Counters: []InstanceCounter{{Type: "label.processed", Value: "120"}}}
+	err = notifier.Notify(context.Background(), rc.NewNotification(EventSuccess, nil, output, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"title": "Nightly \"export\"", "event": "success", "status": "COMPLETED_SUCCESS", "ran": "2m0s", "label.processed": 120}`
+	if len(bodies) != 2 || bodies[1] != expected {
+		t.Fatalf("Expected two attempts with the payload %v, got %q.", expected, bodies)
+	}
+	for name, value := range map[string]string{
+		"Authorization": "Bearer abc123",
+		"X-Team":        "library",
+		"Content-Type":  "application/json",
+		EventHeader:     EventSuccess,
+		SignatureHeader: Sign("shared secret", []byte(expected)),
+	} {
+		if headers[1].Get(name) != value {
+			t.Errorf("%v: expected %q, got %q.", name, value, headers[1].Get(name))
+		}
+	}
+}
+
+func TestNotifyRejected(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		http.Error(w, "unknown project", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	notifier := &Notifier{URLs: []string{server.URL}, Attempts: 3}
+	err := notifier.Notify(context.Background(), &Notification{Event: EventFailure})
+	if !errors.Is(err, ErrNotificationFailed) || attempts != 1 {
+		t.Errorf("Expected one rejected attempt, got %v after %v attempts.", err, attempts)
+	}
+
+	notifier.Template, err = ParseNotifyTemplate("broken", `{"text": {{.Name}}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = notifier.Notify(context.Background(), &Notification{Name: "Nightly export"})
+	if !errors.Is(err, ErrInvalidNotification) {
+		t.Errorf("Expected ErrInvalidNotification, got %v.", err)
+	}
+}
+
+func TestNotifyBackoffTimeout(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	notifier := &Notifier{
+		URLs:     []string{server.URL, server.URL},
+		Attempts: 3,
+		Backoff:  alma.RetryPolicy{BaseDelay: time.Second, Multiplier: 2},
+	}
+	// Each URL is attempted three times, with waits of 1s and 2s between the attempts.
+	if expected := 2 * (3*notifyTimeout + 3*time.Second); notifier.timeout() != expected {
+		t.Errorf("Expected a timeout of %v, got %v.", expected, notifier.timeout())
+	}
+
+	// Waiting between attempts stops when the context is done.
+	notifier.Backoff.BaseDelay = time.Hour
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := notifier.Notify(ctx, &Notification{Event: EventFailure})
+	if !errors.Is(err, ErrNotificationFailed) || !strings.Contains(err.Error(), context.DeadlineExceeded.Error()) {
+		t.Errorf("Expected the notification to time out, got %v.", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("Expected the backoff to stop when the context is done, took %v.", elapsed)
+	}
+	if attempts != 1 {
+		t.Errorf("Expected only the first attempt to be sent before the timeout, got %v attempts.", attempts)
+	}
+}
+
+func TestRunNotifiersBuiltOnce(t *testing.T) {
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+	}))
+	defer server.Close()
+
+	templatePath := filepath.Join(t.TempDir(), "event.tmpl")
+	err := os.WriteFile(templatePath, []byte(`{"event": "{{.Event}}"}`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc := &RunConfig{NotifyURLs: server.URL, NotifyEvents: "start,success", NotifyTemplate: templatePath, NotifyAttempts: 1}
+	notifiers := rc.newRunNotifiers(log.New(io.Discard, "", 0))
+	// The template is read when the run starts, not for each event.
+	err = os.Remove(templatePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	notifiers.notify(EventStart, nil, nil, nil)
+	notifiers.notify(EventSuccess, nil, nil, nil)
+	expected := []string{`{"event": "start"}`, `{"event": "success"}`}
+	if fmt.Sprint(bodies) != fmt.Sprint(expected) {
+		t.Errorf("Expected %q, got %q.", expected, bodies)
+	}
+}
+
+func TestNewNotifierInvalid(t *testing.T) {
+	for _, rc := range []*RunConfig{
+		{NotifyURLs: "ftp://example.com/hook", NotifyAttempts: 1},
+		{NotifyURLs: "https://example.com/hook", NotifyEvents: "finished", NotifyAttempts: 1},
+		{NotifyURLs: "https://example.com/hook", NotifyHeaders: "Authorization", NotifyAttempts: 1},
+		{NotifyURLs: "https://example.com/hook", NotifyAttempts: 0},
+	} {
+		_, err := rc.newNotifier()
+		if !errors.Is(err, ErrInvalidNotification) {
+			t.Errorf("%+v: expected ErrInvalidNotification, got %v.", rc, err)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+// Copyright 2024 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+
+	"github.com/cu-library/alma-api-job-runner/alma"
+)
+
+// Output formats of the final job instance.
+const (
+	OutputXML  = "xml"
+	OutputJSON = "json"
+	OutputText = "text"
+)
+
+// Outcomes of a job instance, classifying its final status.
+const (
+	OutcomeSuccess = "success"
+	OutcomeWarning = "warning"
+	OutcomeFailed  = "failed"
+	OutcomeUnknown = "unknown"
+)
+
+// InstanceOutput is the JSON representation of a final job instance,
+// with the run's metadata and the durations derived from its times.
+type InstanceOutput struct {
+	Name          string            `json:"name"`
+	Version       string            `json:"runner_version"`
+	Domain        string            `json:"domain"`
+	JobPath       string            `json:"url,omitempty"`
+	InstanceURL   string            `json:"instance_url"`
+	ID            string            `json:"id"`
+	JobID         string            `json:"job_id,omitempty"`
+	JobName       string            `json:"job_name,omitempty"`
+	Status        string            `json:"status"`
+	StatusDesc    string            `json:"status_desc"`
+	Outcome       string            `json:"outcome"`
+	ExitCode      int               `json:"exit_code"`
+	SubmittedBy   string            `json:"submitted_by,omitempty"`
+	SubmitTime    string            `json:"submit_time,omitempty"`
+	StartTime     string            `json:"start_time,omitempty"`
+	EndTime       string            `json:"end_time,omitempty"`
+	QueueDuration string            `json:"queue_duration,omitempty"`
+	RunDuration   string            `json:"run_duration,omitempty"`
+	WallDuration  string            `json:"wall_duration,omitempty"`
+	Progress      float64           `json:"progress"`
+	Alerts        []string          `json:"alerts"`
+	Counters      []InstanceCounter `json:"counters"`
+
+	// instance is the job instance, used for the XML and text output.
+	instance *alma.AlmaJobInstance
+	// timing is the job instance's timing in the display time zone.
+	timing InstanceTiming
+}
+
+// Outcome classifies the job status as a success, a warning, or a failure.
+func Outcome(status alma.JobStatus) string {
+	switch {
+	case status.IsSuccess():
+		return OutcomeSuccess
+	case status.IsWarning():
+		return OutcomeWarning
+	case status.IsFailure():
+		return OutcomeFailed
+	default:
+		return OutcomeUnknown
+	}
+}
+
+// checkOutput returns an error if the output format isn't recognized.
+func checkOutput(output string) error {
+	switch output {
+	case OutputXML, OutputJSON, OutputText:
+		return nil
+	default:
+		return fmt.Errorf("%w: %q, expected xml, json or text", ErrInvalidFormat, output)
+	}
+}
+
+// NewInstanceOutput returns the output for the final job instance of the run.
+// If instanceURL is nil, the job instance's link is used.
+func (rc *RunConfig) NewInstanceOutput(instance *alma.AlmaJobInstance, instanceURL *url.URL) *InstanceOutput {
+	timing := NewInstanceTiming(instance, rc.displayLocation())
+	link := instance.Link
+	if instanceURL != nil {
+		link = instanceURL.String()
+	}
+	output := &InstanceOutput{
+		Name:          rc.Name,
+		Version:       version,
+		Domain:        rc.Domain,
+		JobPath:       rc.JobPath,
+		InstanceURL:   link,
+		ID:            instance.ID,
+		Outcome:       Outcome(instance.JobStatus()),
+		ExitCode:      ExitCode(instance, nil, rc.FailOnWarning),
+		SubmitTime:    formatTime(timing.Submit),
+		StartTime:     formatTime(timing.Start),
+		EndTime:       formatTime(timing.End),
+		QueueDuration: timing.Queue,
+		RunDuration:   timing.Run,
+		WallDuration:  timing.Wall,
+		Progress:      instance.Progress,
+		Alerts:        []string{},
+		Counters:      []InstanceCounter{},
+		instance:      instance,
+		timing:        timing,
+	}
+	if instance.JobInfo != nil {
+		output.JobID = instance.JobInfo.ID
+		output.JobName = instance.JobInfo.Name
+	}
+	if instance.Status != nil {
+		output.Status = instance.Status.Value
+		output.StatusDesc = instance.Status.Desc
+	}
+	if instance.SubmittedBy != nil {
+		output.SubmittedBy = instance.SubmittedBy.Value
+	}
+	for _, alert := range instance.Alerts {
+		text := alert.Desc
+		if text == "" {
+			text = alert.Value
+		}
+		output.Alerts = append(output.Alerts, text)
+	}
+	for _, counter := range instance.Counters {
+		output.Counters = append(output.Counters, InstanceCounter{Type: counter.Type.Value, Desc: counter.Type.Desc, Value: counter.Value})
+	}
+	return output
+}
+
+// WriteInstanceOutputs writes the final job instances to w in the output format.
+// In JSON, the job instances are always written as an array, even if there is only one.
+func WriteInstanceOutputs(w io.Writer, outputs []*InstanceOutput, format string) error {
+	err := checkOutput(format)
+	if err != nil {
+		return err
+	}
+	switch format {
+	case OutputXML:
+		for _, output := range outputs {
+			marshaledInstance, err := xml.MarshalIndent(output.instance, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(w, string(marshaledInstance))
+		}
+	case OutputJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if outputs == nil {
+			outputs = []*InstanceOutput{}
+		}
+		return encoder.Encode(outputs)
+	case OutputText:
+		for i, output := range outputs {
+			if i > 0 {
+				fmt.Fprintln(w)
+			}
+			writeInstanceText(w, output)
+		}
+	}
+	return nil
+}
+
+// writeInstanceText writes a short summary of the final job instance.
+func writeInstanceText(w io.Writer, output *InstanceOutput) {
+	fmt.Fprintf(w, "%v: %v (%v)\n", output.Name, output.StatusDesc, output.Outcome)
+	fmt.Fprintf(w, "Job instance: %v\n", output.InstanceURL)
+	for _, line := range output.timing.Lines() {
+		fmt.Fprintln(w, line)
+	}
+	for _, alert := range output.Alerts {
+		fmt.Fprintf(w, "Alert: %v\n", alert)
+	}
+	for _, counter := range output.Counters {
+		name := counter.Desc
+		if name == "" {
+			name = counter.Type
+		}
+		fmt.Fprintf(w, "%v: %v\n", name, counter.Value)
+	}
+}
+
+// writeOutput writes the final job instances to the output file, or stdout if it isn't set.
+// XML is written if the output format isn't set.
+func (rc *RunConfig) writeOutput(outputs []*InstanceOutput) error {
+	format := rc.Output
+	if format == "" {
+		format = OutputXML
+	}
+	if rc.OutputFile == "" {
+		return WriteInstanceOutputs(os.Stdout, outputs, format)
+	}
+	file, err := os.Create(rc.OutputFile)
+	if err != nil {
+		return err
+	}
+	err = WriteInstanceOutputs(file, outputs, format)
+	closeErr := file.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
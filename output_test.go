@@ -0,0 +1,95 @@
+// Copyright 2024 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cu-library/alma-api-job-runner/alma"
+)
+
+func TestInstanceOutput(t *testing.T) {
+	rc := &RunConfig{
+		Name:            "Weekly export",
+		Domain:          "api-ca.hosted.exlibrisgroup.com",
+		JobPath:         "/almaws/v1/conf/jobs/M47?op=run",
+		DisplayTimezone: "UTC",
+		FailOnWarning:   true,
+		Output:          OutputJSON,
+		OutputFile:      filepath.Join(t.TempDir(), "instance.json"),
+	}
+	instance := &alma.AlmaJobInstance{
+		ID:         "12345",
+		SubmitTime: "2024-01-08T10:00:00.000Z",
+		StartTime:  "2024-01-08T10:00:05.000Z",
+		EndTime:    "2024-01-08T10:02:05.000Z",
+		Status:     &alma.DescAndValue{Desc: "Completed with Warnings", Value: "COMPLETED_WARNING"},
+		Alerts:     []alma.DescAndValue{{Desc: "Some records were not exported", Value: "alert_general_warning"}},
+		Counters:   []alma.Counter{{Type: alma.DescAndValue{Desc: "Records exported", Value: "label.exported"}, Value: "118"}},
+	}
+	instanceURL, _ := url.Parse("https://api-ca.hosted.exlibrisgroup.com/almaws/v1/conf/jobs/M47/instances/12345")
+	output := rc.NewInstanceOutput(instance, instanceURL)
+
+	err := rc.writeOutput([]*InstanceOutput{output})
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents, err := os.ReadFile(rc.OutputFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A single job instance is written as an array too.
+	var instances []map[string]interface{}
+	err = json.Unmarshal(contents, &instances)
+	if err != nil || len(instances) != 1 {
+		t.Fatalf("Expected an array with one job instance, got %s, %v.", contents, err)
+	}
+	decoded := instances[0]
+	expected := map[string]interface{}{
+		"name":           "Weekly export",
+		"runner_version": version,
+		"domain":         "api-ca.hosted.exlibrisgroup.com",
+		"status":         "COMPLETED_WARNING",
+		"outcome":        OutcomeWarning,
+		"exit_code":      float64(ExitJobWarning),
+		"start_time":     "2024-01-08T10:00:05Z",
+		"queue_duration": "5s",
+		"run_duration":   "2m0s",
+		"wall_duration":  "2m5s",
+	}
+	for key, value := range expected {
+		if decoded[key] != value {
+			t.Errorf("Expected %v to be %v, got %v.", key, value, decoded[key])
+		}
+	}
+
+	text := new(bytes.Buffer)
+	err = WriteInstanceOutputs(text, []*InstanceOutput{output}, OutputText)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"Weekly export: Completed with Warnings (warning)",
+		"Execution time: 2m0s",
+		"Alert: Some records were not exported",
+		"Records exported: 118",
+	} {
+		if !strings.Contains(text.String(), line+"\n") {
+			t.Errorf("Expected %q in the text output:\n%v", line, text)
+		}
+	}
+
+	err = WriteInstanceOutputs(new(bytes.Buffer), []*InstanceOutput{output}, "yaml")
+	if !errors.Is(err, ErrInvalidFormat) {
+		t.Errorf("Expected ErrInvalidFormat, got %v.", err)
+	}
+}
@@ -0,0 +1,169 @@
+// Copyright 2024 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/cu-library/alma-api-job-runner/alma"
+)
+
+// resumeCommand resumes monitoring the runs whose process stopped before their
+// job instance finished, and sends the reports they owe.
+func resumeCommand(args []string) {
+	rc := &RunConfig{}
+	fs := newFlagSet("resume", "Resume monitoring runs which stopped before their job instance finished, and send their reports.")
+	rc.RegisterFlags(fs)
+	configPath, jobName := registerConfigFlags(fs)
+	parseFlags(fs, args)
+
+	// The job settings come from each run's state. The configuration
+	// file provides the settings which aren't stored, like the API key,
+	// from the job section the run was started with.
+	var config *Config
+	base := rc
+	if *configPath != "" {
+		var err error
+		config, err = LoadConfig(*configPath)
+		if err != nil {
+			exitConfigError("FATAL: Error loading configuration file:", err)
+		}
+		base, err = jobRunConfig(fs, config, *jobName)
+		if err != nil {
+			exitConfigError("FATAL:", err)
+		}
+		log.Println("Configuration file (config):", *configPath)
+		log.Println("Configuration job section (job):", *jobName)
+	} else if *jobName != "" {
+		exitConfigError("FATAL: A configuration file is required if the job option is being used.")
+	}
+	if base.StateDir == "" {
+		exitConfigError("FATAL: A state directory is required for the resume command.")
+	}
+
+	states, err := LoadRunStates(base.StateDir)
+	if err != nil {
+		log.Fatalln("FATAL: Error loading run states:", err)
+	}
+	if len(states) == 0 {
+		log.Println("There are no runs to resume in", base.StateDir)
+		return
+	}
+
+	// Cancel any in-progress requests when the process is interrupted.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	wg := new(sync.WaitGroup)
+	mu := new(sync.Mutex)
+	code := ExitSuccess
+	for _, state := range states {
+		if state.PID != os.Getpid() && processExists(state.PID) {
+			log.Printf("Run %v is still being monitored by PID %v.\n", state.ID, state.PID)
+			continue
+		}
+		state := state
+		resumed, err := resumeRunConfig(fs, base, config, *jobName, state)
+		if err != nil {
+			log.Printf("Run %v can't be resumed: %v\n", state.ID, err)
+			mu.Lock()
+			code = MaxExitCode(code, ExitConfigError)
+			mu.Unlock()
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			instance, err := resumed.Resume(ctx, state, os.Stderr, "["+state.Name+"] ")
+			mu.Lock()
+			code = MaxExitCode(code, ExitCode(instance, err, resumed.FailOnWarning))
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	if code != ExitSuccess {
+		stop()
+		os.Exit(code)
+	}
+}
+
+// resumeRunConfig returns the configuration used to resume the run with state.
+// The settings which aren't stored in the state come from the job section the run
+// was started with, or from jobName's section for runs which didn't record theirs.
+// The base configuration already uses jobName's section, and is used when there's
+// no configuration file. A jobName which doesn't match the run's job section is an error.
+func resumeRunConfig(parent *flag.FlagSet, base *RunConfig, config *Config, jobName string, state *RunState) (*RunConfig, error) {
+	if jobName != "" && state.Job != "" && state.Job != jobName {
+		return nil, fmt.Errorf("%w: the run was started using the %v job section, not %v", ErrInvalidSettings, state.Job, jobName)
+	}
+	resumed := *base
+	if config != nil && state.Job != "" && state.Job != jobName {
+		rc, err := jobRunConfig(parent, config, state.Job)
+		if err != nil {
+			return nil, err
+		}
+		resumed = *rc
+	}
+	state.Apply(&resumed)
+	return &resumed, nil
+}
+
+// Resume monitors the job instance of a run which was stopped, and sends the
+// run's optional email report. The state's file is removed once the report is sent.
+func (rc *RunConfig) Resume(ctx context.Context, state *RunState, logOutput io.Writer, prefix string) (*alma.AlmaJobInstance, error) {
+	emailMessage := new(bytes.Buffer)
+	logger := log.New(io.MultiWriter(logOutput, emailMessage), prefix, log.LstdFlags|log.Lmsgprefix)
+
+	logger.Println(rc.Name)
+	logger.Println("Using alma-api-job-runner version", version)
+	logger.Printf("Resuming run %v, which submitted the job at %v.\n", state.ID, state.SubmitTime)
+	logger.Println("Alma API server domain (domain):", rc.Domain)
+	logger.Println("Job URL (url):", rc.JobPath)
+
+	// Record that this process is monitoring the run now.
+	state.PID = os.Getpid()
+	err := SaveRunState(rc.StateDir, state)
+	if err != nil {
+		logger.Println("Error saving the run state: ", err)
+	}
+
+	// Settings errors don't remove the state, so the run
+	// can be resumed again once they are fixed.
+	err = rc.ValidateMonitor()
+	if err != nil {
+		logger.Println("Error: ", err)
+		return nil, err
+	}
+	client, err := rc.newClient(logger)
+	if err != nil {
+		logger.Println("Error creating Alma API client: ", err)
+		return nil, &RunError{Stage: StageConfig, Err: err}
+	}
+	notifiers := rc.newRunNotifiers(logger)
+	instanceURL, err := url.Parse(state.InstanceURL)
+	if err != nil {
+		logger.Printf("Error parsing instance url (%v): %v\n", state.InstanceURL, err)
+		rc.sendReport(logger, rc.Name+" -- error", emailMessage, nil)
+		rc.removeState(ctx, logger, state)
+		err = &RunError{Stage: StageMonitor, Err: err}
+		notifiers.notify(EventFailure, nil, nil, err)
+		return nil, err
+	}
+
+	instance, err := rc.finish(ctx, client, logger, emailMessage, instanceURL, "resumed", state, notifiers)
+	rc.removeState(ctx, logger, state)
+	return instance, err
+}
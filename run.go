@@ -0,0 +1,541 @@
+// Copyright 2024 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"time"
+
+	"github.com/cu-library/alma-api-job-runner/alma"
+)
+
+// ErrInvalidSettings is returned when the settings for a run are missing or invalid.
+var ErrInvalidSettings = errors.New("invalid settings")
+
+// RunStage is the stage of a run, used to tell why a run failed.
+type RunStage string
+
+const (
+	// StageConfig is loading the settings and parameters, before Alma is contacted.
+	StageConfig RunStage = "config"
+
+	// StageSubmit is everything up to and including submitting the job.
+	StageSubmit RunStage = "submit"
+
+	// StageMonitor is monitoring the submitted job instance.
+	StageMonitor RunStage = "monitor"
+)
+
+// RunError is returned when a run fails, and records the stage it failed in.
+type RunError struct {
+	Stage RunStage
+	Err   error
+}
+
+// Error returns the description of the error the run failed with.
+func (e *RunError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the error the run failed with.
+func (e *RunError) Unwrap() error {
+	return e.Err
+}
+
+// RunConfig stores the settings for submitting and monitoring one job.
+type RunConfig struct {
+	Name              string
+	Domain            string
+	Key               string
+	JobPath           string
+	Params            string
+	Timeout           int
+	Retry             alma.RetryPolicy
+	MaxPollFailures   int
+	PollFailureWindow time.Duration
+	SubmittedBy       string
+	DisplayTimezone   string
+	Output            string
+	OutputFile        string
+	Running           RunningPolicy
+	FailOnWarning     bool
+	ParamDrift        DriftPolicy
+	LockDir           string
+	LockWait          bool
+	StateDir          string
+	SendEmail         bool
+	SMTPServer        string
+	SMTPPort          int
+	SMTPUsername      string
+	SMTPPassword      string
+	SMTPAuthMethod    string
+	SMTPTLS           SMTPTLSMode
+	SMTPCAFile        string
+	SMTPServerName    string
+	SMTPClientCert    string
+	SMTPClientKey     string
+	MailTo            string
+	MailFrom          string
+	NotifyURLs        string
+	NotifyEvents      string
+	NotifyTemplate    string
+	NotifyHeaders     string
+	NotifySecret      string
+	NotifyAttempts    int
+	SlackURLs         string
+	SlackEvents       string
+	TeamsURLs         string
+	TeamsEvents       string
+
+	// The OAuth2 settings used to get access tokens for the xoauth2 SMTP auth method.
+	SMTPOAuthTokenURL     string
+	SMTPOAuthClientID     string
+	SMTPOAuthClientSecret string
+	SMTPOAuthRefreshToken string
+	SMTPOAuthScope        string
+	SMTPOAuthCacheDir     string
+
+	// Webhooks, if set, receives the job end webhook, which is waited on
+	// for up to WebhookDeadline before the job instance is polled instead.
+	Webhooks        *alma.WebhookListener
+	WebhookDeadline time.Duration
+
+	// httpClient, if set, is used for requests to the Alma API instead of http.DefaultClient.
+	httpClient *http.Client
+
+	// job is the configuration file's job section the settings came from, if any.
+	job string
+
+	// skipOutput is set when the final job instance is written by the caller instead, like the workflow command.
+	skipOutput bool
+}
+
+// RegisterFlags defines the flags which set the fields of rc in fs.
+func (rc *RunConfig) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&rc.Name, "name", "Alma API Job Runner", "The name for the job, used for logging and reports only.")
+	fs.StringVar(&rc.Domain, "domain", "", "The domain of the Alma API server URL to use. Required. (ex: api-ca.hosted.exlibrisgroup.com)")
+	fs.StringVar(&rc.Key, "key", "", "The Alma API key. Required.")
+	fs.StringVar(&rc.JobPath, "url", "", "The URL to which the job's parameters should be POST'd. Starts with a /. Required.")
+	fs.StringVar(&rc.Params, "params", "", "A file storing the XML representation of the job's parameters. Required.")
+	fs.IntVar(&rc.Timeout, "timeout", 10, "The number of seconds to wait on the Alma API when submitting requests.")
+	fs.IntVar(&rc.Retry.MaxRetries, "retries", alma.DefaultMaxRetries, "If calling the Alma API results in a transient error, how many times the request is attempted.")
+	fs.DurationVar(&rc.Retry.BaseDelay, "backoffbase", alma.DefaultBackoffBase, "The delay before the first retry.")
+	fs.Float64Var(&rc.Retry.Multiplier, "backoffmultiplier", alma.DefaultBackoffMultiplier, "The factor the delay is multiplied by after each retry.")
+	fs.DurationVar(&rc.Retry.MaxDelay, "backoffmax", alma.DefaultBackoffMax, "The maximum delay before a retry. 0 means no maximum.")
+	fs.DurationVar(&rc.Retry.MaxElapsed, "backoffmaxelapsed", alma.DefaultBackoffMaxElapsed, "Stop retrying a request once this much time has passed. 0 means no limit.")
+	fs.BoolVar(&rc.Retry.Jitter, "backoffjitter", true, "Wait a random delay between zero and the backoff delay before each retry.")
+	fs.StringVar(&rc.SubmittedBy, "submittedby", "", "The user Alma shows as submitting jobs with this API key. After an ambiguous submission failure, only instances submitted by this user are adopted. If empty, the run fails instead if the job was submitted since.")
+	fs.StringVar((*string)(&rc.Running), "running", string(RunningSubmit), "What to do when the job is already running in Alma: skip this run, wait for it to finish, fail, or submit anyway.")
+	fs.BoolVar(&rc.FailOnWarning, "failonwarning", true, "Exit with a non-zero status when the job completes with warnings.")
+	fs.StringVar((*string)(&rc.ParamDrift), "paramdrift", string(DriftOff), "Compare the parameters to the job's definition in Alma before submitting the job: off, warn about differences, or strict, which fails the run.")
+	fs.StringVar(&rc.LockDir, "lockdir", os.TempDir(), "The directory storing the lock files which prevent runs of the same job on this host from overlapping. Locking is disabled if empty.")
+	fs.BoolVar(&rc.LockWait, "lockwait", false, "If another run of the job holds the lock, wait for it to finish instead of skipping this run.")
+	fs.StringVar(&rc.StateDir, "statedir", defaultStateDir(), "The directory storing the state of runs in progress, which the resume command uses. Disabled if empty.")
+	fs.IntVar(&rc.MaxPollFailures, "maxpollfailures", alma.DefaultMaxPollFailures, "How many polls of the job instance in a row can fail before monitoring stops.")
+	fs.DurationVar(&rc.PollFailureWindow, "pollfailurewindow", alma.DefaultPollFailureWindow, "How long polls of the job instance can keep failing before monitoring stops. 0 means no limit.")
+	fs.StringVar(&rc.DisplayTimezone, "displaytimezone", "Local", "The time zone times are shown in, in reports and output. (ex: America/Toronto)")
+	fs.StringVar(&rc.Output, "output", OutputXML, "The format the final job instance is written in: xml, json, or a text summary.")
+	fs.StringVar(&rc.OutputFile, "outputfile", "", "The file the final job instance is written to, instead of stdout.")
+	fs.BoolVar(&rc.SendEmail, "email", false, "Send an email report.")
+	fs.StringVar(&rc.SMTPServer, "smtpserver", "", "The SMTP server to use for sending report emails.")
+	fs.IntVar(&rc.SMTPPort, "smtpport", DefaultSMTPPort, "The port to use when connecting to the SMTP server.")
+	fs.StringVar(&rc.SMTPUsername, "smtpusername", "", "The username to use when connecting to the SMTP server.")
+	fs.StringVar(&rc.SMTPPassword, "smtppassword", "", "The password/secret to use when connecting to the SMTP server.")
+	fs.StringVar(&rc.SMTPAuthMethod, "smtpauthmethod", "", "The Auth method used by the SMTP server: plain, crammd5, login or xoauth2. No authentication is used by default.")
+	fs.StringVar((*string)(&rc.SMTPTLS), "smtptls", string(SMTPTLSStartTLS), "How TLS is used when connecting to the SMTP server: none, starttls if the server supports it, starttls-required, or implicit, usually on port 465.")
+	fs.StringVar(&rc.SMTPCAFile, "smtpcafile", "", "A PEM file of the certificate authorities trusted to sign the SMTP server's certificate, instead of the system's.")
+	fs.StringVar(&rc.SMTPServerName, "smtpservername", "", "The name the SMTP server's certificate is verified against, if it isn't the SMTP server.")
+	fs.StringVar(&rc.SMTPClientCert, "smtpclientcert", "", "A PEM file of the client certificate presented to the SMTP server. Requires smtpclientkey.")
+	fs.StringVar(&rc.SMTPClientKey, "smtpclientkey", "", "A PEM file of the client certificate's private key.")
+	fs.StringVar(&rc.SMTPOAuthTokenURL, "smtpoauthtokenurl", "", "The OAuth2 token endpoint used to get access tokens for the xoauth2 auth method.")
+	fs.StringVar(&rc.SMTPOAuthClientID, "smtpoauthclientid", "", "The OAuth2 client ID used to get access tokens.")
+	fs.StringVar(&rc.SMTPOAuthClientSecret, "smtpoauthclientsecret", "", "The OAuth2 client secret used to get access tokens.")
+	fs.StringVar(&rc.SMTPOAuthRefreshToken, "smtpoauthrefreshtoken", "", "The OAuth2 refresh token used to get access tokens. The client credentials flow is used if empty.")
+	fs.StringVar(&rc.SMTPOAuthScope, "smtpoauthscope", "", "The scope requested with OAuth2 access tokens. (ex: https://outlook.office365.com/.default)")
+	fs.StringVar(&rc.SMTPOAuthCacheDir, "smtpoauthcachedir", defaultTokenCacheDir(), "The directory OAuth2 access tokens are cached in between runs. Caching is disabled if empty.")
+	fs.StringVar(&rc.MailTo, "mailto", "", "The email address to send reports to, comma delimited.")
+	fs.StringVar(&rc.MailFrom, "mailfrom", "", "The email address reports are send from.")
+	fs.StringVar(&rc.NotifyURLs, "notifyurls", "", "URLs a JSON notification is POST'd to when a run starts or finishes, comma delimited.")
+	fs.StringVar(&rc.NotifyEvents, "notifyevents", "start,success,warning,failure", "The events notifications are sent for, comma delimited.")
+	fs.StringVar(&rc.NotifyTemplate, "notifytemplate", "", "A file storing the Go template of the notification payload. The notification is sent as JSON if empty.")
+	fs.StringVar(&rc.NotifyHeaders, "notifyheaders", "", "Headers added to notifications, comma delimited. (ex: Authorization: Bearer abc123)")
+	fs.StringVar(&rc.NotifySecret, "notifysecret", "", "The key used to sign notifications with HMAC-SHA256 in the X-Alma-Job-Runner-Signature header.")
+	fs.IntVar(&rc.NotifyAttempts, "notifyattempts", DefaultNotifyAttempts, "How many times sending a notification is attempted.")
+	fs.StringVar(&rc.SlackURLs, "slackurls", "", "Slack incoming webhook URLs a message is sent to when a run starts or finishes, comma delimited.")
+	fs.StringVar(&rc.SlackEvents, "slackevents", DefaultChatEvents, "The events Slack messages are sent for, comma delimited.")
+	fs.StringVar(&rc.TeamsURLs, "teamsurls", "", "Microsoft Teams webhook URLs a message is sent to when a run starts or finishes, comma delimited.")
+	fs.StringVar(&rc.TeamsEvents, "teamsevents", DefaultChatEvents, "The events Teams messages are sent for, comma delimited.")
+}
+
+// Validate returns an error if any required settings are missing or invalid.
+func (rc *RunConfig) Validate() error {
+	if rc.JobPath == "" {
+		return fmt.Errorf("%w: a URL is required. https://developers.exlibrisgroup.com/blog/Working-with-the-Alma-Jobs-API/", ErrInvalidSettings)
+	}
+	if rc.Params == "" {
+		return fmt.Errorf("%w: an XML file of the job's parameters is required. https://developers.exlibrisgroup.com/blog/Working-with-the-Alma-Jobs-API/", ErrInvalidSettings)
+	}
+	if _, err := ParseRunningPolicy(string(rc.Running)); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSettings, err)
+	}
+	if _, err := ParseDriftPolicy(string(rc.ParamDrift)); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSettings, err)
+	}
+	return rc.ValidateMonitor()
+}
+
+// ValidateMonitor returns an error if any settings required to monitor
+// job instances and send the email report are missing or invalid.
+func (rc *RunConfig) ValidateMonitor() error {
+	if rc.Domain == "" {
+		return fmt.Errorf("%w: an Alma API Server domain is required. https://developers.exlibrisgroup.com/alma/apis/#calling", ErrInvalidSettings)
+	}
+	if rc.Key == "" {
+		return fmt.Errorf("%w: an Alma API Key is required. https://developers.exlibrisgroup.com/alma/apis/#defining", ErrInvalidSettings)
+	}
+	if rc.Retry.MaxRetries < 1 {
+		return fmt.Errorf("%w: the number of retries must be at least 1", ErrInvalidSettings)
+	}
+	if rc.MaxPollFailures < 1 || rc.PollFailureWindow < 0 {
+		return fmt.Errorf("%w: the maximum number of failed polls must be at least 1, and the poll failure window can't be negative", ErrInvalidSettings)
+	}
+	if rc.Retry.BaseDelay < 0 || rc.Retry.MaxDelay < 0 || rc.Retry.MaxElapsed < 0 || rc.Retry.Multiplier < 1 {
+		return fmt.Errorf("%w: the backoff delays can't be negative, and the backoff multiplier must be at least 1", ErrInvalidSettings)
+	}
+	if _, err := time.LoadLocation(rc.DisplayTimezone); err != nil {
+		return fmt.Errorf("%w: display time zone: %v", ErrInvalidSettings, err)
+	}
+	if err := checkOutput(rc.Output); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSettings, err)
+	}
+	if _, err := rc.notifiers(); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSettings, err)
+	}
+	if rc.SendEmail {
+		return rc.ValidateEmail()
+	}
+	return nil
+}
+
+// ValidateEmail returns an error if any settings required to send
+// the email report are missing or invalid.
+func (rc *RunConfig) ValidateEmail() error {
+	if rc.SMTPServer == "" {
+		return fmt.Errorf("%w: a SMTP server is required if the email option is being used", ErrInvalidSettings)
+	}
+	if rc.MailTo == "" {
+		return fmt.Errorf("%w: at least one email address to send reports to must be provided if using the email option", ErrInvalidSettings)
+	}
+	if rc.MailFrom == "" {
+		return fmt.Errorf("%w: the email address reports are sent from must be provided if using the email option", ErrInvalidSettings)
+	}
+	if err := checkSMTPAuthMethod(rc.SMTPAuthMethod); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSettings, err)
+	}
+	if rc.SMTPAuthMethod == SMTPAuthXOAuth2 {
+		if rc.SMTPUsername == "" || rc.SMTPOAuthClientID == "" {
+			return fmt.Errorf("%w: the SMTP username and OAuth2 client ID are required by the xoauth2 auth method", ErrInvalidSettings)
+		}
+		tokenURL, err := url.Parse(rc.SMTPOAuthTokenURL)
+		if err != nil || (tokenURL.Scheme != "https" && tokenURL.Scheme != "http") || tokenURL.Host == "" {
+			return fmt.Errorf("%w: the xoauth2 auth method requires an OAuth2 token endpoint URL", ErrInvalidSettings)
+		}
+	}
+	mode, err := ParseSMTPTLSMode(string(rc.SMTPTLS))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSettings, err)
+	}
+	if (rc.SMTPClientCert == "") != (rc.SMTPClientKey == "") {
+		return fmt.Errorf("%w: the SMTP client certificate and key must be provided together", ErrInvalidSettings)
+	}
+	if mode == SMTPTLSNone {
+		if rc.SMTPCAFile != "" || rc.SMTPServerName != "" || rc.SMTPClientCert != "" {
+			return fmt.Errorf("%w: the SMTP CA file, server name and client certificate can't be used when the SMTP TLS mode is none", ErrInvalidSettings)
+		}
+		return nil
+	}
+	if _, err := rc.smtpConfig().TLSConfig(); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSettings, err)
+	}
+	return nil
+}
+
+// Run submits the job, monitors the job instance until it completes, and
+// sends the optional email report. Log messages are written to logOutput,
+// with the provided prefix, and are copied into the email report.
+// If the run fails, the error report is sent before the error is returned.
+func (rc *RunConfig) Run(ctx context.Context, logOutput io.Writer, prefix string) (*alma.AlmaJobInstance, error) {
+	// Create a buffer to store the email message.
+	// The email report is a copy of the log messages.
+	emailMessage := new(bytes.Buffer)
+
+	// Split log output to both logOutput and the email message.
+	logger := log.New(io.MultiWriter(logOutput, emailMessage), prefix, log.LstdFlags|log.Lmsgprefix)
+	if rc.Webhooks != nil {
+		rc.Webhooks.SetLogger(logger)
+	}
+
+	// Add the arguments to the output for later debugging.
+	logger.Println(rc.Name)
+	logger.Println("Using alma-api-job-runner version", version)
+	logger.Println("Alma API server domain (domain):", rc.Domain)
+	logger.Println("Job URL (url):", rc.JobPath)
+	logger.Println("Parameters file (params):", rc.Params)
+	logger.Println("Sending email (email):", rc.SendEmail)
+
+	// Build the notifiers once, they are used for each of the run's events.
+	notifiers := rc.newRunNotifiers(logger)
+
+	// Closures to optionally send the report email when the job
+	// isn't run to completion, and return the error.
+	optionalEmailAndStop := func(outcome string, err error) (*alma.AlmaJobInstance, error) {
+		rc.sendReport(logger, rc.Name+" -- "+outcome, emailMessage, nil)
+		notifiers.notify(notifyEvent(nil, err), nil, nil, err)
+		return nil, err
+	}
+	optionalEmailAndFail := func(stage RunStage, err error) (*alma.AlmaJobInstance, error) {
+		return optionalEmailAndStop("error", &RunError{Stage: stage, Err: err})
+	}
+
+	// Create the Alma API client.
+	client, err := rc.newClient(logger)
+	if err != nil {
+		logger.Println("Error creating Alma API client: ", err)
+		return optionalEmailAndFail(StageConfig, err)
+	}
+
+	// Build the request to the Alma API.
+	jobURL, err := client.URL(rc.JobPath)
+	if err != nil {
+		logger.Println("Error building final url from arguments: ", err)
+		return optionalEmailAndFail(StageConfig, err)
+	}
+	logger.Println("Going to submit parameters to:", jobURL)
+
+	// Load the parameters XML file.
+	// This is done to check that the XML is well formed and valid.
+	loadedParams, err := LoadParameters(rc.Params)
+	if err != nil {
+		logger.Println("Error loading parameters: ", err)
+		return optionalEmailAndFail(StageConfig, err)
+	}
+
+	// Log the parameters.
+	logger.Println("Parameters:")
+	for _, param := range loadedParams.Parameters {
+		logger.Printf(" %v: %v\n", param.Name.Value, param.Value)
+	}
+
+	// Compare the parameters to the job's definition in Alma.
+	driftNote, err := rc.checkDrift(ctx, client, logger, loadedParams)
+	if err != nil {
+		logger.Println("Error: ", err)
+		if errors.Is(err, ErrParameterDrift) {
+			return optionalEmailAndStop("Failed, parameter drift", &RunError{Stage: StageConfig, Err: err})
+		}
+		return optionalEmailAndFail(StageSubmit, err)
+	}
+
+	// Take the lock for this job, so that runs of it on this host don't overlap.
+	if rc.LockDir != "" {
+		lock, err := AcquireLock(ctx, LockPath(rc.LockDir, rc.Domain, rc.JobPath), rc.LockWait, logger)
+		if errors.Is(err, ErrLocked) {
+			logger.Println(err)
+			return optionalEmailAndStop("Skipped, already running on this host", fmt.Errorf("%w: %v", ErrJobSkipped, err))
+		}
+		if err != nil {
+			logger.Println("Error taking the lock: ", err)
+			return optionalEmailAndFail(StageSubmit, err)
+		}
+		defer func() {
+			err := lock.Release()
+			if err != nil {
+				logger.Println("Error releasing the lock: ", err)
+			}
+		}()
+	}
+
+	// Check whether the job is already running in Alma.
+	note, err := rc.checkRunning(ctx, client, logger, jobURL)
+	switch {
+	case errors.Is(err, ErrJobSkipped):
+		logger.Println(err)
+		return optionalEmailAndStop("Skipped, already running", err)
+	case errors.Is(err, ErrAlreadyRunning):
+		logger.Println("Error: ", err)
+		return optionalEmailAndStop("Failed, already running", &RunError{Stage: StageSubmit, Err: err})
+	case err != nil:
+		logger.Println("Error: ", err)
+		return optionalEmailAndFail(StageSubmit, err)
+	}
+
+	// Retry for max retries.
+	submitTime := time.Now()
+	jobInstanceLink, err := client.RetrySubmitJob(ctx, jobURL, loadedParams)
+	if err != nil {
+		logger.Println("Error when submitting job: ", err)
+		return optionalEmailAndFail(StageSubmit, err)
+	}
+	logger.Println("Successful job submission.")
+
+	instanceURL, err := url.Parse(jobInstanceLink)
+	if err != nil {
+		logger.Printf("Error parsing instance url (%v) from job additional info: %v\n", jobInstanceLink, err)
+		return optionalEmailAndFail(StageSubmit, err)
+	}
+
+	notifiers.notify(EventStart, instanceURL, nil, nil)
+
+	// Save the run's state, so that the run can be resumed if this process stops.
+	var state *RunState
+	if rc.StateDir != "" {
+		state = NewRunState(rc, instanceURL, submitTime)
+		err = SaveRunState(rc.StateDir, state)
+		if err != nil {
+			logger.Println("Error saving the run state, this run can't be resumed: ", err)
+			state = nil
+		}
+	}
+
+	instance, err := rc.finish(ctx, client, logger, emailMessage, instanceURL, joinNotes(note, driftNote), state, notifiers)
+	rc.removeState(ctx, logger, state)
+	return instance, err
+}
+
+// removeState removes the saved state of a run once its report has been sent.
+// If the process is stopping, the state is kept so that the run can be resumed.
+func (rc *RunConfig) removeState(ctx context.Context, logger *log.Logger, state *RunState) {
+	if state == nil || ctx.Err() != nil {
+		return
+	}
+	err := state.Remove()
+	if err != nil {
+		logger.Println("Error removing the run state: ", err)
+	}
+}
+
+// newClient returns an Alma API client using the run's settings.
+func (rc *RunConfig) newClient(logger *log.Logger) (*alma.Client, error) {
+	options := []alma.Option{
+		alma.WithDomain(rc.Domain),
+		alma.WithKey(rc.Key),
+		alma.WithLogger(logger),
+		alma.WithTimeout(time.Duration(rc.Timeout) * time.Second),
+		alma.WithRetryPolicy(rc.Retry),
+		alma.WithMaxPollFailures(rc.MaxPollFailures),
+		alma.WithPollFailureWindow(rc.PollFailureWindow),
+		alma.WithSubmittedBy(rc.SubmittedBy),
+	}
+	if rc.httpClient != nil {
+		options = append(options, alma.WithHTTPClient(rc.httpClient))
+	}
+	return alma.NewClient(options...)
+}
+
+// finish monitors the submitted job instance until it is finished, prints the
+// final job instance, and sends the optional email report and notification.
+// The note, if any, is added to the report's subject. If the run has a saved
+// state and is interrupted, the report is left for the resume command to send.
+func (rc *RunConfig) finish(ctx context.Context, client *alma.Client, logger *log.Logger, emailMessage *bytes.Buffer, instanceURL *url.URL, note string, state *RunState, notifiers *runNotifiers) (*alma.AlmaJobInstance, error) {
+	logger.Println("Going to monitor job at: ", instanceURL)
+	instance, err := rc.monitor(ctx, client, logger, instanceURL)
+	if err != nil {
+		logger.Println("Error monitoring job instance: ", err)
+		if state != nil && ctx.Err() != nil {
+			logger.Println("The run's state is kept, the resume command will send its report.")
+			return nil, &RunError{Stage: StageMonitor, Err: err}
+		}
+		rc.sendReport(logger, rc.Name+" -- error", emailMessage, nil)
+		err = &RunError{Stage: StageMonitor, Err: err}
+		notifiers.notify(EventFailure, instanceURL, nil, err)
+		return nil, err
+	}
+	for _, line := range NewInstanceTiming(instance, rc.displayLocation()).Lines() {
+		logger.Println(line)
+	}
+
+	// Write the final job instance to stdout or the output file.
+	outputs := []*InstanceOutput{rc.NewInstanceOutput(instance, instanceURL)}
+	if !rc.skipOutput {
+		err = rc.writeOutput(outputs)
+		if err != nil {
+			logger.Println("Error writing the final job instance: ", err)
+		}
+	}
+
+	subject := fmt.Sprintf("%v -- %v", rc.Name, instance.StatusDesc())
+	if note != "" {
+		subject += " (" + note + ")"
+	}
+	rc.sendReport(logger, subject, emailMessage, outputs)
+	notifiers.notify(notifyEvent(outputs[0], nil), nil, outputs[0], nil)
+	return instance, nil
+}
+
+// sendReport sends the email report, if the run is configured to send one.
+// The log is the report's text, and the final job instances, if any, are attached.
+func (rc *RunConfig) sendReport(logger *log.Logger, subject string, emailMessage *bytes.Buffer, outputs []*InstanceOutput) {
+	if !rc.SendEmail {
+		return
+	}
+	report, err := rc.NewEmailReport(subject, emailMessage.String(), outputs)
+	if err != nil {
+		logger.Println("Error building the email report: ", err)
+		return
+	}
+	config := rc.smtpConfig()
+	logger.Println("Sending email using SMTP server:", config)
+	err = SendEmail(report, config, rc.MailTo, rc.MailFrom)
+	if err != nil {
+		logger.Println(err)
+	} else {
+		logger.Println("Email sent successfully")
+	}
+}
+
+// monitor waits until the job instance is finished. If a webhook listener is
+// used, the job end webhook is waited on until the deadline, then the final job
+// instance is requested. Otherwise, or if the webhook doesn't arrive in time,
+// the job instance is polled.
+func (rc *RunConfig) monitor(ctx context.Context, client *alma.Client, logger *log.Logger, instanceURL *url.URL) (*alma.AlmaJobInstance, error) {
+	if rc.Webhooks != nil {
+		instanceID := path.Base(instanceURL.Path)
+		logger.Printf("Waiting up to %v for the job end webhook for job instance %v.\n", rc.WebhookDeadline, instanceID)
+		waitCtx, cancel := context.WithTimeout(ctx, rc.WebhookDeadline)
+		event, err := rc.Webhooks.WaitForJobEnd(waitCtx, instanceID)
+		cancel()
+		switch {
+		case err == nil:
+			logger.Println("Job end webhook received, job status:", event.JobInstance.Status.Desc)
+			instance, err := client.GetJobInstance(ctx, instanceURL)
+			if err == nil && instance.Finished() {
+				return instance, nil
+			}
+			if err != nil {
+				logger.Println("Error getting the final job instance: ", err)
+			}
+			logger.Println("Falling back to polling the job instance.")
+		case ctx.Err() != nil:
+			return nil, ctx.Err()
+		default:
+			logger.Println("No job end webhook arrived before the deadline, falling back to polling the job instance.")
+		}
+	}
+	instance, stats, err := client.Monitor(ctx, instanceURL)
+	if stats.FailedPolls > 0 {
+		logger.Printf("%v of %v polls of the job instance failed.\n", stats.FailedPolls, stats.Polls)
+	}
+	return instance, err
+}
@@ -0,0 +1,109 @@
+// Copyright 2024 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+
+	"github.com/cu-library/alma-api-job-runner/alma"
+)
+
+var (
+	// ErrInvalidRunningPolicy is returned when a running policy isn't recognized.
+	ErrInvalidRunningPolicy = errors.New("invalid running policy")
+
+	// ErrJobSkipped is returned by Run when the job wasn't submitted
+	// because an instance of it is already running in Alma.
+	ErrJobSkipped = errors.New("job skipped")
+
+	// ErrAlreadyRunning is returned by Run when an instance of the job
+	// is already running in Alma and the running policy is to fail.
+	ErrAlreadyRunning = errors.New("the job is already running in Alma")
+)
+
+// RunningPolicy controls what happens when an instance of the job is
+// already queued or running in Alma when the job is about to be submitted.
+type RunningPolicy string
+
+const (
+	// RunningSkip doesn't submit the job.
+	RunningSkip RunningPolicy = "skip"
+
+	// RunningWait waits for the running instances to finish, then submits the job.
+	RunningWait RunningPolicy = "wait"
+
+	// RunningFail doesn't submit the job, and reports the run as failed.
+	RunningFail RunningPolicy = "fail"
+
+	// RunningSubmit submits the job without checking for running instances.
+	RunningSubmit RunningPolicy = "submit"
+)
+
+// ParseRunningPolicy returns the RunningPolicy named by s.
+func ParseRunningPolicy(s string) (RunningPolicy, error) {
+	switch policy := RunningPolicy(s); policy {
+	case RunningSkip, RunningWait, RunningFail, RunningSubmit:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("%w: %q, expected skip, wait, fail or submit", ErrInvalidRunningPolicy, s)
+	}
+}
+
+// checkRunning looks for instances of the job which are queued or running in
+// Alma, and applies the running policy. With the wait policy, it returns once no
+// instances are running, and the returned note describes the instances waited for.
+func (rc *RunConfig) checkRunning(ctx context.Context, client *alma.Client, logger *log.Logger, jobURL *url.URL) (note string, err error) {
+	if rc.Running == RunningSubmit {
+		return "", nil
+	}
+	var waitedFor []string
+	for {
+		running, err := client.ActiveJobInstances(ctx, jobURL)
+		if err != nil {
+			return "", fmt.Errorf("checking for running job instances: %w", err)
+		}
+		if len(running) == 0 {
+			break
+		}
+		for _, instance := range running {
+			submittedBy := ""
+			if instance.SubmittedBy != nil {
+				submittedBy = instance.SubmittedBy.Value
+			}
+			logger.Printf("Job instance %v is %v, submitted by %v at %v.\n", instance.ID, instance.StatusDesc(), submittedBy, instance.SubmitTime)
+		}
+		first := running[0]
+		switch rc.Running { //nolint:exhaustive // The submit policy returns before checking.
+		case RunningSkip:
+			logger.Println("Skipping this run, the job is already running.")
+			return "", fmt.Errorf("%w: job instance %v is %v", ErrJobSkipped, first.ID, first.StatusDesc())
+		case RunningFail:
+			return "", fmt.Errorf("%w: job instance %v is %v", ErrAlreadyRunning, first.ID, first.StatusDesc())
+		default:
+			// The wait policy waits for the running instances to finish.
+		}
+		for i := range running {
+			instanceURL, err := alma.InstanceURL(jobURL, &running[i])
+			if err != nil {
+				return "", err
+			}
+			logger.Printf("Waiting for job instance %v to finish before submitting the job.\n", running[i].ID)
+			_, err = client.MonitorJobInstance(ctx, instanceURL)
+			if err != nil {
+				return "", fmt.Errorf("waiting for job instance %v: %w", running[i].ID, err)
+			}
+			waitedFor = append(waitedFor, running[i].ID)
+		}
+	}
+	if len(waitedFor) == 0 {
+		return "", nil
+	}
+	return fmt.Sprintf("after waiting for job instance %v", strings.Join(waitedFor, ", ")), nil
+}
@@ -0,0 +1,91 @@
+// Copyright 2024 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cu-library/alma-api-job-runner/alma"
+)
+
+func TestParseRunningPolicy(t *testing.T) {
+	for _, s := range []string{"skip", "wait", "fail", "submit"} {
+		policy, err := ParseRunningPolicy(s)
+		if err != nil || string(policy) != s {
+			t.Errorf("Expected %q to parse, got %q, %v.", s, policy, err)
+		}
+	}
+	_, err := ParseRunningPolicy("queue")
+	if !errors.Is(err, ErrInvalidRunningPolicy) {
+		t.Errorf("Expected ErrInvalidRunningPolicy, got %v.", err)
+	}
+}
+
+func TestCheckRunning(t *testing.T) {
+	finished := false
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/instances") && !finished:
+			fmt.Fprint(w, `<job_instances total_record_count="1"><job_instance><id>7</id><status desc="Running">RUNNING</status></job_instance></job_instances>`)
+		case strings.HasSuffix(r.URL.Path, "/instances"):
+			fmt.Fprint(w, `<job_instances total_record_count="0"></job_instances>`)
+		case strings.HasSuffix(r.URL.Path, "/instances/7"):
+			finished = true
+			fmt.Fprint(w, `<job_instance><id>7</id><end_time>2015-04-15T13:07:44.359Z</end_time><status desc="Completed Successfully">COMPLETED_SUCCESS</status></job_instance>`)
+		default:
+			t.Errorf("Unexpected request %v.", r.URL)
+		}
+	}))
+	defer server.Close()
+
+	client, err := alma.NewClient(
+		alma.WithDomain(strings.TrimPrefix(server.URL, "https://")),
+		alma.WithKey("testkey"),
+		alma.WithHTTPClient(server.Client()),
+		alma.WithPollInterval(time.Millisecond),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobURL, err := client.URL("/almaws/v1/conf/jobs/M1?op=run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := log.New(io.Discard, "", 0)
+
+	tests := []struct {
+		policy RunningPolicy
+		err    error
+	}{
+		{RunningSkip, ErrJobSkipped},
+		{RunningFail, ErrAlreadyRunning},
+		{RunningSubmit, nil},
+	}
+	for _, test := range tests {
+		rc := &RunConfig{Running: test.policy}
+		_, err := rc.checkRunning(context.Background(), client, logger, jobURL)
+		if !errors.Is(err, test.err) {
+			t.Errorf("%v: expected %v, got %v.", test.policy, test.err, err)
+		}
+	}
+
+	rc := &RunConfig{Running: RunningWait}
+	note, err := rc.checkRunning(context.Background(), client, logger, jobURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if note != "after waiting for job instance 7" {
+		t.Fatalf("Unexpected note %q.", note)
+	}
+}
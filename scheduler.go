@@ -0,0 +1,154 @@
+// Copyright 2024 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/cu-library/alma-api-job-runner/alma"
+)
+
+// ErrInvalidOverlapPolicy is returned when an overlap policy isn't recognized.
+var ErrInvalidOverlapPolicy = errors.New("invalid overlap policy")
+
+// OverlapPolicy controls what happens when a job is scheduled to run
+// while a previous run of the same job is still in progress.
+type OverlapPolicy string
+
+const (
+	// OverlapSkip skips the new run.
+	OverlapSkip OverlapPolicy = "skip"
+
+	// OverlapQueue starts the new run when the previous run finishes.
+	// At most one run is queued, later runs are skipped.
+	OverlapQueue OverlapPolicy = "queue"
+
+	// OverlapAllow starts the new run alongside the previous run.
+	OverlapAllow OverlapPolicy = "allow"
+)
+
+// ParseOverlapPolicy returns the OverlapPolicy named by s.
+func ParseOverlapPolicy(s string) (OverlapPolicy, error) {
+	switch policy := OverlapPolicy(s); policy {
+	case OverlapSkip, OverlapQueue, OverlapAllow:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("%w: %q, expected skip, queue or allow", ErrInvalidOverlapPolicy, s)
+	}
+}
+
+// ScheduledJob is a job which the scheduler runs on a cron schedule.
+type ScheduledJob struct {
+	Name     string
+	Schedule *CronSchedule
+	// Jitter is the maximum random delay added to each scheduled time.
+	Jitter  time.Duration
+	Overlap OverlapPolicy
+	Run     func(ctx context.Context)
+}
+
+// schedulerClock tells the time and sleeps for the scheduler, so that tests can replace the wall clock.
+type schedulerClock struct {
+	now   func() time.Time
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+// RunScheduler runs each job on its schedule until the context is done,
+// then waits for any runs in progress to finish.
+func RunScheduler(ctx context.Context, logger *log.Logger, jobs []*ScheduledJob) {
+	runScheduler(ctx, logger, jobs, schedulerClock{now: time.Now, sleep: alma.Sleep})
+}
+
+// runScheduler is RunScheduler, using the given clock.
+func runScheduler(ctx context.Context, logger *log.Logger, jobs []*ScheduledJob, clock schedulerClock) {
+	runs := new(sync.WaitGroup)
+	loops := new(sync.WaitGroup)
+	jitter := alma.NewJitterSource()
+	for _, job := range jobs {
+		runner := &jobRunner{job: job, ctx: ctx, logger: logger, runs: runs, clock: clock}
+		loops.Add(1)
+		go func() {
+			defer loops.Done()
+			runner.loop(jitter)
+		}()
+	}
+	loops.Wait()
+	logger.Println("Scheduler stopping, waiting for runs in progress to finish.")
+	runs.Wait()
+}
+
+// jobRunner starts the runs of one scheduled job, applying its overlap policy.
+type jobRunner struct {
+	job    *ScheduledJob
+	ctx    context.Context
+	logger *log.Logger
+	runs   *sync.WaitGroup
+	clock  schedulerClock
+
+	mu      sync.Mutex
+	running int
+	queued  bool
+}
+
+// loop sleeps until each scheduled time, then dispatches a run.
+func (r *jobRunner) loop(jitter *alma.JitterSource) {
+	from := r.clock.now()
+	for {
+		next := r.job.Schedule.Next(from)
+		if next.IsZero() {
+			r.logger.Printf("%v: the schedule has no future run times.\n", r.job.Name)
+			return
+		}
+		delay := jitter.Duration(r.job.Jitter)
+		r.logger.Printf("%v: next run at %v.\n", r.job.Name, next.Add(delay).Format(time.RFC1123))
+		err := r.clock.sleep(r.ctx, next.Sub(r.clock.now())+delay)
+		if err != nil {
+			return
+		}
+		r.dispatch()
+		// Scheduled times missed while the host was suspended are skipped.
+		from = r.clock.now().Add(-delay)
+	}
+}
+
+// dispatch starts a run, or skips or queues it if a run is in progress.
+func (r *jobRunner) dispatch() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.running == 0 || r.job.Overlap == OverlapAllow {
+		r.start()
+		return
+	}
+	if r.job.Overlap == OverlapQueue && !r.queued {
+		r.logger.Printf("%v: the previous run is still in progress, queueing this run.\n", r.job.Name)
+		r.queued = true
+		return
+	}
+	r.logger.Printf("%v: the previous run is still in progress, skipping this run.\n", r.job.Name)
+}
+
+// start runs the job in a new goroutine. r.mu must be held.
+func (r *jobRunner) start() {
+	r.running++
+	r.runs.Add(1)
+	go func() {
+		defer r.runs.Done()
+		r.job.Run(r.ctx)
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.running--
+		if r.queued {
+			r.queued = false
+			if r.ctx.Err() == nil {
+				r.start()
+			}
+		}
+	}()
+}
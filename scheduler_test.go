@@ -0,0 +1,61 @@
+// Copyright 2024 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"sync"
+	"testing"
+)
+
+func TestJobRunnerOverlapPolicies(t *testing.T) {
+	tests := map[OverlapPolicy]int{
+		OverlapSkip:  1,
+		OverlapQueue: 2,
+		OverlapAllow: 3,
+	}
+	for policy, expectedRuns := range tests {
+		release := make(chan struct{})
+		mu := new(sync.Mutex)
+		runs := 0
+		runner := &jobRunner{
+			job: &ScheduledJob{
+				Name:    string(policy),
+				Overlap: policy,
+				Run: func(ctx context.Context) {
+					mu.Lock()
+					runs++
+					mu.Unlock()
+					<-release
+				},
+			},
+			ctx:    context.Background(),
+			logger: log.New(io.Discard, "", 0),
+			runs:   new(sync.WaitGroup),
+		}
+		// Three runs are dispatched while the first is still in progress.
+		runner.dispatch()
+		runner.dispatch()
+		runner.dispatch()
+		close(release)
+		runner.runs.Wait()
+		if runs != expectedRuns {
+			t.Errorf("%v: expected %v runs, got %v.", policy, expectedRuns, runs)
+		}
+	}
+}
+
+func TestParseOverlapPolicy(t *testing.T) {
+	policy, err := ParseOverlapPolicy("queue")
+	if err != nil || policy != OverlapQueue {
+		t.Fatalf("Expected queue, got %v, %v.", policy, err)
+	}
+	_, err = ParseOverlapPolicy("sometimes")
+	if err == nil {
+		t.Fatal("Expected an error for an unknown overlap policy.")
+	}
+}
@@ -0,0 +1,277 @@
+// Copyright 2024 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrInvalidSMTPTLSMode is returned when a SMTP TLS mode isn't recognized.
+	ErrInvalidSMTPTLSMode = errors.New("invalid SMTP TLS mode")
+
+	// ErrSTARTTLSUnsupported is returned when STARTTLS is required but the SMTP server doesn't offer it.
+	ErrSTARTTLSUnsupported = errors.New("the SMTP server doesn't support STARTTLS")
+
+	// ErrSMTPAuthUnsupported is returned when an auth method is set but the SMTP server doesn't offer AUTH.
+	ErrSMTPAuthUnsupported = errors.New("the SMTP server doesn't support AUTH")
+
+	// ErrInvalidCAFile is returned when the SMTP CA file doesn't contain any PEM encoded certificates.
+	ErrInvalidCAFile = errors.New("no certificates found in the CA file")
+)
+
+// smtpTimeout is how long connecting to the SMTP server and sending the email report can take.
+const smtpTimeout = time.Minute
+
+// SMTPTLSMode controls how TLS is used when connecting to the SMTP server.
+type SMTPTLSMode string
+
+const (
+	// SMTPTLSNone never uses TLS.
+	SMTPTLSNone SMTPTLSMode = "none"
+
+	// SMTPTLSStartTLS upgrades the connection using STARTTLS if the server supports it.
+	SMTPTLSStartTLS SMTPTLSMode = "starttls"
+
+	// SMTPTLSStartTLSRequired upgrades the connection using STARTTLS, and
+	// doesn't send the email report if the server doesn't support it.
+	SMTPTLSStartTLSRequired SMTPTLSMode = "starttls-required"
+
+	// SMTPTLSImplicit connects using TLS, usually on port 465.
+	SMTPTLSImplicit SMTPTLSMode = "implicit"
+)
+
+// ParseSMTPTLSMode returns the SMTPTLSMode named by s.
+func ParseSMTPTLSMode(s string) (SMTPTLSMode, error) {
+	switch mode := SMTPTLSMode(s); mode {
+	case SMTPTLSNone, SMTPTLSStartTLS, SMTPTLSStartTLSRequired, SMTPTLSImplicit:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("%w: %q, expected none, starttls, starttls-required or implicit", ErrInvalidSMTPTLSMode, s)
+	}
+}
+
+// SMTPConfig stores how to connect and authenticate to the SMTP server.
+type SMTPConfig struct {
+	Server     string
+	Port       int
+	Username   string
+	Password   string
+	AuthMethod string
+	TLS        SMTPTLSMode
+	// CAFile, if set, is a PEM bundle of the certificate authorities trusted
+	// to sign the server's certificate, instead of the system's.
+	CAFile string
+	// ServerName, if set, is the name the server's certificate is verified against, instead of Server.
+	ServerName string
+	// ClientCert and ClientKey, if set, are the PEM files of the client certificate presented to the server.
+	ClientCert string
+	ClientKey  string
+	// OAuth is how the access token is obtained for the xoauth2 auth method.
+	OAuth *OAuthConfig
+}
+
+// smtpConfig returns the SMTP settings of rc.
+func (rc *RunConfig) smtpConfig() *SMTPConfig {
+	return &SMTPConfig{
+		Server:     rc.SMTPServer,
+		Port:       rc.SMTPPort,
+		Username:   rc.SMTPUsername,
+		Password:   rc.SMTPPassword,
+		AuthMethod: rc.SMTPAuthMethod,
+		TLS:        rc.SMTPTLS,
+		CAFile:     rc.SMTPCAFile,
+		ServerName: rc.SMTPServerName,
+		ClientCert: rc.SMTPClientCert,
+		ClientKey:  rc.SMTPClientKey,
+		OAuth: &OAuthConfig{
+			TokenURL:     rc.SMTPOAuthTokenURL,
+			ClientID:     rc.SMTPOAuthClientID,
+			ClientSecret: rc.SMTPOAuthClientSecret,
+			RefreshToken: rc.SMTPOAuthRefreshToken,
+			Scope:        rc.SMTPOAuthScope,
+			CacheDir:     rc.SMTPOAuthCacheDir,
+		},
+	}
+}
+
+// String describes the connection, for logging. The password is never included.
+func (c *SMTPConfig) String() string {
+	details := []string{"TLS: " + string(c.TLS)}
+	if c.ServerName != "" {
+		details = append(details, "server name: "+c.ServerName)
+	}
+	if c.CAFile != "" {
+		details = append(details, "CA file: "+c.CAFile)
+	}
+	if c.ClientCert != "" {
+		details = append(details, "client certificate: "+c.ClientCert)
+	}
+	if c.AuthMethod != "" {
+		details = append(details, "auth: "+c.AuthMethod, "username: "+c.Username)
+	}
+	if c.AuthMethod == SMTPAuthXOAuth2 && c.OAuth != nil {
+		details = append(details, "token URL: "+c.OAuth.TokenURL, "client ID: "+c.OAuth.ClientID)
+	}
+	return fmt.Sprintf("%v (%v)", c.address(), strings.Join(details, ", "))
+}
+
+// address returns the host and port of the SMTP server.
+func (c *SMTPConfig) address() string {
+	return net.JoinHostPort(c.Server, strconv.Itoa(c.Port))
+}
+
+// TLSConfig returns the TLS configuration used to verify the server and present the client certificate.
+func (c *SMTPConfig) TLSConfig() (*tls.Config, error) {
+	config := &tls.Config{ServerName: c.Server, MinVersion: tls.VersionTLS12}
+	if c.ServerName != "" {
+		config.ServerName = c.ServerName
+	}
+	if c.CAFile != "" {
+		bundle, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(bundle) {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidCAFile, c.CAFile)
+		}
+	}
+	if c.ClientCert != "" || c.ClientKey != "" {
+		certificate, err := tls.LoadX509KeyPair(c.ClientCert, c.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("loading the SMTP client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+	return config, nil
+}
+
+// auth returns the SMTP authentication mechanism, or nil if no authentication is used.
+// For xoauth2, an access token is obtained first.
+func (c *SMTPConfig) auth() (smtp.Auth, error) {
+	switch c.AuthMethod {
+	case SMTPAuthCRAMMD5:
+		return smtp.CRAMMD5Auth(c.Username, c.Password), nil
+	case SMTPAuthPlain:
+		return smtp.PlainAuth("", c.Username, c.Password, c.Server), nil
+	case SMTPAuthLogin:
+		return &loginAuth{username: c.Username, password: c.Password}, nil
+	case SMTPAuthXOAuth2:
+		if c.OAuth == nil {
+			return nil, fmt.Errorf("%w: no token endpoint is configured", ErrOAuthToken)
+		}
+		token, err := c.OAuth.Token()
+		if err != nil {
+			return nil, err
+		}
+		return &xoauth2Auth{username: c.Username, token: token}, nil
+	default:
+		return nil, nil
+	}
+}
+
+// Dial connects to the SMTP server, using TLS as configured.
+func (c *SMTPConfig) Dial() (*smtp.Client, error) {
+	tlsConfig, err := c.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	dialer := &net.Dialer{Timeout: smtpTimeout}
+	var conn net.Conn
+	if c.TLS == SMTPTLSImplicit {
+		conn, err = tls.DialWithDialer(dialer, "tcp", c.address(), tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", c.address())
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = conn.SetDeadline(time.Now().Add(smtpTimeout))
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	client, err := smtp.NewClient(conn, c.Server)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if c.TLS == SMTPTLSStartTLS || c.TLS == SMTPTLSStartTLSRequired {
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			err = client.StartTLS(tlsConfig)
+		} else if c.TLS == SMTPTLSStartTLSRequired {
+			err = fmt.Errorf("%w: %v", ErrSTARTTLSUnsupported, c.address())
+		}
+		if err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+	return client, nil
+}
+
+// SendEmail sends the email report using the provided configuration.
+func SendEmail(report *EmailReport, config *SMTPConfig, mailTo, mailFrom string) error {
+	to := TrimSpaceAll(strings.Split(mailTo, ","))
+	message, err := BuildMessage(report, mailFrom, to, time.Now())
+	if err != nil {
+		return fmt.Errorf("building the email message: %w", err)
+	}
+	auth, err := config.auth()
+	if err != nil {
+		return err
+	}
+	client, err := config.Dial()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	if auth != nil {
+		if ok, _ := client.Extension("AUTH"); !ok {
+			return ErrSMTPAuthUnsupported
+		}
+		err = client.Auth(auth)
+		if err != nil {
+			// A rejected access token might have been revoked, so the next report requests a new one.
+			if config.AuthMethod == SMTPAuthXOAuth2 && config.OAuth != nil {
+				config.OAuth.ForgetToken()
+			}
+			return err
+		}
+	}
+	err = client.Mail(mailFrom)
+	if err != nil {
+		return err
+	}
+	for _, recipient := range to {
+		err = client.Rcpt(recipient)
+		if err != nil {
+			return err
+		}
+	}
+	data, err := client.Data()
+	if err != nil {
+		return err
+	}
+	_, err = data.Write(message)
+	if err != nil {
+		return err
+	}
+	err = data.Close()
+	if err != nil {
+		return err
+	}
+	return client.Quit()
+}
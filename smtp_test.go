@@ -0,0 +1,195 @@
+// Copyright 2024 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestCertificate returns a self-signed certificate for mail.test, and writes it to a PEM file in dir.
+func newTestCertificate(t *testing.T, dir string) (tls.Certificate, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "mail.test"},
+		DNSNames:              []string{"mail.test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caFile := filepath.Join(dir, "ca.pem")
+	err = os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, caFile
+}
+
+// serveSMTP accepts one connection on listener and answers it as a minimal SMTP
+// server, offering the extensions. The decoded AUTH credentials and the message
+// data are sent to messages.
+func serveSMTP(listener net.Listener, extensions []string, messages chan<- string) {
+	conn, err := listener.Accept()
+	if err != nil {
+		close(messages)
+		return
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	fmt.Fprint(conn, "220 mail.test ESMTP\r\n")
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			close(messages)
+			return
+		}
+		switch command := strings.ToUpper(strings.Fields(line)[0]); command {
+		case "EHLO":
+			fmt.Fprint(conn, "250-mail.test\r\n")
+			for _, extension := range extensions {
+				fmt.Fprintf(conn, "250-%v\r\n", extension)
+			}
+			fmt.Fprint(conn, "250 8BITMIME\r\n")
+		case "AUTH":
+			fields := strings.Fields(line)
+			credentials := fields[1]
+			if strings.EqualFold(fields[1], "LOGIN") {
+				for _, prompt := range []string{"Username:", "Password:"} {
+					fmt.Fprintf(conn, "334 %v\r\n", base64.StdEncoding.EncodeToString([]byte(prompt)))
+					answer, _ := reader.ReadString('\n')
+					decoded, _ := base64.StdEncoding.DecodeString(strings.TrimSpace(answer))
+					credentials += " " + string(decoded)
+				}
+			} else if len(fields) > 2 {
+				decoded, _ := base64.StdEncoding.DecodeString(fields[2])
+				credentials += " " + string(decoded)
+			}
+			messages <- credentials
+			fmt.Fprint(conn, "235 Authenticated\r\n")
+		case "DATA":
+			fmt.Fprint(conn, "354 Go ahead\r\n")
+			message := new(strings.Builder)
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil || line == ".\r\n" {
+					break
+				}
+				message.WriteString(line)
+			}
+			messages <- message.String()
+			fmt.Fprint(conn, "250 OK\r\n")
+		case "QUIT":
+			fmt.Fprint(conn, "221 Bye\r\n")
+			close(messages)
+			return
+		default:
+			fmt.Fprint(conn, "250 OK\r\n")
+		}
+	}
+}
+
+func TestSendEmailImplicitTLS(t *testing.T) {
+	certificate, caFile := newTestCertificate(t, t.TempDir())
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{certificate}, MinVersion: tls.VersionTLS12})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	messages := make(chan string, 1)
+	go serveSMTP(listener, nil, messages)
+
+	config := &SMTPConfig{Server: "127.0.0.1", Port: listener.Addr().(*net.TCPAddr).Port, TLS: SMTPTLSImplicit, CAFile: caFile, ServerName: "mail.test"}
+	err = SendEmail(&EmailReport{Subject: "Report", Text: "Job finished.\n"}, config, "a@example.com", "runner@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message := <-messages; !strings.Contains(message, "Subject: Report\r\n") || !strings.Contains(message, "Job finished.") {
+		t.Errorf("Unexpected message:\n%v", message)
+	}
+}
+
+func TestSendEmailStartTLSRequired(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	messages := make(chan string, 1)
+	go serveSMTP(listener, nil, messages)
+
+	config := &SMTPConfig{Server: "127.0.0.1", Port: listener.Addr().(*net.TCPAddr).Port, TLS: SMTPTLSStartTLSRequired}
+	err = SendEmail(&EmailReport{Subject: "Report"}, config, "a@example.com", "runner@example.com")
+	if !errors.Is(err, ErrSTARTTLSUnsupported) {
+		t.Errorf("Expected ErrSTARTTLSUnsupported, got %v.", err)
+	}
+	if message, ok := <-messages; ok {
+		t.Errorf("Expected no message to be sent, got:\n%v", message)
+	}
+}
+
+func TestSMTPConfigString(t *testing.T) {
+	config := &SMTPConfig{Server: "smtp.example.com", Port: 465, Username: "runner", Password: "secret", AuthMethod: "plain", TLS: SMTPTLSImplicit, CAFile: "/etc/ssl/campus.pem"}
+	description := config.String()
+	expected := "smtp.example.com:465 (TLS: implicit, CA file: /etc/ssl/campus.pem, auth: plain, username: runner)"
+	if description != expected {
+		t.Errorf("Expected %q, got %q.", expected, description)
+	}
+	if strings.Contains(description, "secret") {
+		t.Errorf("The password is included in %q.", description)
+	}
+}
+
+func TestValidateEmailTLS(t *testing.T) {
+	_, caFile := newTestCertificate(t, t.TempDir())
+	for _, test := range []struct {
+		rc    RunConfig
+		valid bool
+	}{
+		{RunConfig{SMTPTLS: SMTPTLSStartTLSRequired, SMTPCAFile: caFile}, true},
+		{RunConfig{SMTPTLS: "ssl"}, false},
+		{RunConfig{SMTPTLS: SMTPTLSNone, SMTPServerName: "mail.test"}, false},
+		{RunConfig{SMTPTLS: SMTPTLSImplicit, SMTPClientCert: caFile}, false},
+		{RunConfig{SMTPTLS: SMTPTLSImplicit, SMTPCAFile: filepath.Join(filepath.Dir(caFile), "missing.pem")}, false},
+		{RunConfig{SMTPTLS: SMTPTLSStartTLS, SMTPClientCert: filepath.Join(filepath.Dir(caFile), "missing.pem"), SMTPClientKey: filepath.Join(filepath.Dir(caFile), "missing.key")}, false},
+	} {
+		rc := test.rc
+		rc.SMTPServer, rc.MailTo, rc.MailFrom = "smtp.example.com", "a@example.com", "runner@example.com"
+		err := rc.ValidateEmail()
+		if test.valid && err != nil {
+			t.Errorf("%+v: %v", test.rc, err)
+		}
+		if !test.valid && !errors.Is(err, ErrInvalidSettings) {
+			t.Errorf("%+v: expected ErrInvalidSettings, got %v.", test.rc, err)
+		}
+	}
+}
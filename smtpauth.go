@@ -0,0 +1,297 @@
+// Copyright 2024 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrSMTPAuthUnencrypted is returned when the LOGIN or XOAUTH2 auth
+	// methods would send credentials over an unencrypted connection.
+	ErrSMTPAuthUnencrypted = errors.New("refusing to send SMTP credentials over an unencrypted connection")
+
+	// ErrInvalidSMTPAuthMethod is returned when a SMTP auth method isn't recognized.
+	ErrInvalidSMTPAuthMethod = errors.New("invalid SMTP auth method")
+
+	// ErrUnexpectedLoginPrompt is returned when the SMTP server's LOGIN prompt isn't for the username or password.
+	ErrUnexpectedLoginPrompt = errors.New("unexpected LOGIN prompt from the SMTP server")
+
+	// ErrOAuthToken is returned when an OAuth2 access token can't be obtained from the token endpoint.
+	ErrOAuthToken = errors.New("getting an OAuth2 access token failed")
+)
+
+const (
+	// tokenExpiryMargin is how long before its expiry a cached access token is replaced.
+	tokenExpiryMargin = time.Minute
+
+	// maxTokenResponseSize is the largest token endpoint response which is read.
+	maxTokenResponseSize = 64 * 1024
+)
+
+// SMTP auth methods.
+const (
+	SMTPAuthPlain   = "plain"
+	SMTPAuthCRAMMD5 = "crammd5"
+	SMTPAuthLogin   = "login"
+	SMTPAuthXOAuth2 = "xoauth2"
+)
+
+// checkSMTPAuthMethod returns an error if the SMTP auth method isn't recognized.
+// An empty auth method means no authentication is used.
+func checkSMTPAuthMethod(method string) error {
+	switch method {
+	case "", SMTPAuthPlain, SMTPAuthCRAMMD5, SMTPAuthLogin, SMTPAuthXOAuth2:
+		return nil
+	default:
+		return fmt.Errorf("%w: %q, expected plain, crammd5, login or xoauth2", ErrInvalidSMTPAuthMethod, method)
+	}
+}
+
+// checkEncrypted returns an error if credentials would be sent in the clear.
+// Like smtp.PlainAuth, connections to localhost are allowed.
+func checkEncrypted(server *smtp.ServerInfo) error {
+	if server.TLS || server.Name == "localhost" || net.ParseIP(server.Name).IsLoopback() {
+		return nil
+	}
+	return ErrSMTPAuthUnencrypted
+}
+
+// loginAuth implements the LOGIN auth method, which sends the username
+// and password in answer to the server's prompts.
+type loginAuth struct {
+	username string
+	password string
+}
+
+// Start begins the LOGIN exchange.
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	err := checkEncrypted(server)
+	if err != nil {
+		return "", nil, err
+	}
+	return "LOGIN", nil, nil
+}
+
+// Next answers the server's prompt for the username or password.
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	prompt := strings.ToLower(strings.TrimSpace(string(fromServer)))
+	switch {
+	case strings.HasPrefix(prompt, "username"):
+		return []byte(a.username), nil
+	case strings.HasPrefix(prompt, "password"):
+		return []byte(a.password), nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnexpectedLoginPrompt, fromServer)
+	}
+}
+
+// xoauth2Auth implements the XOAUTH2 auth method, which sends an OAuth2 access token.
+type xoauth2Auth struct {
+	username string
+	token    string
+}
+
+// Start sends the username and access token.
+func (a *xoauth2Auth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	err := checkEncrypted(server)
+	if err != nil {
+		return "", nil, err
+	}
+	return "XOAUTH2", []byte("user=" + a.username + "\x01auth=Bearer " + a.token + "\x01\x01"), nil
+}
+
+// Next answers a failure challenge, which holds the error details, with an
+// empty response so the server finishes the exchange with an error.
+func (a *xoauth2Auth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if more {
+		return []byte{}, nil
+	}
+	return nil, nil
+}
+
+// OAuthConfig stores how an OAuth2 access token is obtained for XOAUTH2.
+// The refresh token flow is used if RefreshToken is set, otherwise the client credentials flow.
+type OAuthConfig struct {
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	RefreshToken string
+	Scope        string
+	// CacheDir, if set, is where access tokens are cached between runs.
+	CacheDir string
+	// HTTPClient is used to call the token endpoint.
+	HTTPClient *http.Client
+}
+
+// oauthToken is a token endpoint response, and the cached form of an access token.
+type oauthToken struct {
+	AccessToken      string    `json:"access_token"`
+	TokenType        string    `json:"token_type,omitempty"`
+	ExpiresIn        int       `json:"expires_in,omitempty"`
+	RefreshToken     string    `json:"refresh_token,omitempty"`
+	Expiry           time.Time `json:"expiry"`
+	Error            string    `json:"error,omitempty"`
+	ErrorDescription string    `json:"error_description,omitempty"`
+}
+
+// valid reports whether the access token can still be used at now.
+func (t *oauthToken) valid(now time.Time) bool {
+	return t.AccessToken != "" && (t.Expiry.IsZero() || now.Add(tokenExpiryMargin).Before(t.Expiry))
+}
+
+// defaultTokenCacheDir returns the directory access tokens are cached in by default,
+// or an empty string if the user has no cache directory.
+func defaultTokenCacheDir() string {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(cacheDir, "alma-api-job-runner", "tokens")
+}
+
+// cachePath returns the file the access token is cached in. The name is a hash of the
+// settings which identify the token, so changing them doesn't reuse an old token.
+func (c *OAuthConfig) cachePath() string {
+	if c.CacheDir == "" {
+		return ""
+	}
+	hash := sha256.Sum256([]byte(strings.Join([]string{c.TokenURL, c.ClientID, c.Scope, c.RefreshToken}, "\x00")))
+	return filepath.Join(c.CacheDir, hex.EncodeToString(hash[:16])+".json")
+}
+
+// Token returns an access token, from the cache if it hasn't expired, otherwise from the token endpoint.
+// A refresh token returned by the token endpoint replaces the configured one in later requests.
+func (c *OAuthConfig) Token() (string, error) {
+	now := time.Now()
+	cached := c.loadToken()
+	if cached != nil && cached.valid(now) {
+		return cached.AccessToken, nil
+	}
+	refreshToken := c.RefreshToken
+	if cached != nil && cached.RefreshToken != "" {
+		refreshToken = cached.RefreshToken
+	}
+	token, err := c.requestToken(refreshToken, now)
+	if err != nil {
+		return "", err
+	}
+	if token.RefreshToken == "" {
+		token.RefreshToken = refreshToken
+	}
+	c.saveToken(token)
+	return token.AccessToken, nil
+}
+
+// ForgetToken removes the cached access token, after the SMTP server rejects it.
+// A cached refresh token is kept, since the configured one might have been replaced.
+func (c *OAuthConfig) ForgetToken() {
+	cached := c.loadToken()
+	if cached == nil {
+		return
+	}
+	c.saveToken(&oauthToken{RefreshToken: cached.RefreshToken})
+}
+
+// loadToken returns the cached access token, or nil if there isn't one.
+func (c *OAuthConfig) loadToken() *oauthToken {
+	path := c.cachePath()
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	token := new(oauthToken)
+	if json.Unmarshal(data, token) != nil {
+		return nil
+	}
+	return token
+}
+
+// saveToken caches the access token. Caching is best effort, so errors are ignored.
+func (c *OAuthConfig) saveToken(token *oauthToken) {
+	path := c.cachePath()
+	if path == "" {
+		return
+	}
+	data, err := json.Marshal(token)
+	if err != nil || os.MkdirAll(c.CacheDir, 0o700) != nil {
+		return
+	}
+	_ = writeFileAtomically(path, data)
+}
+
+// requestToken requests an access token from the token endpoint.
+func (c *OAuthConfig) requestToken(refreshToken string, now time.Time) (*oauthToken, error) {
+	form := url.Values{}
+	form.Set("client_id", c.ClientID)
+	if c.ClientSecret != "" {
+		form.Set("client_secret", c.ClientSecret)
+	}
+	if c.Scope != "" {
+		form.Set("scope", c.Scope)
+	}
+	if refreshToken != "" {
+		form.Set("grant_type", "refresh_token")
+		form.Set("refresh_token", refreshToken)
+	} else {
+		form.Set("grant_type", "client_credentials")
+	}
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: smtpTimeout}
+	}
+	request, err := http.NewRequestWithContext(context.Background(), http.MethodPost, c.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrOAuthToken, err)
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := httpClient.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrOAuthToken, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxTokenResponseSize))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrOAuthToken, err)
+	}
+	token := new(oauthToken)
+	err = json.Unmarshal(body, token)
+	if resp.StatusCode != http.StatusOK {
+		if err == nil && token.Error != "" {
+			return nil, fmt.Errorf("%w: %v, %v %v", ErrOAuthToken, resp.Status, token.Error, token.ErrorDescription)
+		}
+		return nil, fmt.Errorf("%w: %v", ErrOAuthToken, resp.Status)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrOAuthToken, err)
+	}
+	if token.AccessToken == "" {
+		return nil, fmt.Errorf("%w: the response doesn't include an access token", ErrOAuthToken)
+	}
+	if token.ExpiresIn > 0 {
+		token.Expiry = now.Add(time.Duration(token.ExpiresIn) * time.Second)
+	}
+	return token, nil
+}
@@ -0,0 +1,130 @@
+// Copyright 2024 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"testing"
+)
+
+// newTokenServer returns a token endpoint which issues numbered access tokens, and
+// rotates the refresh token, recording the grant type and refresh token of each request.
+func newTokenServer(t *testing.T, requests *[]string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := r.ParseForm()
+		if err != nil || r.PostForm.Get("client_id") != "runner" {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"error": "invalid_client", "error_description": "Unknown client."}`)
+			return
+		}
+		*requests = append(*requests, r.PostForm.Get("grant_type")+" "+r.PostForm.Get("refresh_token"))
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token":  fmt.Sprintf("token-%v", len(*requests)),
+			"token_type":    "Bearer",
+			"expires_in":    3600,
+			"refresh_token": fmt.Sprintf("refresh-%v", len(*requests)),
+		})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestOAuthToken(t *testing.T) {
+	var requests []string
+	server := newTokenServer(t, &requests)
+	config := &OAuthConfig{TokenURL: server.URL, ClientID: "runner", ClientSecret: "secret", RefreshToken: "refresh-0", CacheDir: t.TempDir()}
+
+	token, err := config.Token()
+	if err != nil || token != "token-1" {
+		t.Fatalf("Expected token-1, got %q, %v.", token, err)
+	}
+	// The access token is cached, so the token endpoint isn't called again.
+	token, err = config.Token()
+	if err != nil || token != "token-1" || len(requests) != 1 {
+		t.Fatalf("Expected the cached token-1, got %q, %v after %v requests.", token, err, len(requests))
+	}
+	// Once the access token is forgotten, the rotated refresh token is used.
+	config.ForgetToken()
+	token, err = config.Token()
+	if err != nil || token != "token-2" {
+		t.Fatalf("Expected token-2, got %q, %v.", token, err)
+	}
+	expected := []string{"refresh_token refresh-0", "refresh_token refresh-1"}
+	if fmt.Sprint(requests) != fmt.Sprint(expected) {
+		t.Errorf("Expected requests %v, got %v.", expected, requests)
+	}
+
+	// Without a refresh token, the client credentials flow is used.
+	config = &OAuthConfig{TokenURL: server.URL, ClientID: "runner"}
+	_, err = config.Token()
+	if err != nil || requests[len(requests)-1] != "client_credentials " {
+		t.Errorf("Expected a client credentials request, got %v, %v.", requests, err)
+	}
+
+	config = &OAuthConfig{TokenURL: server.URL, ClientID: "someone"}
+	_, err = config.Token()
+	if !errors.Is(err, ErrOAuthToken) {
+		t.Errorf("Expected ErrOAuthToken, got %v.", err)
+	}
+}
+
+func TestSendEmailAuth(t *testing.T) {
+	var requests []string
+	server := newTokenServer(t, &requests)
+	for _, test := range []struct {
+		config   SMTPConfig
+		expected string
+	}{
+		{SMTPConfig{AuthMethod: SMTPAuthLogin, Username: "runner", Password: "secret"}, "LOGIN runner secret"},
+		{
+			SMTPConfig{AuthMethod: SMTPAuthXOAuth2, Username: "runner@example.com", OAuth: &OAuthConfig{TokenURL: server.URL, ClientID: "runner"}},
+			"XOAUTH2 user=runner@example.com\x01auth=Bearer token-1\x01\x01",
+		},
+	} {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		messages := make(chan string, 2)
+		go serveSMTP(listener, []string{"AUTH LOGIN XOAUTH2"}, messages)
+
+		config := test.config
+		config.Server, config.Port, config.TLS = "127.0.0.1", listener.Addr().(*net.TCPAddr).Port, SMTPTLSNone
+		err = SendEmail(&EmailReport{Subject: "Report"}, &config, "a@example.com", "runner@example.com")
+		listener.Close()
+		if err != nil {
+			t.Errorf("%v: %v", test.config.AuthMethod, err)
+			continue
+		}
+		if credentials := <-messages; credentials != test.expected {
+			t.Errorf("%v: expected %q, got %q.", test.config.AuthMethod, test.expected, credentials)
+		}
+	}
+}
+
+func TestSendEmailAuthWithoutOAuth(t *testing.T) {
+	config := &SMTPConfig{AuthMethod: SMTPAuthXOAuth2, Username: "runner@example.com"}
+	_, err := config.auth()
+	if !errors.Is(err, ErrOAuthToken) {
+		t.Errorf("Expected ErrOAuthToken without an OAuth2 configuration, got %v.", err)
+	}
+}
+
+func TestSMTPAuthUnencrypted(t *testing.T) {
+	for _, auth := range []smtp.Auth{&loginAuth{}, &xoauth2Auth{}} {
+		_, _, err := auth.Start(&smtp.ServerInfo{Name: "smtp.example.com", Auth: []string{"LOGIN", "XOAUTH2"}})
+		if !errors.Is(err, ErrSMTPAuthUnencrypted) {
+			t.Errorf("%T: expected ErrSMTPAuthUnencrypted, got %v.", auth, err)
+		}
+	}
+}
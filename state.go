@@ -0,0 +1,240 @@
+// Copyright 2024 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// stateFileExtension is the extension of run state files.
+const stateFileExtension = ".json"
+
+// RunState records a run whose job instance is being monitored, so that a
+// later process can resume monitoring it and send the report it owes.
+// Secrets, like the API key, the SMTP password, the OAuth2 client secret and the
+// notification signing secret, aren't stored.
+type RunState struct {
+	ID             string    `json:"id"`
+	PID            int       `json:"pid"`
+	Name           string    `json:"name"`
+	Job            string    `json:"job,omitempty"`
+	Domain         string    `json:"domain"`
+	JobPath        string    `json:"url"`
+	InstanceURL    string    `json:"instance_url"`
+	SubmitTime     time.Time `json:"submit_time"`
+	SendEmail      bool      `json:"email"`
+	SMTPServer     string    `json:"smtpserver,omitempty"`
+	SMTPPort       int       `json:"smtpport,omitempty"`
+	SMTPUsername   string    `json:"smtpusername,omitempty"`
+	SMTPAuthMethod string    `json:"smtpauthmethod,omitempty"`
+	SMTPTLS        string    `json:"smtptls,omitempty"`
+	SMTPCAFile     string    `json:"smtpcafile,omitempty"`
+	SMTPServerName string    `json:"smtpservername,omitempty"`
+	SMTPClientCert string    `json:"smtpclientcert,omitempty"`
+	SMTPClientKey  string    `json:"smtpclientkey,omitempty"`
+	SMTPOAuthURL   string    `json:"smtpoauthtokenurl,omitempty"`
+	SMTPOAuthID    string    `json:"smtpoauthclientid,omitempty"`
+	SMTPOAuthScope string    `json:"smtpoauthscope,omitempty"`
+	MailTo         string    `json:"mailto,omitempty"`
+	MailFrom       string    `json:"mailfrom,omitempty"`
+	Output         string    `json:"output,omitempty"`
+	OutputFile     string    `json:"outputfile,omitempty"`
+	FailOnWarning  bool      `json:"failonwarning"`
+	NotifyURLs     string    `json:"notifyurls,omitempty"`
+	NotifyEvents   string    `json:"notifyevents,omitempty"`
+	NotifyTemplate string    `json:"notifytemplate,omitempty"`
+	NotifyHeaders  string    `json:"notifyheaders,omitempty"`
+	NotifyAttempts int       `json:"notifyattempts,omitempty"`
+	SlackURLs      string    `json:"slackurls,omitempty"`
+	SlackEvents    string    `json:"slackevents,omitempty"`
+	TeamsURLs      string    `json:"teamsurls,omitempty"`
+	TeamsEvents    string    `json:"teamsevents,omitempty"`
+
+	// path is the file the state was saved to or loaded from.
+	path string
+}
+
+// defaultStateDir returns the directory run states are stored in by default,
+// or an empty string if the user has no cache directory.
+func defaultStateDir() string {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(cacheDir, "alma-api-job-runner", "runs")
+}
+
+// NewRunState returns the state of the run rc, which submitted the job instance at instanceURL.
+func NewRunState(rc *RunConfig, instanceURL *url.URL, submitTime time.Time) *RunState {
+	return &RunState{
+		ID:             fmt.Sprintf("%v-%v", submitTime.UTC().Format("20060102T150405Z"), path.Base(instanceURL.Path)),
+		PID:            os.Getpid(),
+		Name:           rc.Name,
+		Job:            rc.job,
+		Domain:         rc.Domain,
+		JobPath:        rc.JobPath,
+		InstanceURL:    instanceURL.String(),
+		SubmitTime:     submitTime,
+		SendEmail:      rc.SendEmail,
+		SMTPServer:     rc.SMTPServer,
+		SMTPPort:       rc.SMTPPort,
+		SMTPUsername:   rc.SMTPUsername,
+		SMTPAuthMethod: rc.SMTPAuthMethod,
+		SMTPTLS:        string(rc.SMTPTLS),
+		SMTPCAFile:     rc.SMTPCAFile,
+		SMTPServerName: rc.SMTPServerName,
+		SMTPClientCert: rc.SMTPClientCert,
+		SMTPClientKey:  rc.SMTPClientKey,
+		SMTPOAuthURL:   rc.SMTPOAuthTokenURL,
+		SMTPOAuthID:    rc.SMTPOAuthClientID,
+		SMTPOAuthScope: rc.SMTPOAuthScope,
+		MailTo:         rc.MailTo,
+		MailFrom:       rc.MailFrom,
+		Output:         rc.Output,
+		OutputFile:     rc.OutputFile,
+		FailOnWarning:  rc.FailOnWarning,
+		NotifyURLs:     rc.NotifyURLs,
+		NotifyEvents:   rc.NotifyEvents,
+		NotifyTemplate: rc.NotifyTemplate,
+		NotifyHeaders:  rc.NotifyHeaders,
+		NotifyAttempts: rc.NotifyAttempts,
+		SlackURLs:      rc.SlackURLs,
+		SlackEvents:    rc.SlackEvents,
+		TeamsURLs:      rc.TeamsURLs,
+		TeamsEvents:    rc.TeamsEvents,
+	}
+}
+
+// Apply copies the run's settings into rc.
+func (s *RunState) Apply(rc *RunConfig) {
+	rc.Name = s.Name
+	rc.Domain = s.Domain
+	rc.JobPath = s.JobPath
+	rc.SendEmail = s.SendEmail
+	rc.SMTPServer = s.SMTPServer
+	rc.SMTPPort = s.SMTPPort
+	rc.SMTPUsername = s.SMTPUsername
+	rc.SMTPAuthMethod = s.SMTPAuthMethod
+	// States saved by earlier versions don't store the TLS mode, so rc's is kept.
+	if s.SMTPTLS != "" {
+		rc.SMTPTLS = SMTPTLSMode(s.SMTPTLS)
+	}
+	rc.SMTPCAFile = s.SMTPCAFile
+	rc.SMTPServerName = s.SMTPServerName
+	rc.SMTPClientCert = s.SMTPClientCert
+	rc.SMTPClientKey = s.SMTPClientKey
+	rc.SMTPOAuthTokenURL = s.SMTPOAuthURL
+	rc.SMTPOAuthClientID = s.SMTPOAuthID
+	rc.SMTPOAuthScope = s.SMTPOAuthScope
+	rc.MailTo = s.MailTo
+	rc.MailFrom = s.MailFrom
+	// States saved by earlier versions don't store the output and notification settings, so rc's are kept.
+	if s.Output == "" {
+		return
+	}
+	rc.Output = s.Output
+	rc.OutputFile = s.OutputFile
+	rc.FailOnWarning = s.FailOnWarning
+	rc.NotifyURLs = s.NotifyURLs
+	rc.NotifyEvents = s.NotifyEvents
+	rc.NotifyTemplate = s.NotifyTemplate
+	rc.NotifyHeaders = s.NotifyHeaders
+	rc.NotifyAttempts = s.NotifyAttempts
+	rc.SlackURLs = s.SlackURLs
+	rc.SlackEvents = s.SlackEvents
+	rc.TeamsURLs = s.TeamsURLs
+	rc.TeamsEvents = s.TeamsEvents
+}
+
+// SaveRunState writes the state to a file in dir named after its ID.
+// The file is replaced atomically, so a state file is never partially written.
+func SaveRunState(dir string, state *RunState) error {
+	err := os.MkdirAll(dir, 0o700)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	statePath := filepath.Join(dir, state.ID+stateFileExtension)
+	err = writeFileAtomically(statePath, append(data, '\n'))
+	if err != nil {
+		return err
+	}
+	state.path = statePath
+	return nil
+}
+
+// writeFileAtomically writes data to a temporary file next to path, then renames it to path,
+// so the file is never partially written.
+func writeFileAtomically(path string, data []byte) error {
+	temp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = temp.Write(data)
+	if err == nil {
+		err = temp.Sync()
+	}
+	closeErr := temp.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(temp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(temp.Name())
+		return err
+	}
+	return nil
+}
+
+// Remove deletes the state's file.
+func (s *RunState) Remove() error {
+	return os.Remove(s.path)
+}
+
+// LoadRunStates reads the run states stored in dir, ordered by submit time.
+// A missing directory has no run states.
+func LoadRunStates(dir string) ([]*RunState, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var states []*RunState
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), stateFileExtension) {
+			continue
+		}
+		statePath := filepath.Join(dir, entry.Name())
+		data, err := os.ReadFile(statePath)
+		if err != nil {
+			return nil, err
+		}
+		state := &RunState{path: statePath}
+		err = json.Unmarshal(data, state)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %w", statePath, err)
+		}
+		states = append(states, state)
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].SubmitTime.Before(states[j].SubmitTime)
+	})
+	return states, nil
+}
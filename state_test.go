@@ -0,0 +1,220 @@
+// Copyright 2024 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRunStates(t *testing.T) {
+	dir := t.TempDir()
+	rc := &RunConfig{
+		Name:         "Export",
+		Domain:       "api-ca.hosted.exlibrisgroup.com",
+		Key:          "secretkey",
+		JobPath:      "/almaws/v1/conf/jobs/M1?op=run",
+		SendEmail:    true,
+		SMTPServer:   "smtp.example.com",
+		SMTPPort:     587,
+		SMTPPassword: "secretpassword",
+		MailTo:       "staff@example.com",
+		MailFrom:     "runner@example.com",
+		Output:       OutputJSON,
+		OutputFile:   "/var/lib/alma/export.json",
+		NotifyURLs:   "https://hooks.example.com/alma",
+		NotifyEvents: "failure",
+		NotifySecret: "secretsigningkey",
+		SlackURLs:    "https://hooks.slack.com/services/T0/B0/abc",
+		SlackEvents:  "failure,warning",
+		job:          "weekly-export",
+	}
+	submitTime := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+	for i, id := range []string{"2", "1"} {
+		instanceURL, err := url.Parse("https://api-ca.hosted.exlibrisgroup.com/almaws/v1/conf/jobs/M1/instances/" + id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = SaveRunState(dir, NewRunState(rc, instanceURL, submitTime.Add(-time.Duration(i)*time.Hour)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	states, err := LoadRunStates(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(states) != 2 || states[0].ID != "20240301T110000Z-1" || states[1].ID != "20240301T120000Z-2" {
+		t.Fatalf("Expected two states ordered by submit time, got %+v.", states)
+	}
+	if states[0].Job != "weekly-export" {
+		t.Fatalf("Expected the run's job section to be stored, got %q.", states[0].Job)
+	}
+
+	resumed := &RunConfig{Key: "resumekey", Output: OutputXML, FailOnWarning: true, NotifyURLs: "https://other.example.com"}
+	states[0].Apply(resumed)
+	if resumed.Name != rc.Name || resumed.JobPath != rc.JobPath || resumed.MailTo != rc.MailTo || resumed.SMTPPort != rc.SMTPPort {
+		t.Fatalf("Expected the run's settings to be applied, got %+v.", resumed)
+	}
+	if resumed.Output != rc.Output || resumed.OutputFile != rc.OutputFile || resumed.FailOnWarning || resumed.NotifyURLs != rc.NotifyURLs || resumed.SlackEvents != rc.SlackEvents {
+		t.Fatalf("Expected the run's output and notification settings to be applied, got %+v.", resumed)
+	}
+	if resumed.Key != "resumekey" || resumed.SMTPPassword != "" || resumed.NotifySecret != "" {
+		t.Fatal("Expected secrets not to be stored in the run state.")
+	}
+
+	err = states[0].Remove()
+	if err != nil {
+		t.Fatal(err)
+	}
+	states, err = LoadRunStates(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(states) != 1 {
+		t.Fatalf("Expected one state after removing one, got %v.", len(states))
+	}
+
+	states, err = LoadRunStates(dir + string(os.PathSeparator) + "missing")
+	if err != nil || len(states) != 0 {
+		t.Fatalf("Expected a missing directory to have no states, got %v, %v.", states, err)
+	}
+}
+
+func TestRunStateFromEarlierVersion(t *testing.T) {
+	// States saved by earlier versions don't have the output and notification settings.
+	state := &RunState{Name: "Export", MailTo: "staff@example.com"}
+	resumed := &RunConfig{Output: OutputXML, FailOnWarning: true, NotifyURLs: "https://hooks.example.com/alma"}
+	state.Apply(resumed)
+	if resumed.MailTo != state.MailTo || resumed.Output != OutputXML || !resumed.FailOnWarning || resumed.NotifyURLs != "https://hooks.example.com/alma" {
+		t.Fatalf("Expected the resume command's output and notification settings to be kept, got %+v.", resumed)
+	}
+}
+
+func TestRunInterruptedKeepsState(t *testing.T) {
+	mu := new(sync.Mutex)
+	var events []string
+	notifications := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, r.Header.Get(EventHeader))
+	}))
+	defer notifications.Close()
+
+	// The process is interrupted while the job is running.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/almaws/v1/conf/jobs/M47":
+			fmt.Fprintf(w, `<job><additional_info link="https://%v/almaws/v1/conf/jobs/M47/instances/12345">Job submitted.</additional_info></job>`, r.Host)
+		case r.URL.Path == "/almaws/v1/conf/jobs/M47/instances/12345":
+			cancel()
+			fmt.Fprint(w, `<job_instance><id>12345</id><status desc="Running">RUNNING</status></job_instance>`)
+		default:
+			t.Errorf("Unexpected request %v %v.", r.Method, r.URL)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	params := filepath.Join(dir, "params.xml")
+	err := os.WriteFile(params, []byte(`<job><parameters><parameter><name>set_id</name><value>1</value></parameter></parameters></job>`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc := &RunConfig{}
+	rc.RegisterFlags(flag.NewFlagSet("run", flag.ContinueOnError))
+	rc.Domain = strings.TrimPrefix(server.URL, "https://")
+	rc.Key = "testkey"
+	rc.JobPath = "/almaws/v1/conf/jobs/M47?op=run"
+	rc.Params = params
+	rc.LockDir = ""
+	rc.StateDir = filepath.Join(dir, "runs")
+	rc.NotifyURLs = notifications.URL
+	rc.NotifyEvents = "start,failure"
+	rc.httpClient = server.Client()
+
+	_, err = rc.Run(ctx, io.Discard, "")
+	if err == nil {
+		t.Fatal("Expected the interrupted run to fail.")
+	}
+	// The failure is reported when the run is resumed, not now.
+	if fmt.Sprint(events) != "[start]" {
+		t.Errorf("Expected only the start notification, got %v.", events)
+	}
+	states, err := LoadRunStates(rc.StateDir)
+	if err != nil || len(states) != 1 {
+		t.Fatalf("Expected the run's state to be kept, got %v, %v.", states, err)
+	}
+}
+
+func TestResumeRunConfig(t *testing.T) {
+	config, err := ParseConfig(strings.NewReader(`[defaults]
+key = "defaultkey"
+pollfailurewindow = "1m"
+
+[jobs.weekly-export]
+key = "weeklykey"
+
+[jobs.nightly-export]
+key = "nightlykey"
+pollfailurewindow = "5m"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs := flag.NewFlagSet("resume", flag.ContinueOnError)
+	(&RunConfig{}).RegisterFlags(fs)
+	err = fs.Parse([]string{"-pollfailurewindow", "30s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	base, err := jobRunConfig(fs, config, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The settings which aren't stored come from the run's job section, and the command line.
+	resumed, err := resumeRunConfig(fs, base, config, "", &RunState{ID: "1", Name: "Nightly", Job: "nightly-export"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resumed.Key != "nightlykey" || resumed.PollFailureWindow != 30*time.Second || resumed.Name != "Nightly" {
+		t.Errorf("Expected the nightly-export settings, got key %q, poll failure window %v and name %q.", resumed.Key, resumed.PollFailureWindow, resumed.Name)
+	}
+
+	// Runs which didn't record their job section use -job's section.
+	weekly, err := jobRunConfig(fs, config, "weekly-export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resumed, err = resumeRunConfig(fs, weekly, config, "weekly-export", &RunState{ID: "2", Name: "Weekly"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resumed.Key != "weeklykey" {
+		t.Errorf("Expected the weekly-export key, got %q.", resumed.Key)
+	}
+
+	_, err = resumeRunConfig(fs, weekly, config, "weekly-export", &RunState{ID: "3", Name: "Nightly", Job: "nightly-export"})
+	if !errors.Is(err, ErrInvalidSettings) {
+		t.Errorf("Expected ErrInvalidSettings for a mismatched job section, got %v.", err)
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright 2024 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cu-library/alma-api-job-runner/alma"
+)
+
+// reportTimeLayout is the format of the times shown in reports.
+const reportTimeLayout = "2006-01-02 15:04:05 MST"
+
+// InstanceTiming stores when a job instance was submitted, started and ended,
+// and how long it waited, ran and took in total. Values which aren't known are empty.
+type InstanceTiming struct {
+	Submit time.Time
+	Start  time.Time
+	End    time.Time
+	Queue  string
+	Run    string
+	Wall   string
+}
+
+// NewInstanceTiming returns the timing of the job instance, with its times in loc.
+// The timing of a nil job instance is empty.
+func NewInstanceTiming(instance *alma.AlmaJobInstance, loc *time.Location) InstanceTiming {
+	if instance == nil {
+		return InstanceTiming{}
+	}
+	times := instance.Times().In(loc)
+	return InstanceTiming{
+		Submit: times.Submit,
+		Start:  times.Start,
+		End:    times.End,
+		Queue:  formatDuration(times.QueueDuration()),
+		Run:    formatDuration(times.RunDuration()),
+		Wall:   formatDuration(times.WallDuration()),
+	}
+}
+
+// Durations returns the known durations on one line, like "queued 5s, ran 2m0s, total 2m5s".
+func (t InstanceTiming) Durations() string {
+	var parts []string
+	if t.Queue != "" {
+		parts = append(parts, "queued "+t.Queue)
+	}
+	if t.Run != "" {
+		parts = append(parts, "ran "+t.Run)
+	}
+	if t.Wall != "" {
+		parts = append(parts, "total "+t.Wall)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Lines returns a description of the timing for reports.
+func (t InstanceTiming) Lines() []string {
+	var lines []string
+	for _, field := range []struct {
+		name  string
+		value time.Time
+	}{
+		{"Submitted", t.Submit},
+		{"Started", t.Start},
+		{"Ended", t.End},
+	} {
+		if !field.value.IsZero() {
+			lines = append(lines, fmt.Sprintf("%v: %v", field.name, field.value.Format(reportTimeLayout)))
+		}
+	}
+	for _, field := range []struct{ name, value string }{
+		{"Queue wait", t.Queue},
+		{"Execution time", t.Run},
+		{"Wall time", t.Wall},
+	} {
+		if field.value != "" {
+			lines = append(lines, fmt.Sprintf("%v: %v", field.name, field.value))
+		}
+	}
+	return lines
+}
+
+// durationsNote returns the known durations in parentheses, with a leading space,
+// or an empty string if none are known.
+func durationsNote(t InstanceTiming) string {
+	durations := t.Durations()
+	if durations == "" {
+		return ""
+	}
+	return " (" + durations + ")"
+}
+
+// formatDuration rounds the duration to the second, or returns an empty string if it isn't known.
+func formatDuration(d time.Duration, ok bool) string {
+	if !ok {
+		return ""
+	}
+	return d.Round(time.Second).String()
+}
+
+// formatTime formats the time as RFC 3339, or returns an empty string if it's zero.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+// displayLocation returns the time zone times are shown in.
+func (rc *RunConfig) displayLocation() *time.Location {
+	if rc.DisplayTimezone == "" {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(rc.DisplayTimezone)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
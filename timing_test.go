@@ -0,0 +1,41 @@
+// Copyright 2024 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cu-library/alma-api-job-runner/alma"
+)
+
+func TestInstanceTiming(t *testing.T) {
+	instance := &alma.AlmaJobInstance{
+		SubmitTime: "2024-01-08T10:00:00.000Z",
+		StartTime:  "2024-01-08T10:00:05.400Z",
+		EndTime:    "2024-01-08T10:02:05.400Z",
+	}
+	timing := NewInstanceTiming(instance, time.FixedZone("EST", -5*60*60))
+	if durations := timing.Durations(); durations != "queued 5s, ran 2m0s, total 2m5s" {
+		t.Errorf("Unexpected durations %q.", durations)
+	}
+	expected := []string{
+		"Submitted: 2024-01-08 05:00:00 EST",
+		"Started: 2024-01-08 05:00:05 EST",
+		"Ended: 2024-01-08 05:02:05 EST",
+		"Queue wait: 5s",
+		"Execution time: 2m0s",
+		"Wall time: 2m5s",
+	}
+	if lines := timing.Lines(); !reflect.DeepEqual(lines, expected) {
+		t.Errorf("Expected %q, got %q.", expected, lines)
+	}
+
+	instance.StartTime, instance.EndTime = "", ""
+	if durations := NewInstanceTiming(instance, time.UTC).Durations(); durations != "" {
+		t.Errorf("Expected no durations for an instance which hasn't started, got %q.", durations)
+	}
+}
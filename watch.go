@@ -0,0 +1,195 @@
+// Copyright 2024 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"os/signal"
+	"path"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/cu-library/alma-api-job-runner/alma"
+)
+
+// ErrInvalidInstance is returned when a job instance argument isn't recognized.
+var ErrInvalidInstance = errors.New("invalid job instance")
+
+// WatchResult is the outcome of watching one job instance.
+type WatchResult struct {
+	InstanceURL *url.URL
+	Instance    *alma.AlmaJobInstance
+	Err         error
+	// Log stores the log messages about the job instance.
+	Log *bytes.Buffer
+}
+
+// ID returns the ID of the watched job instance.
+func (r *WatchResult) ID() string {
+	return path.Base(r.InstanceURL.Path)
+}
+
+// Status returns the description of the job instance's final status, or "error".
+func (r *WatchResult) Status() string {
+	if r.Err != nil || r.Instance == nil {
+		return "error"
+	}
+	return r.Instance.StatusDesc()
+}
+
+// watchCommand monitors job instances which were submitted some other way,
+// like in the Alma UI, without submitting a job.
+func watchCommand(args []string) {
+	rc := &RunConfig{}
+	fs := newFlagSet("watch", "Monitor the job instances given as arguments after the flags, without submitting a job. "+
+		"Each is a job instance URL, or a job ID and instance ID separated by a slash (ex: M47/12345).")
+	rc.RegisterFlags(fs)
+	configPath, jobName := registerConfigFlags(fs)
+	parseFlags(fs, args)
+	configureCommand(fs, *configPath, *jobName, rc.ValidateMonitor)
+
+	if fs.NArg() == 0 {
+		exitConfigError("FATAL: At least one job instance is required.")
+	}
+	instanceURLs := make([]*url.URL, 0, fs.NArg())
+	for _, arg := range fs.Args() {
+		instanceURL, err := ParseInstanceArg(rc.Domain, arg)
+		if err != nil {
+			exitConfigError("FATAL:", err)
+		}
+		instanceURLs = append(instanceURLs, instanceURL)
+	}
+
+	// Cancel any in-progress requests when the process is interrupted.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	results := rc.Watch(ctx, instanceURLs, os.Stderr)
+	code := ExitSuccess
+	for _, result := range results {
+		code = MaxExitCode(code, ExitCode(result.Instance, result.Err, rc.FailOnWarning))
+	}
+	if code != ExitSuccess {
+		stop()
+		os.Exit(code)
+	}
+}
+
+// ParseInstanceArg returns the URL of the job instance described by arg: a URL,
+// a path starting with a /, or a job ID and instance ID separated by a slash.
+func ParseInstanceArg(domain, arg string) (*url.URL, error) {
+	switch {
+	case strings.HasPrefix(arg, "https://"), strings.HasPrefix(arg, "http://"):
+		return url.Parse(arg)
+	case strings.HasPrefix(arg, "/"):
+		return url.Parse(fmt.Sprintf("https://%v%v", domain, arg))
+	}
+	ids := strings.Split(arg, "/")
+	if len(ids) != 2 || ids[0] == "" || ids[1] == "" {
+		return nil, fmt.Errorf("%w: %q, expected a URL or a job ID and instance ID (ex: M47/12345)", ErrInvalidInstance, arg)
+	}
+	return url.Parse(fmt.Sprintf("https://%v/almaws/v1/conf/jobs/%v/instances/%v", domain, url.PathEscape(ids[0]), url.PathEscape(ids[1])))
+}
+
+// Watch monitors the job instances concurrently until they are finished, prints
+// each final job instance, and sends one optional email report covering them all.
+// The results are returned in the order of instanceURLs.
+func (rc *RunConfig) Watch(ctx context.Context, instanceURLs []*url.URL, logOutput io.Writer) []*WatchResult {
+	emailMessage := new(bytes.Buffer)
+	logger := log.New(io.MultiWriter(logOutput, emailMessage), "", log.LstdFlags|log.Lmsgprefix)
+	logger.Println(rc.Name)
+	logger.Println("Using alma-api-job-runner version", version)
+	logger.Println("Alma API server domain (domain):", rc.Domain)
+	logger.Printf("Watching %v job instances.\n", len(instanceURLs))
+
+	results := make([]*WatchResult, len(instanceURLs))
+	wg := new(sync.WaitGroup)
+	for i, instanceURL := range instanceURLs {
+		result := &WatchResult{InstanceURL: instanceURL, Log: new(bytes.Buffer)}
+		results[i] = result
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			instanceLogger := log.New(io.MultiWriter(logOutput, result.Log), "["+result.ID()+"] ", log.LstdFlags|log.Lmsgprefix)
+			client, err := rc.newClient(instanceLogger)
+			if err != nil {
+				instanceLogger.Println("Error creating Alma API client: ", err)
+				result.Err = &RunError{Stage: StageConfig, Err: err}
+				return
+			}
+			instanceLogger.Println("Going to monitor job at: ", result.InstanceURL)
+			result.Instance, err = rc.monitor(ctx, client, instanceLogger, result.InstanceURL)
+			if err != nil {
+				instanceLogger.Println("Error monitoring job instance: ", err)
+				result.Err = &RunError{Stage: StageMonitor, Err: err}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Write the final job instances to stdout or the output file.
+	outputs := make([]*InstanceOutput, 0, len(results))
+	for _, result := range results {
+		if result.Instance != nil {
+			outputs = append(outputs, rc.NewInstanceOutput(result.Instance, result.InstanceURL))
+		}
+	}
+	err := rc.writeOutput(outputs)
+	if err != nil {
+		logger.Println("Error writing the final job instances: ", err)
+	}
+
+	WriteWatchReport(emailMessage, results, rc.displayLocation())
+	rc.sendReport(logger, rc.Name+" -- "+WatchSummary(results), emailMessage, outputs)
+	return results
+}
+
+// WatchSummary returns a one line count of the final statuses, for report subjects.
+// The summary of a single job instance is its status.
+func WatchSummary(results []*WatchResult) string {
+	if len(results) == 1 {
+		return results[0].Status()
+	}
+	var statuses []string
+	counts := map[string]int{}
+	for _, result := range results {
+		status := result.Status()
+		if counts[status] == 0 {
+			statuses = append(statuses, status)
+		}
+		counts[status]++
+	}
+	parts := make([]string, 0, len(statuses))
+	for _, status := range statuses {
+		parts = append(parts, fmt.Sprintf("%v %v", counts[status], status))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// WriteWatchReport writes the final status and durations of each job instance, followed
+// by each job instance's log and XML representation. Times are shown in loc.
+func WriteWatchReport(w io.Writer, results []*WatchResult, loc *time.Location) {
+	fmt.Fprintf(w, "\n%v\n", WatchSummary(results))
+	for _, result := range results {
+		if result.Err != nil {
+			fmt.Fprintf(w, "%v: error, %v\n", result.ID(), result.Err)
+			continue
+		}
+		fmt.Fprintf(w, "%v: %v%v\n", result.ID(), result.Status(), durationsNote(NewInstanceTiming(result.Instance, loc)))
+	}
+	for _, result := range results {
+		fmt.Fprintf(w, "\n%v\n", result.InstanceURL)
+		_, _ = w.Write(result.Log.Bytes())
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright 2024 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cu-library/alma-api-job-runner/alma"
+)
+
+func TestParseInstanceArg(t *testing.T) {
+	const domain = "api-ca.hosted.exlibrisgroup.com"
+	const expected = "https://api-ca.hosted.exlibrisgroup.com/almaws/v1/conf/jobs/M47/instances/12345"
+	for _, arg := range []string{
+		expected,
+		"/almaws/v1/conf/jobs/M47/instances/12345",
+		"M47/12345",
+	} {
+		instanceURL, err := ParseInstanceArg(domain, arg)
+		if err != nil {
+			t.Errorf("%v: %v", arg, err)
+			continue
+		}
+		if instanceURL.String() != expected {
+			t.Errorf("%v: expected %v, got %v.", arg, expected, instanceURL)
+		}
+	}
+	for _, arg := range []string{"12345", "M47/", "M47/1/2"} {
+		_, err := ParseInstanceArg(domain, arg)
+		if !errors.Is(err, ErrInvalidInstance) {
+			t.Errorf("%v: expected ErrInvalidInstance, got %v.", arg, err)
+		}
+	}
+}
+
+func TestWatchReport(t *testing.T) {
+	result := func(id, status string, err error) *WatchResult {
+		instanceURL, _ := url.Parse("https://example.com/almaws/v1/conf/jobs/M47/instances/" + id)
+		r := &WatchResult{InstanceURL: instanceURL, Err: err, Log: bytes.NewBufferString("[" + id + "] log\n")}
+		if status != "" {
+			r.Instance = &alma.AlmaJobInstance{ID: id, Status: &alma.DescAndValue{Desc: status}}
+		}
+		return r
+	}
+	results := []*WatchResult{
+		result("1", "Completed Successfully", nil),
+		result("2", "", errors.New("polling failed")),
+		result("3", "Completed Successfully", nil),
+	}
+	if summary := WatchSummary(results); summary != "2 Completed Successfully, 1 error" {
+		t.Fatalf("Unexpected summary %q.", summary)
+	}
+	if summary := WatchSummary(results[:1]); summary != "Completed Successfully" {
+		t.Fatalf("Unexpected summary %q.", summary)
+	}
+	results[0].Instance.StartTime = "2024-01-08T10:00:05.000Z"
+	results[0].Instance.EndTime = "2024-01-08T10:02:05.000Z"
+	report := new(bytes.Buffer)
+	WriteWatchReport(report, results, time.UTC)
+	for _, expected := range []string{"1: Completed Successfully (ran 2m0s)\n", "3: Completed Successfully\n", "2: error, polling failed", "[3] log"} {
+		if !strings.Contains(report.String(), expected) {
+			t.Errorf("Expected %q in the report:\n%v", expected, report)
+		}
+	}
+}
@@ -0,0 +1,521 @@
+// Copyright 2024 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/cu-library/alma-api-job-runner/alma"
+)
+
+const (
+	// WorkflowSection is the name of the workflow file section which describes the workflow.
+	WorkflowSection = "workflow"
+
+	// StepsSectionPrefix is the prefix of workflow file sections which describe one step.
+	StepsSectionPrefix = "steps."
+)
+
+// ErrInvalidWorkflow is returned when a workflow file can't be parsed or isn't a valid graph.
+var ErrInvalidWorkflow = errors.New("invalid workflow")
+
+// Workflow is a graph of jobs, where each step runs after the steps it depends on finish.
+//
+// The workflow file uses the same TOML subset as the configuration file.
+// The optional [workflow] section names the workflow, and each [steps.<name>]
+// section describes one step. A step runs the job with the same name in the
+// configuration file, unless job is set. It runs after the steps listed in
+// after, only if they all finished with one of the statuses listed in when,
+// or successfully if when isn't set. Steps which run the same job must not
+// be able to run at the same time.
+//
+//	[workflow]
+//	name = "Nightly OCLC sync and export"
+//
+//	[steps.oclc-sync]
+//
+//	[steps.weekly-export]
+//	after = ["oclc-sync"]
+//	when = ["COMPLETED_SUCCESS"]
+type Workflow struct {
+	Name  string
+	Steps []*WorkflowStep
+}
+
+// WorkflowStep is one job in a workflow.
+type WorkflowStep struct {
+	Name  string
+	Job   string
+	After []string
+	// When lists the statuses the steps in After must finish with.
+	// If it's empty, they must finish successfully.
+	When []string
+}
+
+// accepts reports whether an upstream step which finished with status lets the step run.
+func (s *WorkflowStep) accepts(status string) bool {
+	if len(s.When) == 0 {
+		return alma.JobStatus(status).IsSuccess()
+	}
+	return containsString(s.When, status)
+}
+
+// requirement describes the statuses the steps in After must finish with, for skip reasons.
+func (s *WorkflowStep) requirement() string {
+	if len(s.When) == 0 {
+		return "a successful status"
+	}
+	return strings.Join(s.When, " or ")
+}
+
+// StepOutcome describes how a workflow step ended.
+type StepOutcome string
+
+const (
+	// StepCompleted means the job was submitted and monitored until it finished.
+	StepCompleted StepOutcome = "completed"
+
+	// StepFailed means the job couldn't be submitted or monitored.
+	StepFailed StepOutcome = "failed"
+
+	// StepSkipped means the step didn't run, because a step it depends on didn't finish as required.
+	StepSkipped StepOutcome = "skipped"
+)
+
+// StepResult stores the outcome of one workflow step.
+type StepResult struct {
+	Step     *WorkflowStep
+	Outcome  StepOutcome
+	Instance *alma.AlmaJobInstance
+	Err      error
+	// Reason explains why a step was skipped.
+	Reason string
+	// Log stores the log messages written while the step ran.
+	Log *bytes.Buffer
+}
+
+// Status returns the final status of the step's job instance, or an empty string.
+func (r *StepResult) Status() string {
+	if r.Instance == nil || r.Instance.Status == nil {
+		return ""
+	}
+	return r.Instance.Status.Value
+}
+
+// StepRunFunc runs the job for a workflow step, writing log messages to logOutput.
+type StepRunFunc func(ctx context.Context, step *WorkflowStep, logOutput io.Writer) (*alma.AlmaJobInstance, error)
+
+// LoadWorkflow reads and parses the workflow file at path.
+func LoadWorkflow(path string) (*Workflow, error) {
+	workflowFile, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer workflowFile.Close()
+	workflow, err := ParseWorkflow(workflowFile)
+	if err != nil {
+		return nil, err
+	}
+	if workflow.Name == "" {
+		workflow.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	return workflow, nil
+}
+
+// ParseWorkflow parses the contents of a workflow file, and checks
+// that the steps form a graph without cycles.
+func ParseWorkflow(r io.Reader) (*Workflow, error) {
+	sections, err := parseSections(r)
+	if err != nil {
+		return nil, err
+	}
+	workflow := &Workflow{}
+	steps := map[string]*WorkflowStep{}
+	for _, section := range sections {
+		switch {
+		case section.name == WorkflowSection:
+			for key, value := range section.settings {
+				if key != "name" {
+					return nil, fmt.Errorf("%w: line %v: unknown setting %q", ErrInvalidWorkflow, section.line, key)
+				}
+				workflow.Name = value
+			}
+		case strings.HasPrefix(section.name, StepsSectionPrefix):
+			step := &WorkflowStep{Name: strings.TrimPrefix(section.name, StepsSectionPrefix)}
+			for key, value := range section.settings {
+				switch key {
+				case "job":
+					step.Job = value
+				case "after":
+					step.After = splitList(value)
+				case "when":
+					step.When = splitList(value)
+					for _, status := range step.When {
+						if !alma.JobStatus(status).IsTerminal() {
+							return nil, fmt.Errorf("%w: line %v: %q isn't the status of a finished job instance", ErrInvalidWorkflow, section.line, status)
+						}
+					}
+				default:
+					return nil, fmt.Errorf("%w: line %v: unknown setting %q", ErrInvalidWorkflow, section.line, key)
+				}
+			}
+			if step.Job == "" {
+				step.Job = step.Name
+			}
+			steps[step.Name] = step
+			workflow.Steps = append(workflow.Steps, step)
+		default:
+			return nil, fmt.Errorf("%w: line %v: unknown section %q", ErrInvalidWorkflow, section.line, section.name)
+		}
+	}
+	if len(workflow.Steps) == 0 {
+		return nil, fmt.Errorf("%w: no steps", ErrInvalidWorkflow)
+	}
+	for _, step := range workflow.Steps {
+		for _, upstream := range step.After {
+			if _, ok := steps[upstream]; !ok {
+				return nil, fmt.Errorf("%w: step %q runs after unknown step %q", ErrInvalidWorkflow, step.Name, upstream)
+			}
+		}
+	}
+	err = checkForCycles(workflow.Steps, steps)
+	if err != nil {
+		return nil, err
+	}
+	err = checkForParallelJobs(workflow.Steps, steps)
+	if err != nil {
+		return nil, err
+	}
+	return workflow, nil
+}
+
+// checkForCycles returns an error if any step depends on itself, directly or indirectly.
+func checkForCycles(ordered []*WorkflowStep, steps map[string]*WorkflowStep) error {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := map[string]int{}
+	var visit func(step *WorkflowStep, path []string) error
+	visit = func(step *WorkflowStep, path []string) error {
+		path = append(path, step.Name)
+		switch state[step.Name] {
+		case visiting:
+			return fmt.Errorf("%w: cycle %v", ErrInvalidWorkflow, strings.Join(path, " -> "))
+		case visited:
+			return nil
+		}
+		state[step.Name] = visiting
+		for _, upstream := range step.After {
+			err := visit(steps[upstream], path)
+			if err != nil {
+				return err
+			}
+		}
+		state[step.Name] = visited
+		return nil
+	}
+	for _, step := range ordered {
+		if state[step.Name] == unvisited {
+			err := visit(step, nil)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkForParallelJobs returns an error if two steps which run the same job can run
+// at the same time, because neither runs after the other. Runs of the same job share
+// a lock, so one of the steps would wait for or skip the other. The graph must not have cycles.
+func checkForParallelJobs(ordered []*WorkflowStep, steps map[string]*WorkflowStep) error {
+	ancestors := map[string]map[string]bool{}
+	var findAncestors func(step *WorkflowStep) map[string]bool
+	findAncestors = func(step *WorkflowStep) map[string]bool {
+		if found, ok := ancestors[step.Name]; ok {
+			return found
+		}
+		found := map[string]bool{}
+		for _, upstream := range step.After {
+			found[upstream] = true
+			for name := range findAncestors(steps[upstream]) {
+				found[name] = true
+			}
+		}
+		ancestors[step.Name] = found
+		return found
+	}
+	for i, step := range ordered {
+		for _, other := range ordered[i+1:] {
+			if step.Job != other.Job {
+				continue
+			}
+			if !findAncestors(step)[other.Name] && !findAncestors(other)[step.Name] {
+				return fmt.Errorf("%w: steps %q and %q both run job %q and can run at the same time, one must run after the other",
+					ErrInvalidWorkflow, step.Name, other.Name, step.Job)
+			}
+		}
+	}
+	return nil
+}
+
+// Execute runs the workflow's steps. Each step starts as soon as the steps it
+// depends on have finished, so independent branches run in parallel. A step
+// whose dependencies didn't finish with a required status is skipped, which
+// in turn skips the steps which depend on it. The results are returned in the
+// order the steps appear in the workflow.
+func (w *Workflow) Execute(ctx context.Context, logger *log.Logger, run StepRunFunc) []*StepResult {
+	results := map[string]*StepResult{}
+	done := map[string]chan struct{}{}
+	for _, step := range w.Steps {
+		results[step.Name] = &StepResult{Step: step, Log: new(bytes.Buffer)}
+		done[step.Name] = make(chan struct{})
+	}
+
+	wg := new(sync.WaitGroup)
+	for _, step := range w.Steps {
+		step := step
+		result := results[step.Name]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer close(done[step.Name])
+			// Wait for the steps this step depends on.
+			for _, upstream := range step.After {
+				<-done[upstream]
+			}
+			result.Reason = skipReason(ctx, step, results)
+			if result.Reason != "" {
+				result.Outcome = StepSkipped
+				logger.Printf("%v: skipped, %v.\n", step.Name, result.Reason)
+				return
+			}
+			logger.Printf("%v: starting job %v.\n", step.Name, step.Job)
+			result.Instance, result.Err = run(ctx, step, result.Log)
+			if errors.Is(result.Err, ErrJobSkipped) {
+				result.Outcome = StepSkipped
+				result.Reason = result.Err.Error()
+				logger.Printf("%v: skipped, %v.\n", step.Name, result.Reason)
+				return
+			}
+			if result.Err != nil {
+				result.Outcome = StepFailed
+				logger.Printf("%v: failed, %v.\n", step.Name, result.Err)
+				return
+			}
+			result.Outcome = StepCompleted
+			logger.Printf("%v: finished with status %v.\n", step.Name, result.Status())
+		}()
+	}
+	wg.Wait()
+
+	ordered := make([]*StepResult, 0, len(w.Steps))
+	for _, step := range w.Steps {
+		ordered = append(ordered, results[step.Name])
+	}
+	return ordered
+}
+
+// skipReason explains why a step can't run, or returns an empty string if it can.
+// The results of the step's dependencies must be final.
+func skipReason(ctx context.Context, step *WorkflowStep, results map[string]*StepResult) string {
+	if ctx.Err() != nil {
+		return "the workflow was cancelled"
+	}
+	for _, upstream := range step.After {
+		result := results[upstream]
+		switch result.Outcome {
+		case StepSkipped:
+			return fmt.Sprintf("step %v was skipped", upstream)
+		case StepFailed:
+			return fmt.Sprintf("step %v failed: %v", upstream, result.Err)
+		case StepCompleted:
+			if !step.accepts(result.Status()) {
+				return fmt.Sprintf("step %v finished with status %v, but %v required %v", upstream, result.Status(), step.Name, step.requirement())
+			}
+		}
+	}
+	return ""
+}
+
+// WorkflowSucceeded reports whether every step completed successfully.
+func WorkflowSucceeded(results []*StepResult) bool {
+	for _, result := range results {
+		if result.Outcome != StepCompleted || !alma.JobStatus(result.Status()).IsSuccess() {
+			return false
+		}
+	}
+	return true
+}
+
+// ExitCode returns the exit code for the step's outcome. Steps skipped because of
+// the steps they depend on don't add to the exit code, the steps they depend on do.
+func (r *StepResult) ExitCode(failOnWarning bool) int {
+	if r.Outcome == StepSkipped && r.Err == nil {
+		return ExitSuccess
+	}
+	return ExitCode(r.Instance, r.Err, failOnWarning)
+}
+
+// WorkflowSummary returns a one line count of the step outcomes, for report subjects.
+func WorkflowSummary(results []*StepResult) string {
+	counts := map[StepOutcome]int{}
+	for _, result := range results {
+		counts[result.Outcome]++
+	}
+	return fmt.Sprintf("%v completed, %v failed, %v skipped", counts[StepCompleted], counts[StepFailed], counts[StepSkipped])
+}
+
+// WriteWorkflowReport writes a summary of each step, followed by each step's log.
+// Times are shown in loc.
+func WriteWorkflowReport(w io.Writer, workflowName string, results []*StepResult, loc *time.Location) {
+	fmt.Fprintf(w, "Workflow: %v\n", workflowName)
+	fmt.Fprintf(w, "%v\n\n", WorkflowSummary(results))
+	for _, result := range results {
+		switch result.Outcome {
+		case StepCompleted:
+			fmt.Fprintf(w, "%v (%v): completed, status %v%v\n", result.Step.Name, result.Step.Job, result.Status(), durationsNote(NewInstanceTiming(result.Instance, loc)))
+		case StepFailed:
+			fmt.Fprintf(w, "%v (%v): failed, %v\n", result.Step.Name, result.Step.Job, result.Err)
+		case StepSkipped:
+			fmt.Fprintf(w, "%v (%v): skipped, %v\n", result.Step.Name, result.Step.Job, result.Reason)
+		}
+	}
+	for _, result := range results {
+		if result.Log.Len() == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "\n--- %v ---\n", result.Step.Name)
+		_, _ = w.Write(result.Log.Bytes())
+	}
+}
+
+// workflowCommand runs the steps of a workflow file, then sends one report.
+func workflowCommand(args []string) {
+	rc := &RunConfig{}
+	fs := newFlagSet("workflow", "Run a graph of jobs, where each job runs after the jobs it depends on succeed.")
+	rc.RegisterFlags(fs)
+	configPath := fs.String("config", "", "A configuration file storing default and per-job settings. Required.")
+	workflowPath := fs.String("workflow", "", "A workflow file describing the steps to run. Required.")
+	parseFlags(fs, args)
+
+	if *configPath == "" {
+		exitConfigError("FATAL: A configuration file is required for the workflow command.")
+	}
+	if *workflowPath == "" {
+		exitConfigError("FATAL: A workflow file is required for the workflow command.")
+	}
+	config, err := LoadConfig(*configPath)
+	if err != nil {
+		exitConfigError("FATAL: Error loading configuration file:", err)
+	}
+	workflow, err := LoadWorkflow(*workflowPath)
+	if err != nil {
+		exitConfigError("FATAL: Error loading workflow file:", err)
+	}
+
+	// Build and check the run configuration of each step before anything runs.
+	// Steps don't send their own reports or write their own final job instances.
+	stepConfigs := map[string]*RunConfig{}
+	for _, step := range workflow.Steps {
+		stepConfig, err := jobRunConfig(fs, config, step.Job)
+		if err != nil {
+			exitConfigError(fmt.Sprintf("FATAL: Step %v: %v", step.Name, err))
+		}
+		stepConfig.SendEmail = false
+		stepConfig.skipOutput = true
+		err = stepConfig.Validate()
+		if err != nil {
+			exitConfigError(fmt.Sprintf("FATAL: Step %v: %v", step.Name, err))
+		}
+		stepConfigs[step.Name] = stepConfig
+	}
+
+	// The report uses the settings from the command line, the environment and the defaults.
+	err = configureFlagSet(fs, config, "")
+	if err != nil {
+		exitConfigError("FATAL:", err)
+	}
+	if !isFlagSet(fs, "name") {
+		rc.Name = workflow.Name
+	}
+	if rc.SendEmail {
+		err := rc.ValidateEmail()
+		if err != nil {
+			exitConfigError("FATAL:", err)
+		}
+	}
+
+	// Cancel any in-progress requests when the process is interrupted.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	log.Printf("Running workflow %v with %v steps.\n", workflow.Name, len(workflow.Steps))
+	results := workflow.Execute(ctx, log.Default(), func(ctx context.Context, step *WorkflowStep, logOutput io.Writer) (*alma.AlmaJobInstance, error) {
+		return stepConfigs[step.Name].Run(ctx, io.MultiWriter(os.Stderr, logOutput), "["+step.Name+"] ")
+	})
+
+	report := new(bytes.Buffer)
+	WriteWorkflowReport(report, workflow.Name, results, rc.displayLocation())
+	fmt.Fprint(os.Stderr, report.String())
+
+	// The final job instances of the steps are written together once every step
+	// has finished, and are attached to the email report.
+	var outputs []*InstanceOutput
+	for _, result := range results {
+		if result.Instance != nil {
+			outputs = append(outputs, stepConfigs[result.Step.Name].NewInstanceOutput(result.Instance, nil))
+		}
+	}
+	err = rc.writeOutput(outputs)
+	if err != nil {
+		log.Println("Error writing the final job instances: ", err)
+	}
+	rc.sendReport(log.Default(), fmt.Sprintf("%v -- %v", rc.Name, WorkflowSummary(results)), report, outputs)
+
+	code := ExitSuccess
+	for _, result := range results {
+		code = MaxExitCode(code, result.ExitCode(stepConfigs[result.Step.Name].FailOnWarning))
+	}
+	if code != ExitSuccess {
+		stop()
+		os.Exit(code)
+	}
+}
+
+// splitList splits a comma delimited setting, trimming spaces and dropping empty elements.
+func splitList(value string) []string {
+	var list []string
+	for _, element := range TrimSpaceAll(strings.Split(value, ",")) {
+		if element != "" {
+			list = append(list, element)
+		}
+	}
+	return list
+}
+
+// containsString reports whether list contains s.
+func containsString(list []string, s string) bool {
+	for _, element := range list {
+		if element == s {
+			return true
+		}
+	}
+	return false
+}
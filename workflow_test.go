@@ -0,0 +1,142 @@
+// Copyright 2024 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/cu-library/alma-api-job-runner/alma"
+)
+
+func TestParseWorkflowErrors(t *testing.T) {
+	tests := map[string]string{
+		"no steps":        "[workflow]\nname = \"empty\"\n",
+		"unknown step":    "[steps.a]\nafter = [\"b\"]\n",
+		"cycle":           "[steps.a]\nafter = [\"c\"]\n[steps.b]\nafter = [\"a\"]\n[steps.c]\nafter = [\"b\"]\n",
+		"self cycle":      "[steps.a]\nafter = [\"a\"]\n",
+		"unknown key":     "[steps.a]\nbefore = [\"b\"]\n",
+		"unknown section": "[jobs.a]\n",
+		"unknown status":  "[steps.a]\n[steps.b]\nafter = [\"a\"]\nwhen = [\"COMPLETED_SUCESS\"]\n",
+		"active status":   "[steps.a]\n[steps.b]\nafter = [\"a\"]\nwhen = [\"RUNNING\"]\n",
+		"parallel job":    "[steps.export]\n[steps.again]\njob = \"export\"\n",
+		"parallel branch": "[steps.sync]\n[steps.a]\njob = \"export\"\nafter = [\"sync\"]\n[steps.b]\njob = \"export\"\nafter = [\"sync\"]\n",
+	}
+	for name, content := range tests {
+		_, err := ParseWorkflow(strings.NewReader(content))
+		if !errors.Is(err, ErrInvalidWorkflow) {
+			t.Errorf("%v: expected ErrInvalidWorkflow, got %v.", name, err)
+		}
+	}
+}
+
+func TestParseWorkflowSequentialJob(t *testing.T) {
+	// A job can be run more than once, as long as its runs can't overlap.
+	_, err := ParseWorkflow(strings.NewReader(`[steps.export]
+
+[steps.sync]
+after = ["export"]
+
+[steps.export-again]
+job = "export"
+after = ["sync"]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWorkflowExecute(t *testing.T) {
+	workflow, err := ParseWorkflow(strings.NewReader(`[workflow]
+name = "Nightly"
+
+[steps.oclc-sync]
+
+[steps.export]
+job = "weekly-export"
+after = ["oclc-sync"]
+
+[steps.tagging]
+
+[steps.after-tagging]
+after = ["tagging"]
+
+[steps.after-after-tagging]
+after = ["after-tagging"]
+
+[steps.cleanup]
+after = ["oclc-sync", "tagging"]
+when = ["COMPLETED_SUCCESS", "COMPLETED_FAILED"]
+
+[steps.on-warning]
+after = ["oclc-sync"]
+when = ["COMPLETED_WARNING"]
+
+[steps.nothing-to-do]
+
+[steps.after-nothing-to-do]
+after = ["nothing-to-do"]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	run := func(ctx context.Context, step *WorkflowStep, logOutput io.Writer) (*alma.AlmaJobInstance, error) {
+		fmt.Fprintf(logOutput, "running %v\n", step.Job)
+		switch step.Name {
+		case "tagging":
+			return nil, fmt.Errorf("%w: submit failed", alma.ErrAPIError)
+		case "nothing-to-do":
+			return &alma.AlmaJobInstance{Status: &alma.DescAndValue{Value: "COMPLETED_NO_BULKS"}}, nil
+		default:
+			return &alma.AlmaJobInstance{Status: &alma.DescAndValue{Value: "COMPLETED_SUCCESS"}}, nil
+		}
+	}
+	results := workflow.Execute(context.Background(), log.New(io.Discard, "", 0), run)
+
+	expected := map[string]StepOutcome{
+		"oclc-sync":           StepCompleted,
+		"export":              StepCompleted,
+		"tagging":             StepFailed,
+		"after-tagging":       StepSkipped,
+		"after-after-tagging": StepSkipped,
+		"cleanup":             StepSkipped,
+		"on-warning":          StepSkipped,
+		"nothing-to-do":       StepCompleted,
+		"after-nothing-to-do": StepCompleted,
+	}
+	for _, result := range results {
+		if result.Outcome != expected[result.Step.Name] {
+			t.Errorf("%v: expected %v, got %v (%v).", result.Step.Name, expected[result.Step.Name], result.Outcome, result.Reason)
+		}
+	}
+	if !strings.Contains(results[4].Reason, "after-tagging was skipped") {
+		t.Errorf("Unexpected reason %q.", results[4].Reason)
+	}
+	if !strings.Contains(results[6].Reason, "COMPLETED_SUCCESS") {
+		t.Errorf("Unexpected reason %q.", results[6].Reason)
+	}
+	if results[1].Log.String() != "running weekly-export\n" {
+		t.Errorf("Unexpected log %q.", results[1].Log.String())
+	}
+	if WorkflowSucceeded(results) {
+		t.Error("Expected the workflow to fail.")
+	}
+	code := ExitSuccess
+	for _, result := range results {
+		code = MaxExitCode(code, result.ExitCode(true))
+	}
+	if code != ExitFailure {
+		t.Errorf("Expected the exit code of the failed step, got %v.", code)
+	}
+	if WorkflowSummary(results) != "4 completed, 1 failed, 4 skipped" {
+		t.Errorf("Unexpected summary %q.", WorkflowSummary(results))
+	}
+}